@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	acceleratorKspeeder = "kspeeder" // kspeeder, downloaded and started by DockRoot
+	acceleratorExternal = "external" // any pull-through cache, optionally started and stopped by commands
+
+	defaultHealthPath = "/v2/"
+)
+
+// acceleratorInfo is the accelerator section of dockroot.json.
+type acceleratorInfo struct {
+	Kind         string   `json:"kind,omitempty"`          // acceleratorKspeeder or acceleratorExternal, default acceleratorKspeeder
+	Endpoint     string   `json:"endpoint,omitempty"`      // HOST[:PORT] of the registry the accelerator serves
+	HealthPath   string   `json:"health-path,omitempty"`   // Path on the endpoint which answers when the accelerator is up
+	Insecure     bool     `json:"insecure,omitempty"`      // The endpoint speaks plain HTTP or has an untrusted certificate
+	StartCommand []string `json:"start-command,omitempty"` // external only: command which starts the accelerator
+	StopCommand  []string `json:"stop-command,omitempty"`  // external only: command which stops the accelerator
+}
+
+// accelerator is a local pull-through cache which Docker Hub images are fetched through before trying any mirror.
+type accelerator interface {
+	// mirror returns the registry the accelerator serves.
+	mirror() *registryMirror
+	// start starts the accelerator and returns once it is healthy.
+	start() error
+	// healthy returns true if the accelerator is up and serving.
+	healthy() bool
+	// stop stops the accelerator.
+	stop() error
+}
+
+func defaultAcceleratorInfo() *acceleratorInfo {
+	return &acceleratorInfo{
+		Kind:       acceleratorKspeeder,
+		Endpoint:   defaultHubMirror,
+		HealthPath: defaultHealthPath,
+	}
+}
+
+// acceleratorInfo returns the accelerator settings, with defaults filled in.
+func (info *registryInfo) acceleratorInfo() *acceleratorInfo {
+	if info.Accelerator == nil {
+		return defaultAcceleratorInfo()
+	}
+	conf := *info.Accelerator
+	if conf.Kind == "" {
+		conf.Kind = acceleratorKspeeder
+	}
+	if conf.Endpoint == "" {
+		conf.Endpoint = defaultHubMirror
+	}
+	if conf.HealthPath == "" {
+		conf.HealthPath = defaultHealthPath
+	}
+	return &conf
+}
+
+// accelerator returns the configured accelerator, or nil if useKspeeder is false.
+func (info *registryInfo) accelerator(binaryDir string) (accelerator, error) {
+	if !info.UseKspeeder {
+		return nil, nil
+	}
+	conf := info.acceleratorInfo()
+	switch conf.Kind {
+	case acceleratorKspeeder:
+		return newKspeederAccelerator(binaryDir, info), nil
+	case acceleratorExternal:
+		return &externalAccelerator{registryAccelerator: registryAccelerator{conf: conf}}, nil
+	default:
+		return nil, fmt.Errorf("Unknown accelerator kind %q, expected %q or %q", conf.Kind, acceleratorKspeeder, acceleratorExternal)
+	}
+}
+
+// ensureAccelerator starts acc unless it is already healthy.
+func ensureAccelerator(acc accelerator) error {
+	if acc.healthy() {
+		return nil
+	}
+	return acc.start()
+}
+
+// registryAccelerator implements the parts of accelerator common to everything serving the registry API.
+type registryAccelerator struct {
+	conf *acceleratorInfo
+}
+
+func (acc *registryAccelerator) mirror() *registryMirror {
+	return &registryMirror{host: acc.conf.Endpoint, insecure: acc.conf.Insecure}
+}
+
+// healthURL returns the URL checked by healthy.
+func (acc *registryAccelerator) healthURL() string {
+	scheme := "https"
+	if acc.conf.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/%s", scheme, acc.conf.Endpoint, strings.TrimPrefix(acc.conf.HealthPath, "/"))
+}
+
+func (acc *registryAccelerator) client() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if acc.conf.Insecure {
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	return &http.Client{Transport: transport}
+}
+
+// healthy returns true if the health path answers with a non-error status.
+func (acc *registryAccelerator) healthy() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, acc.healthURL(), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := acc.client().Do(req)
+	if err != nil {
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
+
+// kspeederAccelerator runs kspeeder next to the DockRoot binary.
+type kspeederAccelerator struct {
+	registryAccelerator
+	binaryPath string
+	dep        *dependencyInfo
+	cachePath  string
+	pidPath    string // Records the PID of a kspeeder started by DockRoot
+	logPath    string // Receives the output of a kspeeder started by DockRoot
+}
+
+// newKspeederAccelerator returns the kspeeder accelerator for info, using its accelerator settings if they are for kspeeder.
+func newKspeederAccelerator(binaryDir string, info *registryInfo) *kspeederAccelerator {
+	conf := info.acceleratorInfo()
+	if conf.Kind != acceleratorKspeeder {
+		conf = defaultAcceleratorInfo()
+	}
+	return &kspeederAccelerator{
+		registryAccelerator: registryAccelerator{conf: conf},
+		binaryPath:          filepath.Join(binaryDir, "kspeeder"),
+		dep:                 info.dependency(dependencyKspeeder),
+		cachePath:           filepath.Join(info.DataRoot, "cache"),
+		pidPath:             filepath.Join(info.DataRoot, "kspeeder.pid"),
+		logPath:             filepath.Join(info.DataRoot, "kspeeder.log"),
+	}
+}
+
+func (acc *kspeederAccelerator) healthy() bool {
+	return checkIsKspeederRun(acc.client(), acc.healthURL())
+}
+
+// start starts kspeeder for pulls; it exits by itself after an hour.
+func (acc *kspeederAccelerator) start() error {
+	return acc.startFor(1)
+}
+
+// startFor starts kspeeder, downloading it if necessary. If exitAfterHours is positive, kspeeder exits by itself after that many hours.
+func (acc *kspeederAccelerator) startFor(exitAfterHours int) error {
+	client := acc.client()
+	if err := checkAndDownloadKspeeder(acc.binaryPath, acc.dep, &http.Client{}); err != nil {
+		return err
+	}
+	_, err := runKspeeder(acc.binaryPath, acc.cachePath, acc.pidPath, acc.logPath, acc.healthURL(), exitAfterHours, client)
+	return err
+}
+
+func (acc *kspeederAccelerator) stop() error {
+	return stopKspeeder(acc.pidPath)
+}
+
+// externalAccelerator is a self-hosted pull-through cache, managed by the configured commands if any.
+type externalAccelerator struct {
+	registryAccelerator
+}
+
+func (acc *externalAccelerator) start() error {
+	if len(acc.conf.StartCommand) == 0 {
+		return fmt.Errorf("accelerator %s is not healthy and has no start-command", acc.conf.Endpoint)
+	}
+	if err := runAcceleratorCommand(acc.conf.StartCommand); err != nil {
+		return fmt.Errorf("starting accelerator: %w", err)
+	}
+	return waitForReady("accelerator "+acc.conf.Endpoint, acc.healthy, acceleratorReadyTimeout, nil)
+}
+
+func (acc *externalAccelerator) stop() error {
+	if len(acc.conf.StopCommand) == 0 {
+		return nil
+	}
+	if err := runAcceleratorCommand(acc.conf.StopCommand); err != nil {
+		return fmt.Errorf("stopping accelerator: %w", err)
+	}
+	return nil
+}
+
+func runAcceleratorCommand(args []string) error {
+	logrus.Debugf("Running %s", strings.Join(args, " "))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryInfoAccelerator(t *testing.T) {
+	// useKspeeder: false disables the accelerator altogether.
+	var info registryInfo
+	require.NoError(t, json.Unmarshal([]byte(`{"data-root": "/srv", "useKspeeder": false}`), &info))
+	acc, err := info.accelerator("/bin")
+	require.NoError(t, err)
+	assert.Nil(t, acc)
+
+	// Old configuration files without an accelerator section keep using kspeeder.
+	info = registryInfo{}
+	require.NoError(t, json.Unmarshal([]byte(`{"data-root": "/srv", "useKspeeder": true}`), &info))
+	acc, err = info.accelerator("/bin")
+	require.NoError(t, err)
+	k, ok := acc.(*kspeederAccelerator)
+	require.True(t, ok)
+	assert.Equal(t, "/bin/kspeeder", k.binaryPath)
+	assert.Equal(t, "/srv/cache", k.cachePath)
+	assert.Equal(t, &registryMirror{host: defaultHubMirror}, acc.mirror())
+	assert.Equal(t, "https://"+defaultHubMirror+"/v2/", k.healthURL())
+
+	info = registryInfo{}
+	require.NoError(t, json.Unmarshal([]byte(`{"useKspeeder": true, "accelerator": {"kind": "external", "endpoint": "cache.lan:5000", "health-path": "/healthz", "insecure": true}}`), &info))
+	acc, err = info.accelerator("/bin")
+	require.NoError(t, err)
+	e, ok := acc.(*externalAccelerator)
+	require.True(t, ok)
+	assert.Equal(t, &registryMirror{host: "cache.lan:5000", insecure: true}, acc.mirror())
+	assert.Equal(t, "http://cache.lan:5000/healthz", e.healthURL())
+
+	info = registryInfo{UseKspeeder: true, Accelerator: &acceleratorInfo{Kind: "nope"}}
+	_, err = info.accelerator("/bin")
+	assert.Error(t, err)
+}
+
+func TestExternalAccelerator(t *testing.T) {
+	up := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up || r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	info := registryInfo{UseKspeeder: true, Accelerator: &acceleratorInfo{
+		Kind:       acceleratorExternal,
+		Endpoint:   strings.TrimPrefix(server.URL, "http://"),
+		HealthPath: "/healthz",
+		Insecure:   true,
+	}}
+	acc, err := info.accelerator("/bin")
+	require.NoError(t, err)
+	assert.False(t, acc.healthy())
+	// Without a start-command there is nothing we can do about it.
+	assert.Error(t, ensureAccelerator(acc))
+
+	info.Accelerator.StartCommand = []string{"true"}
+	acc, err = info.accelerator("/bin")
+	require.NoError(t, err)
+	up = true
+	assert.NoError(t, ensureAccelerator(acc))
+	assert.True(t, acc.healthy())
+	assert.NoError(t, acc.stop())
+
+	info.Accelerator.StopCommand = []string{"false"}
+	acc, err = info.accelerator("/bin")
+	require.NoError(t, err)
+	assert.Error(t, acc.stop())
+}
@@ -0,0 +1,832 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/idtools"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/moby/sys/user"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/mutate"
+	"github.com/opencontainers/umoci/oci/casext"
+	"github.com/opencontainers/umoci/pkg/funchelpers"
+	"github.com/opencontainers/umoci/pkg/mtreefilter"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// buildCacheName is the name the steps of build are kept under in the image store, tagged with the hash of the
+// step and the image it ran on. Store names always start with a registry domain, so no image can be named like it.
+const buildCacheName = "build-cache"
+
+func isBuildCacheRef(refName string) bool {
+	return strings.HasPrefix(refName, buildCacheName+":")
+}
+
+type buildOptions struct {
+	global  *globalOptions
+	file    string
+	tag     string
+	noCache bool
+}
+
+func buildCmd(global *globalOptions) *cobra.Command {
+	opts := buildOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "build -t IMAGE[:TAG] [-f Dockerfile] DIR",
+		Short: "build an image in the image store from a Dockerfile",
+		Long: `Build an image in the image store from a Dockerfile, with DIR as the build context.
+
+The image named by FROM must be in the image store. ` + strings.Join(dockerfileCommands, ", ") + ` are supported,
+without multi-stage builds. RUN steps run with ruri in a temporary container.
+
+Each step adds a layer, or only changes the image config, and is cached in the image store
+by the hash of the instruction, the files it copies and the image it runs on.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot pull alpine:latest
+DockRoot build -t myapp:1.0 .
+DockRoot build -f docker/Dockerfile.arm -t myapp:arm --no-cache src`,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.file, "file", "f", "", "Path to the Dockerfile, default DIR/Dockerfile")
+	flags.StringVarP(&opts.tag, "tag", "t", "", "Name the image `IMAGE[:TAG]`")
+	flags.BoolVar(&opts.noCache, "no-cache", false, "Do not use the cache when building the image")
+	return cmd
+}
+
+func (opts *buildOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 || opts.tag == "" {
+		return fmt.Errorf("Usage: %s build -t IMAGE[:TAG] [-f Dockerfile] DIR", os.Args[0])
+	}
+	ref, err := parseImageReference(opts.tag)
+	if err != nil {
+		return err
+	}
+	if ref.digest() != "" {
+		return fmt.Errorf("Cannot build %s, which is pinned to a digest", opts.tag)
+	}
+	contextDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	file := opts.file
+	if file == "" {
+		file = filepath.Join(contextDir, "Dockerfile")
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	instructions, err := parseDockerfile(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if slices.ContainsFunc(instructions, func(inst *dockerfileInstruction) bool { return inst.command == "RUN" }) {
+		if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+			return err
+		}
+	}
+
+	b := &imageBuilder{
+		dataRoot:   info.DataRoot,
+		contextDir: contextDir,
+		ruriPath:   ruriPath,
+		etc:        info.etcConfig(),
+		noCache:    opts.noCache,
+		stdout:     stdout,
+	}
+	defer b.cleanup()
+	manifestDigest, err := b.build(instructions, ref.storeName())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %s\n", ref.storeName(), manifestDigest)
+	return nil
+}
+
+// imageBuilder runs the instructions of a Dockerfile, each on top of the image the previous one built.
+type imageBuilder struct {
+	dataRoot   string
+	contextDir string
+	ruriPath   string
+	etc        etcConfig
+	noCache    bool
+	stdout     io.Writer
+
+	ref    string                // Reference name of the image built so far in the store
+	image  digest.Digest         // Its manifest digest
+	config imgspecv1.ImageConfig // Its config
+	cmdSet bool                  // CMD was given in the Dockerfile, so ENTRYPOINT keeps it
+	bundle string                // Bundle the image is unpacked to, once a step which changes files misses the cache
+}
+
+// build runs instructions and names the resulting image refName in the store. It returns the manifest digest.
+func (b *imageBuilder) build(instructions []*dockerfileInstruction, refName string) (string, error) {
+	if instructions[0].command != "FROM" {
+		return "", fmt.Errorf("Dockerfile line %d: the first instruction must be FROM", instructions[0].line)
+	}
+	for i, inst := range instructions {
+		fmt.Fprintf(b.stdout, "Step %d/%d : %s\n", i+1, len(instructions), inst)
+		var err error
+		if inst.command == "FROM" {
+			if i > 0 {
+				return "", fmt.Errorf("Dockerfile line %d: multi-stage builds are not supported", inst.line)
+			}
+			err = b.from(inst)
+		} else {
+			err = b.step(inst)
+		}
+		if err != nil {
+			return "", fmt.Errorf("Dockerfile line %d: %w", inst.line, err)
+		}
+		if inst.command == "CMD" {
+			b.cmdSet = true
+		}
+		fmt.Fprintf(b.stdout, " ---> %s\n", b.image.Encoded()[:12])
+	}
+	err := b.withStore(true, func(engineExt casext.Engine) error {
+		ctx := context.Background()
+		desc, err := resolveStoreReference(ctx, engineExt, b.ref)
+		if err != nil {
+			return err
+		}
+		return engineExt.UpdateReference(ctx, refName, desc)
+	})
+	if err != nil {
+		return "", err
+	}
+	unlock, err := lockImageStore(b.dataRoot, true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+	manifestDigest, err := tagImageDigest(imageStoreDir(b.dataRoot), refName)
+	if err != nil {
+		return "", err
+	}
+	return manifestDigest.String(), nil
+}
+
+// withStore calls fn with the image store, locked exclusively to change it.
+func (b *imageBuilder) withStore(exclusive bool, fn func(engineExt casext.Engine) error) (Err error) {
+	unlock, err := lockImageStore(b.dataRoot, exclusive)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	engineExt, err := openImageStore(imageStoreDir(b.dataRoot))
+	if err != nil {
+		return err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	return fn(engineExt)
+}
+
+// load makes the image named refName in the store, with manifestDigest, the image built so far.
+func (b *imageBuilder) load(engineExt casext.Engine, refName string, manifestDigest digest.Digest) error {
+	img, err := readImageConfig(engineExt, refName)
+	if err != nil {
+		return err
+	}
+	b.ref, b.image, b.config = refName, manifestDigest, img.Config
+	return nil
+}
+
+func (b *imageBuilder) from(inst *dockerfileInstruction) error {
+	if _, _, err := inst.flag(""); err != nil {
+		return err
+	}
+	words, err := shellWords(inst.args, func(string) (string, bool) { return "", false })
+	if err != nil {
+		return err
+	}
+	if len(words) != 1 && (len(words) != 3 || !strings.EqualFold(words[1], "AS")) {
+		return fmt.Errorf("FROM requires one image")
+	}
+	if words[0] == "scratch" {
+		return fmt.Errorf("FROM scratch is not supported, start from an image in the image store")
+	}
+	storeDir := imageStoreDir(b.dataRoot)
+	return b.withStore(false, func(engineExt casext.Engine) error {
+		img, err := resolveStoreImage(storeDir, words[0])
+		if err != nil {
+			return err
+		}
+		return b.load(engineExt, img.Name, digest.Digest(img.Digest))
+	})
+}
+
+// step runs inst on the image built so far, unless the cache holds its result.
+func (b *imageBuilder) step(inst *dockerfileInstruction) error {
+	key, err := b.cacheKey(inst)
+	if err != nil {
+		return err
+	}
+	cacheRef := buildCacheName + ":" + key.Encoded()
+	if !b.noCache {
+		hit := false
+		err := b.withStore(false, func(engineExt casext.Engine) error {
+			paths, err := engineExt.ResolveReference(context.Background(), cacheRef)
+			if err != nil || len(paths) != 1 {
+				return err
+			}
+			hit = true
+			return b.load(engineExt, cacheRef, paths[0].Descriptor().Digest)
+		})
+		if err != nil {
+			return err
+		}
+		if hit {
+			fmt.Fprintln(b.stdout, " ---> Using cache")
+			return nil
+		}
+	}
+
+	history := &imgspecv1.History{CreatedBy: inst.String()}
+	switch inst.command {
+	case "RUN", "COPY", "ADD":
+		if err := b.unpack(); err != nil {
+			return err
+		}
+		if inst.command == "RUN" {
+			err = b.run(inst)
+		} else {
+			err = b.copy(inst)
+		}
+		if err != nil {
+			return err
+		}
+		return b.commit(cacheRef, b.config, history, true)
+	default:
+		config, err := b.configStep(inst)
+		if err != nil {
+			return err
+		}
+		return b.commit(cacheRef, config, history, false)
+	}
+}
+
+// cacheKey returns the hash of inst on the image built so far. For COPY and ADD it covers the files copied from the
+// build context too; ADD from a URL is only keyed by the URL.
+func (b *imageBuilder) cacheKey(inst *dockerfileInstruction) (digest.Digest, error) {
+	digester := digest.Canonical.Digester()
+	h := digester.Hash()
+	fmt.Fprintf(h, "%s\n%s\n", b.image, inst)
+	if inst.command == "COPY" || inst.command == "ADD" {
+		srcs, _, err := b.copyArgs(inst)
+		if err != nil {
+			return "", err
+		}
+		for _, src := range srcs {
+			if inst.command == "ADD" && isURL(src) {
+				continue
+			}
+			matches, err := b.contextMatches(src)
+			if err != nil {
+				return "", err
+			}
+			for _, match := range matches {
+				if err := hashContextPath(h, match); err != nil {
+					return "", err
+				}
+			}
+		}
+	}
+	return digester.Digest(), nil
+}
+
+// hashContextPath writes the names, modes and contents of the files under p to w.
+func hashContextPath(w io.Writer, p string) error {
+	return filepath.Walk(p, func(name string, st os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(filepath.Dir(p), name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s %o %d\n", rel, st.Mode(), st.Size())
+		switch {
+		case st.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(name)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(w, target)
+		case st.Mode().IsRegular():
+			f, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			if _, err := io.Copy(w, f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// commit records the changes of inst as an image on top of the image built so far, named cacheRef, with config.
+// With layer, the changes to the rootfs of the bundle are added as a layer.
+func (b *imageBuilder) commit(cacheRef string, config imgspecv1.ImageConfig, history *imgspecv1.History, layer bool) error {
+	return b.withStore(true, func(engineExt casext.Engine) error {
+		ctx := context.Background()
+		paths, err := engineExt.ResolveReference(ctx, b.ref)
+		if err != nil {
+			return err
+		}
+		if len(paths) != 1 {
+			return fmt.Errorf("tag is not found or ambiguous: %s", b.ref)
+		}
+		mutator, err := mutate.New(engineExt, paths[0])
+		if err != nil {
+			return err
+		}
+		imageMeta, err := mutator.Meta(ctx)
+		if err != nil {
+			return err
+		}
+		annotations, err := mutator.Annotations(ctx)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		imageMeta.Created = now
+		history.Created = &now
+		if layer {
+			if err := mutator.Set(ctx, config, imageMeta, annotations, nil); err != nil {
+				return err
+			}
+			meta, err := umoci.ReadBundleMeta(b.bundle)
+			if err != nil {
+				return err
+			}
+			masks := slices.Clone(commitMaskedPaths)
+			for vol := range config.Volumes {
+				masks = append(masks, vol)
+			}
+			filters := []mtreefilter.FilterFunc{mtreefilter.MaskFilter(masks)}
+			if err := umoci.Repack(engineExt, cacheRef, b.bundle, meta, history, filters, true, mutator, mutate.GzipCompressor); err != nil {
+				return err
+			}
+		} else {
+			if err := mutator.Set(ctx, config, imageMeta, annotations, history); err != nil {
+				return err
+			}
+			newPath, err := mutator.Commit(ctx)
+			if err != nil {
+				return err
+			}
+			if err := engineExt.UpdateReference(ctx, cacheRef, newPath.Root()); err != nil {
+				return err
+			}
+		}
+		desc, err := resolveStoreReference(ctx, engineExt, cacheRef)
+		if err != nil {
+			return err
+		}
+		return b.load(engineExt, cacheRef, desc.Digest)
+	})
+}
+
+// unpack unpacks the image built so far into a temporary bundle, unless a previous step did.
+// Later steps keep the bundle in sync with the image they build.
+func (b *imageBuilder) unpack() error {
+	if b.bundle != "" {
+		return nil
+	}
+	bundle, err := os.MkdirTemp(b.dataRoot, ".build-")
+	if err != nil {
+		return err
+	}
+	// umoci unpacks into a new directory.
+	if err := os.Remove(bundle); err != nil {
+		return err
+	}
+	b.bundle = bundle
+	unlock, err := lockImageStore(b.dataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return unpack(imageStoreDir(b.dataRoot), b.ref, bundle)
+}
+
+// cleanup removes the bundle, once ruri released its mounts.
+func (b *imageBuilder) cleanup() {
+	if b.bundle == "" {
+		return
+	}
+	confPath := filepath.Join(b.bundle, "ruri.conf")
+	if _, err := os.Stat(confPath); err == nil {
+		if err := RunRuri(b.ruriPath, []string{"-U", confPath}, io.Discard); err != nil {
+			logrus.Warnf("Keeping %s, unmounting it failed: %v", b.bundle, err)
+			return
+		}
+	}
+	if err := os.RemoveAll(b.bundle); err != nil {
+		logrus.Warnf("Removing %s: %v", b.bundle, err)
+	}
+}
+
+// lookupEnv returns the value of the environment variable name in the image built so far.
+func (b *imageBuilder) lookupEnv(name string) (string, bool) {
+	for _, env := range b.config.Env {
+		if k, v, ok := strings.Cut(env, "="); ok && k == name {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// workDir returns the working directory of the image built so far.
+func (b *imageBuilder) workDir() string {
+	if b.config.WorkingDir == "" {
+		return "/"
+	}
+	return b.config.WorkingDir
+}
+
+// shellCommand returns the command of RUN, CMD or ENTRYPOINT, running the shell form with /bin/sh -c.
+func (inst *dockerfileInstruction) shellCommand() []string {
+	if inst.exec != nil {
+		return inst.exec
+	}
+	return []string{"/bin/sh", "-c", inst.args}
+}
+
+// run runs the command of a RUN instruction in the bundle with ruri, with the environment, working directory and
+// user of the image built so far.
+func (b *imageBuilder) run(inst *dockerfileInstruction) error {
+	if _, _, err := inst.flag(""); err != nil {
+		return err
+	}
+	workDir, err := securejoin.SecureJoin(filepath.Join(b.bundle, "rootfs"), b.workDir())
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(workDir, 0755); err != nil {
+		return err
+	}
+	// ruri can only take the group from the container's /etc/passwd.
+	runUser, _, _ := strings.Cut(b.config.User, ":")
+	if err := writeRuri(b.ruriPath, b.bundle, "", b.workDir(), runUser, b.config.Env, nil, b.etc); err != nil {
+		return err
+	}
+	confPath := filepath.Join(b.bundle, "ruri.conf")
+	command := inst.shellCommand()
+	cmd := exec.Command(b.ruriPath, append([]string{"-c", confPath}, command...)...)
+	cmd.Stdout = b.stdout
+	cmd.Stderr = os.Stderr
+	runErr := cmd.Run()
+	if err := RunRuri(b.ruriPath, []string{"-U", confPath}, io.Discard); err != nil {
+		return fmt.Errorf("unmounting the build container: %w", err)
+	}
+	if runErr != nil {
+		return fmt.Errorf("The command %q returned: %w", strings.Join(command, " "), runErr)
+	}
+	return nil
+}
+
+// copyArgs returns the sources and the destination of a COPY or ADD instruction, with the destination relative to
+// the working directory made absolute.
+func (b *imageBuilder) copyArgs(inst *dockerfileInstruction) ([]string, string, error) {
+	words := inst.exec
+	if words == nil {
+		var err error
+		if words, err = shellWords(inst.args, b.lookupEnv); err != nil {
+			return nil, "", err
+		}
+	}
+	if len(words) < 2 {
+		return nil, "", fmt.Errorf("%s requires at least one source and a destination", inst.command)
+	}
+	srcs, dest := words[:len(words)-1], words[len(words)-1]
+	if !path.IsAbs(dest) {
+		dir := strings.HasSuffix(dest, "/") || dest == "." || dest == ".."
+		dest = path.Join(b.workDir(), dest)
+		if dir {
+			dest += "/"
+		}
+	}
+	return srcs, dest, nil
+}
+
+// contextMatches returns the paths in the build context src matches, which may be a wildcard pattern.
+func (b *imageBuilder) contextMatches(src string) ([]string, error) {
+	p, err := securejoin.SecureJoin(b.contextDir, src)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := filepath.Glob(p)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no such file or directory in the build context", src)
+	}
+	return matches, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// copy copies the sources of a COPY or ADD instruction from the build context into the bundle. ADD also extracts
+// local tar archives and downloads URLs.
+func (b *imageBuilder) copy(inst *dockerfileInstruction) error {
+	chown, hasChown, err := inst.flag("chown", "chown")
+	if err != nil {
+		return err
+	}
+	srcs, dest, err := b.copyArgs(inst)
+	if err != nil {
+		return err
+	}
+	rootfs := filepath.Join(b.bundle, "rootfs")
+	// As with docker, copied files belong to root unless --chown says otherwise.
+	owner := idtools.IDPair{}
+	if hasChown {
+		passwd, err := securejoin.SecureJoin(rootfs, "/etc/passwd")
+		if err != nil {
+			return err
+		}
+		group, err := securejoin.SecureJoin(rootfs, "/etc/group")
+		if err != nil {
+			return err
+		}
+		u, err := user.GetExecUserPath(chown, &user.ExecUser{}, passwd, group)
+		if err != nil {
+			return fmt.Errorf("Invalid --chown=%s: %w", chown, err)
+		}
+		owner = idtools.IDPair{UID: u.Uid, GID: u.Gid}
+	}
+
+	var matches []string
+	for _, src := range srcs {
+		if inst.command == "ADD" && isURL(src) {
+			matches = append(matches, src)
+			continue
+		}
+		m, err := b.contextMatches(src)
+		if err != nil {
+			return err
+		}
+		matches = append(matches, m...)
+	}
+	destIsDir := strings.HasSuffix(dest, "/") || len(matches) > 1
+	if !destIsDir {
+		if p, err := securejoin.SecureJoin(rootfs, dest); err == nil {
+			if st, err := os.Stat(p); err == nil && st.IsDir() {
+				destIsDir = true
+			}
+		}
+	}
+	for _, match := range matches {
+		switch {
+		case isURL(match):
+			err = b.download(match, rootfs, dest, destIsDir, owner)
+		case inst.command == "ADD" && isLocalArchive(match):
+			err = extractArchive(match, rootfs, dest)
+		default:
+			err = copyIntoRootfs(match, rootfs, dest, destIsDir, owner)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyIntoRootfs copies src to dest in rootfs, owned by owner. A directory has its contents copied into dest;
+// a file is copied into dest if destIsDir, otherwise to dest.
+func copyIntoRootfs(src, rootfs, dest string, destIsDir bool, owner idtools.IDPair) error {
+	st, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	options := &archive.TarOptions{ChownOpts: &owner}
+	dir, tarDir := dest, src
+	if !st.IsDir() {
+		name := path.Base(dest)
+		if destIsDir {
+			name = filepath.Base(src)
+		} else {
+			dir = path.Dir(dest)
+		}
+		tarDir = filepath.Dir(src)
+		options.IncludeFiles = []string{filepath.Base(src)}
+		options.RebaseNames = map[string]string{filepath.Base(src): name}
+	}
+	destDir, err := securejoin.SecureJoin(rootfs, dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	rc, err := archive.TarWithOptions(tarDir, options)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return archive.Untar(rc, destDir, &archive.TarOptions{})
+}
+
+// isLocalArchive reports whether the file p is a tar archive, possibly compressed, which ADD extracts.
+func isLocalArchive(p string) bool {
+	st, err := os.Lstat(p)
+	return err == nil && st.Mode().IsRegular() && archive.IsArchivePath(p)
+}
+
+// extractArchive extracts the tar archive src, possibly compressed, into the directory dest in rootfs.
+func extractArchive(src, rootfs, dest string) error {
+	destDir, err := securejoin.SecureJoin(rootfs, dest)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return err
+	}
+	return archive.UntarPath(src, destDir)
+}
+
+// addDownloadTimeout bounds how long ADD may take to download a file, so that a stalled server does not hang build.
+const addDownloadTimeout = 10 * time.Minute
+
+// download downloads rawURL to dest in rootfs, owned by owner, into dest under the last element of the URL path if
+// destIsDir. As with docker, the file is only readable by its owner.
+func (b *imageBuilder) download(rawURL, rootfs, dest string, destIsDir bool, owner idtools.IDPair) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		if destIsDir {
+			return fmt.Errorf("Cannot name the file downloaded from %s, add it to a file name", rawURL)
+		}
+		name = "download"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), addDownloadTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Downloading %s: %s", rawURL, resp.Status)
+	}
+	tmpDir, err := os.MkdirTemp(b.bundle, "download-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmp := filepath.Join(tmpDir, name)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, resp.Body)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if mtime, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(tmp, mtime, mtime)
+	}
+	return copyIntoRootfs(tmp, rootfs, dest, destIsDir, owner)
+}
+
+// configStep returns the config of the image built so far with the changes of an ENV, LABEL, WORKDIR, USER, EXPOSE,
+// ENTRYPOINT or CMD instruction.
+func (b *imageBuilder) configStep(inst *dockerfileInstruction) (imgspecv1.ImageConfig, error) {
+	config := b.config
+	if _, _, err := inst.flag(""); err != nil {
+		return config, err
+	}
+	var words []string
+	if inst.command != "ENTRYPOINT" && inst.command != "CMD" {
+		var err error
+		if words, err = shellWords(inst.args, b.lookupEnv); err != nil {
+			return config, err
+		}
+	}
+	switch inst.command {
+	case "ENV", "LABEL":
+		pairs, err := keyValues(inst, words)
+		if err != nil {
+			return config, err
+		}
+		if inst.command == "LABEL" {
+			config.Labels = maps.Clone(config.Labels)
+			if config.Labels == nil {
+				config.Labels = map[string]string{}
+			}
+		} else {
+			config.Env = slices.Clone(config.Env)
+		}
+		for _, kv := range pairs {
+			if inst.command == "LABEL" {
+				config.Labels[kv[0]] = kv[1]
+				continue
+			}
+			i := slices.IndexFunc(config.Env, func(env string) bool { return strings.HasPrefix(env, kv[0]+"=") })
+			if i == -1 {
+				config.Env = append(config.Env, kv[0]+"="+kv[1])
+			} else {
+				config.Env[i] = kv[0] + "=" + kv[1]
+			}
+		}
+	case "WORKDIR", "USER":
+		if len(words) != 1 {
+			return config, fmt.Errorf("%s requires exactly one argument", inst.command)
+		}
+		if inst.command == "USER" {
+			config.User = words[0]
+		} else {
+			config.WorkingDir = path.Join(b.workDir(), words[0])
+			if path.IsAbs(words[0]) {
+				config.WorkingDir = path.Clean(words[0])
+			}
+		}
+	case "EXPOSE":
+		config.ExposedPorts = maps.Clone(config.ExposedPorts)
+		if config.ExposedPorts == nil {
+			config.ExposedPorts = map[string]struct{}{}
+		}
+		for _, w := range words {
+			port, proto, ok := strings.Cut(w, "/")
+			if !ok {
+				proto = "tcp"
+			}
+			if n, err := strconv.ParseUint(port, 10, 16); err != nil || n == 0 || (proto != "tcp" && proto != "udp" && proto != "sctp") {
+				return config, fmt.Errorf("Invalid port %q", w)
+			}
+			config.ExposedPorts[port+"/"+strings.ToLower(proto)] = struct{}{}
+		}
+	case "ENTRYPOINT":
+		config.Entrypoint = inst.shellCommand()
+		// As with docker, the CMD of the base image does not apply to another ENTRYPOINT.
+		if !b.cmdSet {
+			config.Cmd = nil
+		}
+	case "CMD":
+		config.Cmd = inst.shellCommand()
+	}
+	return config, nil
+}
+
+// keyValues returns the key=value pairs of ENV or LABEL words, or the key and value of the legacy "ENV key value" form.
+func keyValues(inst *dockerfileInstruction, words []string) ([][2]string, error) {
+	if len(words) > 0 && !strings.Contains(words[0], "=") {
+		if len(words) < 2 {
+			return nil, fmt.Errorf("%s requires a value for %s", inst.command, words[0])
+		}
+		return [][2]string{{words[0], strings.Join(words[1:], " ")}}, nil
+	}
+	var pairs [][2]string
+	for _, w := range words {
+		k, v, ok := strings.Cut(w, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("%s requires key=value pairs, not %q", inst.command, w)
+		}
+		pairs = append(pairs, [2]string{k, v})
+	}
+	return pairs, nil
+}
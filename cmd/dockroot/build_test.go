@@ -0,0 +1,130 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRuri stands in for ruri in build: it records the command it runs in the file "ran" of the container.
+const fakeRuri = `#!/bin/sh
+[ "$1" = -U ] && exit 0
+dir=$(sed -n 's/^container_dir="\(.*\)"$/\1/p' "$2")
+shift 2
+echo "$@" > "$dir/ran"
+`
+
+func TestBuildImage(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	addTestImage(t, storeDir, "docker.io/library/base:1.0", map[string]string{"etc/passwd": "app:x:1000:1000::/home/app:/bin/sh\n"}, nil)
+	_, err := tagImageDigest(storeDir, "docker.io/library/base:1.0")
+	require.NoError(t, err)
+
+	contextDir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(contextDir, "src/lib"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contextDir, "src/app.sh"), []byte("echo app"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(contextDir, "src/lib/util.sh"), []byte("echo util"), 0644))
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "data.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 4}))
+	_, err = tw.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, os.WriteFile(filepath.Join(contextDir, "data.tar"), archive.Bytes(), 0644))
+	instructions, err := parseDockerfile(strings.NewReader(`FROM base:1.0
+ENV APP_HOME=/opt/app GREETING="hello world"
+WORKDIR $APP_HOME
+COPY --chown=app src/ ./
+COPY src/app.sh /usr/bin/
+ADD data.tar /srv/
+RUN ["touch", "/built"]
+USER app
+EXPOSE 8080 53/udp
+LABEL org.opencontainers.image.title="My app"
+CMD ["--verbose"]
+ENTRYPOINT ["./app.sh"]
+`))
+	require.NoError(t, err)
+
+	ruriPath := filepath.Join(t.TempDir(), "ruri")
+	require.NoError(t, os.WriteFile(ruriPath, []byte(fakeRuri), 0755))
+	build := func() (string, string) {
+		var out bytes.Buffer
+		b := &imageBuilder{dataRoot: dataRoot, contextDir: contextDir, ruriPath: ruriPath, stdout: &out}
+		defer b.cleanup()
+		manifestDigest, err := b.build(instructions, "docker.io/library/app:1.0")
+		require.NoError(t, err, out.String())
+		return manifestDigest, out.String()
+	}
+	manifestDigest, out := build()
+	assert.NotContains(t, out, "Using cache")
+	cachedDigest, out := build()
+	assert.Equal(t, manifestDigest, cachedDigest)
+	assert.Equal(t, 11, strings.Count(out, "Using cache"))
+	bundles, err := filepath.Glob(filepath.Join(dataRoot, ".build-*"))
+	require.NoError(t, err)
+	assert.Empty(t, bundles)
+
+	engineExt, err := openImageStore(storeDir)
+	require.NoError(t, err)
+	img, err := readImageConfig(engineExt, manifestDigest)
+	require.NoError(t, err)
+	config := img.Config
+	assert.Contains(t, config.Env, "APP_HOME=/opt/app")
+	assert.Contains(t, config.Env, "GREETING=hello world")
+	assert.Equal(t, "/opt/app", config.WorkingDir)
+	assert.Equal(t, "app", config.User)
+	assert.Equal(t, map[string]struct{}{"8080/tcp": {}, "53/udp": {}}, config.ExposedPorts)
+	assert.Equal(t, "My app", config.Labels["org.opencontainers.image.title"])
+	assert.Equal(t, []string{"./app.sh"}, config.Entrypoint)
+	assert.Equal(t, []string{"--verbose"}, config.Cmd, "CMD from the Dockerfile survives ENTRYPOINT")
+	require.Len(t, img.History, 12)
+	emptyLayers := 0
+	for _, h := range img.History[1:] {
+		if h.EmptyLayer {
+			emptyLayers++
+		}
+	}
+	assert.Equal(t, 7, emptyLayers, "one layer for each RUN, COPY and ADD")
+	require.NoError(t, engineExt.Close())
+
+	containerDir := unpackTestContainer(t, dataRoot, "docker.io/library/app:1.0", "app001")
+	rootfs := filepath.Join(containerDir, "rootfs")
+	for name, content := range map[string]string{
+		"opt/app/app.sh":      "echo app",
+		"opt/app/lib/util.sh": "echo util",
+		"usr/bin/app.sh":      "echo app",
+		"srv/data.txt":        "data",
+		"ran":                 "touch /built\n",
+		"etc/passwd":          "app:x:1000:1000::/home/app:/bin/sh\n",
+	} {
+		data, err := os.ReadFile(filepath.Join(rootfs, name))
+		require.NoError(t, err, name)
+		assert.Equal(t, content, string(data), name)
+	}
+	st, err := os.Stat(filepath.Join(rootfs, "opt/app/lib/util.sh"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 1000, st.Sys().(*syscall.Stat_t).Uid)
+	st, err = os.Stat(filepath.Join(rootfs, "usr/bin/app.sh"))
+	require.NoError(t, err)
+	assert.EqualValues(t, 0, st.Sys().(*syscall.Stat_t).Uid)
+
+	// Cached steps are not listed as images, and rmi --build-cache removes them.
+	images, err := listStoreImages(storeDir)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, []string{"docker.io/library/app:1.0"}, images[0].names)
+	removed, err := pruneBuildCache(dataRoot)
+	require.NoError(t, err)
+	assert.Equal(t, 11, removed)
+	_, out = build()
+	assert.NotContains(t, out, "Using cache")
+}
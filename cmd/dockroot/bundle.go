@@ -0,0 +1,256 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// bundleManifestName is the file in a dependency bundle which records the SHA-256 digest of every binary in it.
+const bundleManifestName = "bundle.json"
+
+// bundleManifest maps a dependency name and architecture to the hex SHA-256 digest of the bundled binary.
+type bundleManifest map[string]map[string]string
+
+// bundledDependencies lists what a bundle carries, and how each binary is checked to run.
+var bundledDependencies = []struct {
+	name  string
+	check func(binaryPath string) bool
+}{
+	{dependencyRuri, checkIsRuriDownload},
+	{dependencyKspeeder, checkIsKspeederDownload},
+}
+
+func bundleFileName(name, arch string) string {
+	return name + "." + arch
+}
+
+// isTarballPath returns true if path names a tarball rather than a bundle directory, and whether it is compressed.
+func isTarballPath(path string) (tarball, compressed bool) {
+	switch {
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return true, true
+	case strings.HasSuffix(path, ".tar"):
+		return true, false
+	}
+	return false, false
+}
+
+// writeBundle downloads ruri and kspeeder for each of archs into out, a directory or a .tar, .tar.gz or .tgz file.
+// Binaries for this machine's architecture are also checked to run, as ensuredeps would check them.
+func writeBundle(client *http.Client, info *registryInfo, archs []string, out string) error {
+	tarball, compressed := isTarballPath(out)
+	dir := out
+	if tarball {
+		tmpDir, err := os.MkdirTemp("", "dockroot-bundle")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		dir = tmpDir
+	} else if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	manifest := bundleManifest{}
+	for _, d := range bundledDependencies {
+		dep := info.dependency(d.name)
+		manifest[d.name] = map[string]string{}
+		for _, arch := range archs {
+			path := filepath.Join(dir, bundleFileName(d.name, arch))
+			if err := downloadBinaryForArch(client, dep, arch, path, d.name); err != nil {
+				return err
+			}
+			if arch == dep.arch && !d.check(path) {
+				return fmt.Errorf("%s from %s does not run on this machine", d.name, dep.url(arch))
+			}
+			digest, err := fileSHA256(path)
+			if err != nil {
+				return err
+			}
+			manifest[d.name][arch] = digest
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, bundleManifestName), data, 0644); err != nil {
+		return err
+	}
+	if tarball {
+		return writeBundleTarball(dir, out, compressed)
+	}
+	return nil
+}
+
+// writeBundleTarball writes the files in dir to the tarball out.
+func writeBundleTarball(dir, out string, compressed bool) (retErr error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+		if retErr != nil {
+			os.Remove(out)
+		}
+	}()
+	var w io.Writer = f
+	if compressed {
+		gz := gzip.NewWriter(f)
+		defer func() {
+			if err := gz.Close(); err != nil && retErr == nil {
+				retErr = err
+			}
+		}()
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		if err := addTarFile(tw, filepath.Join(dir, entry.Name()), entry.Name()); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func addTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractBundleTarball extracts the bundle files in the tarball at path, compressed or not, into dir.
+// Directories in entry names are ignored, so nothing is written outside dir.
+func extractBundleTarball(path, dir string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	br := bufio.NewReader(f)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("Error reading bundle %s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		out, err := os.OpenFile(filepath.Join(dir, filepath.Base(hdr.Name)), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// installFromBundle installs the named dependencies for this machine into binaryDir from src, a bundle directory or tarball.
+// Each binary must match the digest recorded in the bundle and any digest pinned in dockroot.json, and must run, before it is installed.
+func installFromBundle(src string, info *registryInfo, binaryDir string, names []string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	dir := src
+	if !fi.IsDir() {
+		tmpDir, err := os.MkdirTemp("", "dockroot-bundle")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+		if err := extractBundleTarball(src, tmpDir); err != nil {
+			return err
+		}
+		dir = tmpDir
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, bundleManifestName))
+	if err != nil {
+		return fmt.Errorf("%s is not a dependency bundle: %w", src, err)
+	}
+	var manifest bundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("Error parsing %s in %s: %w", bundleManifestName, src, err)
+	}
+
+	arch := info.dependencyArch()
+	for _, d := range bundledDependencies {
+		if !slices.Contains(names, d.name) {
+			continue
+		}
+		digest := strings.ToLower(manifest[d.name][arch])
+		if digest == "" {
+			return fmt.Errorf("Bundle %s has no %s for %s", src, d.name, arch)
+		}
+		// bundle.json carries the digests to verify against, unless dockroot.json pins its own.
+		if pinned := info.dependency(d.name).digest(arch); pinned != "" && pinned != digest {
+			return fmt.Errorf("Bundle %s has %s sha256:%s for %s, but dockroot.json pins sha256:%s", src, d.name, digest, arch, pinned)
+		}
+		if err := installBundledBinary(dir, d.name, arch, digest, filepath.Join(binaryDir, d.name), d.check); err != nil {
+			return err
+		}
+		fmt.Printf("Installed %s from %s\n", d.name, src)
+	}
+	return nil
+}
+
+func installBundledBinary(dir, name, arch, digest, binaryPath string, check func(string) bool) error {
+	path := filepath.Join(dir, bundleFileName(name, arch))
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return installBinary(f, digest, arch, path, binaryPath, name, check)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDependencyServer serves shell scripts which pass checkIsRuriDownload and checkIsKspeederDownload.
+func fakeDependencyServer(t *testing.T) *httptest.Server {
+	scripts := map[string]string{
+		dependencyRuri:     "#!/bin/sh\necho 'ruri version 3.8'\n",
+		dependencyKspeeder: "#!/bin/sh\necho 'usage: -localAddr string'\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), ".")
+		script, ok := scripts[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(script))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestDependencyBundle(t *testing.T) {
+	if _, err := os.Stat("/bin/sh"); err != nil {
+		t.Skip("/bin/sh not available")
+	}
+	srv := fakeDependencyServer(t)
+	info := &registryInfo{Dependencies: map[string]*dependencyInfo{
+		dependencyRuri:     {URL: srv.URL + "/ruri.{arch}"},
+		dependencyKspeeder: {URL: srv.URL + "/kspeeder.{arch}"},
+	}}
+	archs := []string{info.dependencyArch(), "riscv64"}
+
+	// Nothing is pinned for the fake server, so nothing is downloaded unless that is allowed.
+	err := writeBundle(srv.Client(), info, archs, filepath.Join(t.TempDir(), "bundle"))
+	assert.ErrorContains(t, err, "No SHA-256 pinned")
+	info.AllowUnpinned = true
+
+	for _, out := range []string{"bundle", "bundle.tar", "bundle.tar.gz"} {
+		t.Run(out, func(t *testing.T) {
+			out := filepath.Join(t.TempDir(), out)
+			err := writeBundle(srv.Client(), info, archs, out)
+			require.NoError(t, err)
+
+			binaryDir := t.TempDir()
+			err = installFromBundle(out, info, binaryDir, []string{dependencyRuri, dependencyKspeeder})
+			require.NoError(t, err)
+			assert.True(t, checkIsRuriDownload(filepath.Join(binaryDir, "ruri")))
+			assert.True(t, checkIsKspeederDownload(filepath.Join(binaryDir, "kspeeder")))
+		})
+	}
+
+	// Only the requested dependencies are installed. Installing needs no pins besides those of the bundle.
+	out := filepath.Join(t.TempDir(), "bundle")
+	require.NoError(t, writeBundle(srv.Client(), info, archs, out))
+	binaryDir := t.TempDir()
+	require.NoError(t, installFromBundle(out, &registryInfo{}, binaryDir, []string{dependencyRuri}))
+	assert.NoFileExists(t, filepath.Join(binaryDir, "kspeeder"))
+
+	// A binary which does not match the bundle manifest is rejected, and leaves nothing behind.
+	require.NoError(t, os.WriteFile(filepath.Join(out, bundleFileName(dependencyRuri, info.dependencyArch())), []byte("#!/bin/sh\necho 'ruri version evil'\n"), 0755))
+	binaryDir = t.TempDir()
+	err = installFromBundle(out, info, binaryDir, []string{dependencyRuri})
+	assert.ErrorContains(t, err, "Checksum mismatch")
+	assert.NoFileExists(t, filepath.Join(binaryDir, "ruri"))
+	assert.NoFileExists(t, filepath.Join(binaryDir, "ruri.syn"))
+
+	// A bundle which disagrees with the digest pinned in dockroot.json is rejected.
+	pinned := &registryInfo{Dependencies: map[string]*dependencyInfo{
+		dependencyRuri: {SHA256: map[string]string{info.dependencyArch(): strings.Repeat("0", 64)}},
+	}}
+	err = installFromBundle(out, pinned, t.TempDir(), []string{dependencyRuri})
+	assert.ErrorContains(t, err, "dockroot.json pins")
+
+	// Architectures missing from the bundle are reported.
+	foreign := filepath.Join(t.TempDir(), "bundle")
+	require.NoError(t, writeBundle(srv.Client(), info, []string{"riscv64"}, foreign))
+	err = installFromBundle(foreign, info, t.TempDir(), []string{dependencyRuri})
+	assert.ErrorContains(t, err, "has no ruri for "+info.dependencyArch())
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/mutate"
+	"github.com/opencontainers/umoci/pkg/funchelpers"
+	"github.com/opencontainers/umoci/pkg/mtreefilter"
+	"github.com/spf13/cobra"
+	"github.com/vbatts/go-mtree"
+)
+
+// commitMaskedPaths are the files DockRoot writes into a rootfs for the host it runs on, which commit leaves out
+// along with the image's volumes.
+var commitMaskedPaths = []string{"etc/resolv.conf", "etc/hosts", "etc/localtime"}
+
+type commitOptions struct {
+	global  *globalOptions
+	message string
+}
+
+func commitCmd(global *globalOptions) *cobra.Command {
+	opts := commitOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "commit NAME IMAGE[:TAG]",
+		Short: "create an image in the image store from the changes to a container",
+		Long: `Create an image in the image store from the changes to the rootfs of the container NAME,
+added as a layer on top of the image it was created from. The Env, Cmd, WorkingDir and User
+it runs with are recorded in the image config.
+
+` + strings.Join(commitMaskedPaths, ", ") + ` and the image's volumes are left out. The container must be stopped.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot commit homeassistant001 homeassistant:configured
+DockRoot save homeassistant:configured -o homeassistant.tar`,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.message, "message", "m", "", "Commit message, recorded in the image history")
+	return cmd
+}
+
+func (opts *commitOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: %s commit NAME IMAGE[:TAG]", os.Args[0])
+	}
+	ref, err := parseImageReference(args[1])
+	if err != nil {
+		return err
+	}
+	if ref.digest() != "" {
+		return fmt.Errorf("Cannot commit to %s, which is pinned to a digest", args[1])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	name := CleanString(args[0])
+	destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, name))
+	if err != nil {
+		return err
+	}
+	if !isDirValid(destAbsDir) {
+		return fmt.Errorf("Container %s does not exist", name)
+	}
+	img, err := readContainerImage(destAbsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Container %s was pulled before images were kept, pull it again", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+	confPath := filepath.Join(destAbsDir, "ruri.conf")
+	conf, err := readRuriConf(confPath)
+	if err != nil {
+		return err
+	}
+	// umoci would take the mounts of the last run for changes.
+	if err := releaseContainer(ruriPath, confPath, name); err != nil {
+		return err
+	}
+	if err := mountContainerRootfs(info.DataRoot, destAbsDir); err != nil {
+		return err
+	}
+
+	unlock, err := lockImageStore(info.DataRoot, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	history := &imgspecv1.History{CreatedBy: "dockroot commit " + name, Comment: opts.message}
+	manifestDigest, err := commitContainer(info.DataRoot, img, destAbsDir, conf, ref.storeName(), history)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "%s: %s\n", ref.storeName(), manifestDigest)
+	return nil
+}
+
+// commitContainer adds the changes to the rootfs of the container in containerDir, created from img, as a layer on
+// top of img, and keeps the result in the store as refName with the settings in conf. It returns the new manifest digest.
+func commitContainer(dataRoot string, img *containerImage, containerDir string, conf map[string][]string, refName string, history *imgspecv1.History) (_ string, Err error) {
+	storeDir := imageStoreDir(dataRoot)
+	// umoci diffs the rootfs against the mtree written when unpacking, which the lower bundle holds for overlays.
+	if img.Storage == storageOverlay {
+		cleanup, err := borrowLowerMeta(dataRoot, img, containerDir)
+		if err != nil {
+			return "", err
+		}
+		defer cleanup()
+	}
+	meta, err := umoci.ReadBundleMeta(containerDir)
+	if err != nil {
+		return "", err
+	}
+
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return "", err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	ctx := context.Background()
+	paths, err := engineExt.ResolveReference(ctx, img.Digest)
+	if err != nil {
+		return "", err
+	}
+	if len(paths) != 1 {
+		return "", fmt.Errorf("tag is not found or ambiguous: %s", img.Digest)
+	}
+	mutator, err := mutate.New(engineExt, paths[0])
+	if err != nil {
+		return "", err
+	}
+	config, err := mutator.Config(ctx)
+	if err != nil {
+		return "", err
+	}
+	imageMeta, err := mutator.Meta(ctx)
+	if err != nil {
+		return "", err
+	}
+	annotations, err := mutator.Annotations(ctx)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	imageMeta.Created = now
+	history.Created = &now
+	if err := mutator.Set(ctx, commitConfig(config.Config, conf), imageMeta, annotations, nil); err != nil {
+		return "", err
+	}
+
+	masks := slices.Clone(commitMaskedPaths)
+	for vol := range config.Config.Volumes {
+		masks = append(masks, vol)
+	}
+	relinked, err := relinkedPaths(containerDir, meta)
+	if err != nil {
+		return "", err
+	}
+	masks = append(masks, relinked...)
+	filters := []mtreefilter.FilterFunc{mtreefilter.MaskFilter(masks)}
+	if err := umoci.Repack(engineExt, refName, containerDir, meta, history, filters, false, mutator, mutate.GzipCompressor); err != nil {
+		return "", err
+	}
+	manifestDigest, err := tagImageDigest(storeDir, refName)
+	if err != nil {
+		return "", err
+	}
+	return manifestDigest.String(), nil
+}
+
+// relinkedPaths returns the files in the rootfs of bundle which dedupe hardlinked to the copies in other containers.
+// These only differ from the image in their link count, which umoci would take for a change.
+func relinkedPaths(bundle string, meta umoci.Meta) ([]string, error) {
+	mtreeName := strings.Replace(meta.From.Descriptor().Digest.String(), ":", "_", 1) + ".mtree"
+	f, err := os.Open(filepath.Join(bundle, mtreeName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	spec, err := mtree.ParseSpec(f)
+	if err != nil {
+		return nil, err
+	}
+	rootfs := filepath.Join(bundle, "rootfs")
+	var paths []string
+	for _, e := range spec.Entries {
+		if e.Type != mtree.RelativeType && e.Type != mtree.FullType {
+			continue
+		}
+		keys := map[mtree.Keyword]string{}
+		for _, kv := range e.AllKeys() {
+			keys[kv.Keyword()] = kv.Value()
+		}
+		if keys["type"] != "file" {
+			continue
+		}
+		p, err := e.Path()
+		if err != nil {
+			return nil, err
+		}
+		st, err := os.Lstat(filepath.Join(rootfs, p))
+		if err != nil || !st.Mode().IsRegular() {
+			continue
+		}
+		sys := st.Sys().(*syscall.Stat_t)
+		if strconv.FormatUint(uint64(sys.Nlink), 10) == keys["nlink"] ||
+			strconv.FormatInt(st.Size(), 10) != keys["size"] ||
+			strconv.FormatUint(uint64(sys.Uid), 10) != keys["uid"] ||
+			strconv.FormatUint(uint64(sys.Gid), 10) != keys["gid"] ||
+			fmt.Sprintf("%#o", st.Mode().Perm()) != keys["mode"] {
+			continue
+		}
+		ok, err := matchesLayer(filepath.Join(rootfs, p), st, layerFileContent{
+			digest: digest.NewDigestFromEncoded(digest.SHA256, keys["sha256digest"]),
+			size:   st.Size(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
+
+// borrowLowerMeta copies the umoci metadata of the lower bundle of the overlay container in containerDir next to its
+// rootfs, and returns a function removing it again.
+func borrowLowerMeta(dataRoot string, img *containerImage, containerDir string) (func(), error) {
+	lowerBundle := lowerBundleDir(dataRoot, img.Digest)
+	entries, err := os.ReadDir(lowerBundle)
+	if err != nil {
+		return nil, err
+	}
+	var copied []string
+	cleanup := func() {
+		for _, p := range copied {
+			os.Remove(p)
+		}
+	}
+	for _, e := range entries {
+		if e.Name() != umoci.MetaName && !strings.HasSuffix(e.Name(), ".mtree") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(lowerBundle, e.Name()))
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+		p := filepath.Join(containerDir, e.Name())
+		if err := os.WriteFile(p, data, 0644); err != nil {
+			cleanup()
+			return nil, err
+		}
+		copied = append(copied, p)
+	}
+	return cleanup, nil
+}
+
+// commitConfig returns config with the Env, Cmd, WorkingDir and User from conf, the container's ruri.conf.
+// The image's Entrypoint and Cmd stay apart unless the container runs another command.
+func commitConfig(config imgspecv1.ImageConfig, conf map[string][]string) imgspecv1.ImageConfig {
+	env := conf["env"]
+	config.Env = nil
+	for i := 0; i+1 < len(env); i += 2 {
+		config.Env = append(config.Env, env[i]+"="+env[i+1])
+	}
+	if command := conf["command"]; len(command) > 0 && !slices.Equal(command, containerCommand(config.Entrypoint, config.Cmd, "", nil)) {
+		config.Entrypoint = nil
+		config.Cmd = command
+	}
+	if v := conf["work_dir"]; len(v) == 1 {
+		config.WorkingDir = v[0]
+	}
+	if v := conf["user"]; len(v) == 1 {
+		config.User = v[0]
+	}
+	return config
+}
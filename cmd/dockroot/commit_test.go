@@ -0,0 +1,141 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRuriConf(t *testing.T) {
+	ruriInfo := DefaultRuriInfo()
+	ruriInfo.User = "1000"
+	ruriInfo.WorkDir = "/srv"
+	ruriInfo.Envs = []string{"PATH", "/usr/bin:/bin", "TZ", "UTC"}
+	ruriInfo.Commands = []string{"/bin/sh", "-c", "echo hi"}
+	path := filepath.Join(t.TempDir(), "ruri.conf")
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	require.NoError(t, RenderRuriInfo(ruriInfo, f))
+	require.NoError(t, f.Close())
+
+	conf, err := readRuriConf(path)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1000"}, conf["user"])
+	assert.Equal(t, []string{"/srv"}, conf["work_dir"])
+	assert.Equal(t, ruriInfo.Envs, conf["env"])
+	assert.Equal(t, ruriInfo.Commands, conf["command"])
+	assert.Empty(t, conf["extra_mountpoint"])
+	assert.Contains(t, conf, "extra_mountpoint")
+}
+
+func TestCommitConfig(t *testing.T) {
+	image := imgspecv1.ImageConfig{
+		Entrypoint: []string{"/init"},
+		Cmd:        []string{"serve"},
+		Env:        []string{"PATH=/bin"},
+	}
+	// The image's command keeps its Entrypoint and Cmd apart.
+	config := commitConfig(image, map[string][]string{
+		"command":  {"/init", "serve"},
+		"env":      {"PATH", "/bin", "TZ", "UTC"},
+		"work_dir": {"/srv"},
+		"user":     {""},
+	})
+	assert.Equal(t, []string{"/init"}, config.Entrypoint)
+	assert.Equal(t, []string{"serve"}, config.Cmd)
+	assert.Equal(t, []string{"PATH=/bin", "TZ=UTC"}, config.Env)
+	assert.Equal(t, "/srv", config.WorkingDir)
+
+	config = commitConfig(image, map[string][]string{"command": {"/root/entry.sh"}, "user": {"1000:1000"}})
+	assert.Nil(t, config.Entrypoint)
+	assert.Equal(t, []string{"/root/entry.sh"}, config.Cmd)
+	assert.Equal(t, "1000:1000", config.User)
+}
+
+func TestCommitContainer(t *testing.T) {
+	for _, storage := range []string{storageCopy, storageOverlay} {
+		t.Run(storage, func(t *testing.T) {
+			dataRoot := t.TempDir()
+			storeDir := imageStoreDir(dataRoot)
+			const refName = "docker.io/library/app:latest"
+			addTestImage(t, storeDir, refName, map[string]string{
+				"usr/bin/app":  "v1",
+				"usr/bin/tool": "tool",
+				"usr/lib/old":  "old",
+				"data/seed":    "seed",
+			}, []string{"/data"})
+			manifestDigest, err := tagImageDigest(storeDir, refName)
+			require.NoError(t, err)
+			containerDir := filepath.Join(dataRoot, "app001")
+			require.NoError(t, os.Mkdir(containerDir, 0755))
+			img := &containerImage{Name: refName, Digest: manifestDigest.String()}
+			require.NoError(t, unpackContainer(dataRoot, img, containerDir, storage))
+			if img.Storage != storage && storage == storageOverlay {
+				t.Skip("overlayfs is not available")
+			}
+			defer unmountContainerRootfs(containerDir)
+			require.NoError(t, writeContainerImage(containerDir, img))
+			require.NoError(t, writeRuri("/usr/bin/ruri", containerDir, "", "/srv", "", []string{"FOO=bar"}, nil, etcConfig{dns: []string{"10.0.0.53"}}))
+			if storage == storageCopy {
+				// Files dedupe linked to another container's copy are no change.
+				other := unpackTestContainer(t, dataRoot, refName, "app002")
+				groups, err := dedupeGroups(storeDir, []string{containerDir, other})
+				require.NoError(t, err)
+				files, _, err := dedupeFiles(groups)
+				require.NoError(t, err)
+				require.Positive(t, files)
+				meta, err := umoci.ReadBundleMeta(containerDir)
+				require.NoError(t, err)
+				relinked, err := relinkedPaths(containerDir, meta)
+				require.NoError(t, err)
+				assert.Contains(t, relinked, "usr/bin/tool")
+			}
+
+			rootfs := filepath.Join(containerDir, "rootfs")
+			require.NoError(t, os.WriteFile(filepath.Join(rootfs, "usr/bin/app"), []byte("v2"), 0755))
+			require.NoError(t, os.WriteFile(filepath.Join(rootfs, "usr/bin/new"), []byte("new"), 0755))
+			require.NoError(t, os.Remove(filepath.Join(rootfs, "usr/lib/old")))
+			require.NoError(t, os.WriteFile(filepath.Join(rootfs, "data/db"), []byte("state"), 0644))
+
+			conf, err := readRuriConf(filepath.Join(containerDir, "ruri.conf"))
+			require.NoError(t, err)
+			const newRef = "docker.io/library/app:committed"
+			committed, err := commitContainer(dataRoot, img, containerDir, conf, newRef, &imgspecv1.History{CreatedBy: "test"})
+			require.NoError(t, err)
+			assert.NotEqual(t, img.Digest, committed)
+			if storage == storageOverlay {
+				entries, err := os.ReadDir(containerDir)
+				require.NoError(t, err)
+				for _, e := range entries {
+					assert.NotEqual(t, umoci.MetaName, e.Name())
+				}
+			}
+
+			copyDir := unpackTestContainer(t, dataRoot, newRef, "app003")
+			read := func(p string) string {
+				b, err := os.ReadFile(filepath.Join(copyDir, "rootfs", p))
+				if err != nil {
+					return "<missing>"
+				}
+				return string(b)
+			}
+			assert.Equal(t, "v2", read("usr/bin/app"))
+			assert.Equal(t, "new", read("usr/bin/new"))
+			assert.Equal(t, "tool", read("usr/bin/tool"))
+			assert.Equal(t, "<missing>", read("usr/lib/old"))
+			assert.Equal(t, "<missing>", read("data/db"))
+			assert.Equal(t, "<missing>", read("etc/resolv.conf"))
+
+			imgConfig, err := getImageConfig(filepath.Join(copyDir, imageConfigFile))
+			require.NoError(t, err)
+			assert.Equal(t, "/srv", imgConfig.Config.WorkingDir)
+			assert.Contains(t, imgConfig.Config.Env, "FOO=bar")
+			assert.Equal(t, []string{"/bin/sh"}, imgConfig.Config.Cmd)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	hostResolvConf = "/etc/resolv.conf"
+	hostLocaltime  = "/etc/localtime"
+	zoneinfoDir    = "/usr/share/zoneinfo"
+
+	// fallbackNameserver is used if neither --dns, dockroot.json nor the host name any nameserver.
+	fallbackNameserver = "223.5.5.5"
+
+	tzHost = "host" // --tz value binding the host's /etc/localtime
+)
+
+// etcConfig is how a container resolves names and tells the time, written to its /etc when its ruri config is renewed.
+type etcConfig struct {
+	dns       []string // Nameservers; if empty, those of the host are used
+	dnsSearch []string // Search domains; if empty, those of the host are used
+	addHosts  []string // Extra HOST:IP entries for /etc/hosts
+	tz        string   // tzHost, a zoneinfo name such as "Asia/Shanghai", or "" to keep the image's
+}
+
+// etcConfig returns the container defaults from dockroot.json.
+// info may be nil, in which case the host's settings are used.
+func (info *registryInfo) etcConfig() etcConfig {
+	if info == nil {
+		return etcConfig{}
+	}
+	return etcConfig{dns: info.DNS, dnsSearch: info.DNSSearch, tz: info.Timezone}
+}
+
+// apply writes /etc/resolv.conf and /etc/hosts into rootfs, and binds /etc/localtime through ruriInfo if a timezone is set.
+func (conf etcConfig) apply(rootfs string, ruriInfo *RuriInfo) error {
+	hosts, err := renderHosts(ruriInfo.Hostname, conf.addHosts)
+	if err != nil {
+		return err
+	}
+	var localtime string
+	if conf.tz != "" {
+		if localtime, err = localtimeSource(conf.tz); err != nil {
+			return err
+		}
+	}
+
+	hostResolv, err := os.ReadFile(hostResolvConf)
+	if err != nil && len(conf.dns) == 0 {
+		logrus.Warnf("Unable to read %s, using nameserver %s: %v", hostResolvConf, fallbackNameserver, err)
+	}
+	if err := writeRootfsFile(rootfs, "etc/resolv.conf", renderResolvConf(hostResolv, conf.dns, conf.dnsSearch)); err != nil {
+		return err
+	}
+	if err := writeRootfsFile(rootfs, "etc/hosts", hosts); err != nil {
+		return err
+	}
+
+	if localtime != "" {
+		// ruri needs something to mount on; an empty file also replaces any symlink into the image's zoneinfo.
+		if err := writeRootfsFile(rootfs, "etc/localtime", nil); err != nil {
+			return err
+		}
+		ruriInfo.ExtraRoMountpoints = append(ruriInfo.ExtraRoMountpoints, localtime, hostLocaltime)
+		if conf.tz != tzHost && !hasRuriEnv(ruriInfo, "TZ") {
+			ruriInfo.Envs = append(ruriInfo.Envs, "TZ", conf.tz)
+		}
+	}
+	return nil
+}
+
+// renderResolvConf returns resolv.conf contents based on the host's, with dns and search replacing its nameservers and search domains if set.
+func renderResolvConf(host []byte, dns, search []string) []byte {
+	var nameservers, hostSearch, options []string
+	scanner := bufio.NewScanner(bytes.NewReader(host))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			nameservers = append(nameservers, fields[1])
+		case "search", "domain":
+			hostSearch = fields[1:]
+		case "options":
+			options = append(options, strings.Join(fields[1:], " "))
+		}
+	}
+	if len(dns) > 0 {
+		nameservers = dns
+	}
+	if len(nameservers) == 0 {
+		nameservers = []string{fallbackNameserver}
+	}
+	if len(search) == 0 {
+		search = hostSearch
+	}
+
+	buf := &bytes.Buffer{}
+	// As with docker, a search domain of "." means none.
+	if search = slices.DeleteFunc(slices.Clone(search), func(s string) bool { return s == "." }); len(search) > 0 {
+		fmt.Fprintf(buf, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ns := range nameservers {
+		fmt.Fprintf(buf, "nameserver %s\n", ns)
+	}
+	for _, o := range options {
+		fmt.Fprintf(buf, "options %s\n", o)
+	}
+	return buf.Bytes()
+}
+
+// renderHosts returns /etc/hosts contents naming the container hostname, plus the HOST:IP entries in addHosts.
+func renderHosts(hostname string, addHosts []string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("127.0.0.1\tlocalhost\n")
+	buf.WriteString("::1\tlocalhost ip6-localhost ip6-loopback\n")
+	if hostname != "" && hostname != "localhost" {
+		fmt.Fprintf(buf, "127.0.1.1\t%s\n", hostname)
+	}
+	for _, h := range addHosts {
+		host, ip, err := parseAddHost(h)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(buf, "%s\t%s\n", ip, host)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseAddHost parses an --add-host value, HOST:IP or HOST=IP; IPv6 addresses need not be bracketed.
+func parseAddHost(s string) (host, ip string, err error) {
+	host, ip, ok := strings.Cut(s, "=")
+	if !ok {
+		host, ip, ok = strings.Cut(s, ":")
+	}
+	ip = strings.TrimSuffix(strings.TrimPrefix(ip, "["), "]")
+	if !ok || host == "" || net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("Invalid --add-host %q, expected HOST:IP", s)
+	}
+	return host, ip, nil
+}
+
+// localtimeSource returns the host file to bind at /etc/localtime for tz.
+func localtimeSource(tz string) (string, error) {
+	path := hostLocaltime
+	if tz != tzHost {
+		if !filepath.IsLocal(tz) {
+			return "", fmt.Errorf("Invalid timezone %q", tz)
+		}
+		path = filepath.Join(zoneinfoDir, tz)
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("Timezone %q is not available on this host: %w", tz, err)
+	}
+	return path, nil
+}
+
+// writeRootfsFile replaces the file at rel in rootfs with data.
+// Whatever was there is removed first, so that a symlink in the image can not redirect the write to the host.
+func writeRootfsFile(rootfs, rel string, data []byte) error {
+	path := filepath.Join(rootfs, rel)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// hasRuriEnv returns true if ruriInfo sets the environment variable name.
+func hasRuriEnv(ruriInfo *RuriInfo, name string) bool {
+	for i := 0; i+1 < len(ruriInfo.Envs); i += 2 {
+		if ruriInfo.Envs[i] == name {
+			return true
+		}
+	}
+	return false
+}
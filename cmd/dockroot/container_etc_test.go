@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderResolvConf(t *testing.T) {
+	host := []byte("# Generated\nsearch lan corp.example\nnameserver 192.168.1.1\nnameserver fd00::1\noptions ndots:2 edns0\n")
+
+	// By default the host's settings are copied.
+	assert.Equal(t, "search lan corp.example\nnameserver 192.168.1.1\nnameserver fd00::1\noptions ndots:2 edns0\n",
+		string(renderResolvConf(host, nil, nil)))
+	// --dns and --dns-search replace the host's.
+	assert.Equal(t, "search example.com\nnameserver 10.0.0.53\noptions ndots:2 edns0\n",
+		string(renderResolvConf(host, []string{"10.0.0.53"}, []string{"example.com"})))
+	// "." drops the search domains.
+	assert.Equal(t, "nameserver 192.168.1.1\nnameserver fd00::1\noptions ndots:2 edns0\n",
+		string(renderResolvConf(host, nil, []string{"."})))
+	// Without any nameserver, the old default is used.
+	assert.Equal(t, "nameserver "+fallbackNameserver+"\n", string(renderResolvConf(nil, nil, nil)))
+}
+
+func TestParseAddHost(t *testing.T) {
+	for _, c := range []struct{ in, host, ip string }{
+		{"nas:192.168.1.2", "nas", "192.168.1.2"},
+		{"nas=192.168.1.2", "nas", "192.168.1.2"},
+		{"v6:fd00::2", "v6", "fd00::2"},
+		{"v6=[fd00::2]", "v6", "fd00::2"},
+	} {
+		host, ip, err := parseAddHost(c.in)
+		require.NoError(t, err, c.in)
+		assert.Equal(t, c.host, host)
+		assert.Equal(t, c.ip, ip)
+	}
+	for _, in := range []string{"nas", ":192.168.1.2", "nas:not-an-ip"} {
+		_, _, err := parseAddHost(in)
+		assert.ErrorContains(t, err, "Invalid --add-host", in)
+	}
+}
+
+func TestEtcConfigApply(t *testing.T) {
+	rootfs := t.TempDir()
+	// A symlink in the image must not redirect writes to the host.
+	outside := filepath.Join(t.TempDir(), "outside")
+	require.NoError(t, os.WriteFile(outside, []byte("untouched"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(rootfs, "etc"), 0755))
+	require.NoError(t, os.Symlink(outside, filepath.Join(rootfs, "etc", "resolv.conf")))
+
+	ruriInfo := DefaultRuriInfo()
+	ruriInfo.Hostname = "alpine001"
+	conf := etcConfig{dns: []string{"10.0.0.53"}, addHosts: []string{"nas:192.168.1.2"}}
+	require.NoError(t, conf.apply(rootfs, ruriInfo))
+
+	b, err := os.ReadFile(filepath.Join(rootfs, "etc", "resolv.conf"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "nameserver 10.0.0.53\n")
+	b, err = os.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Equal(t, "untouched", string(b))
+	b, err = os.ReadFile(filepath.Join(rootfs, "etc", "hosts"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "127.0.1.1\talpine001\n")
+	assert.Contains(t, string(b), "192.168.1.2\tnas\n")
+	assert.Empty(t, ruriInfo.ExtraRoMountpoints)
+
+	// An invalid entry fails before anything is written.
+	err = etcConfig{addHosts: []string{"bad"}}.apply(t.TempDir(), ruriInfo)
+	assert.ErrorContains(t, err, "Invalid --add-host")
+	err = etcConfig{tz: "../../etc/shadow"}.apply(rootfs, ruriInfo)
+	assert.ErrorContains(t, err, "Invalid timezone")
+
+	if _, err := os.Stat(hostLocaltime); err == nil {
+		ruriInfo = DefaultRuriInfo()
+		require.NoError(t, etcConfig{tz: tzHost}.apply(rootfs, ruriInfo))
+		assert.Equal(t, []string{hostLocaltime, hostLocaltime}, ruriInfo.ExtraRoMountpoints)
+		assert.False(t, hasRuriEnv(ruriInfo, "TZ"))
+	}
+	if _, err := os.Stat(filepath.Join(zoneinfoDir, "UTC")); err == nil {
+		ruriInfo = DefaultRuriInfo()
+		require.NoError(t, etcConfig{tz: "UTC"}.apply(rootfs, ruriInfo))
+		assert.Equal(t, []string{filepath.Join(zoneinfoDir, "UTC"), hostLocaltime}, ruriInfo.ExtraRoMountpoints)
+		assert.Equal(t, []string{"TZ", "UTC"}, ruriInfo.Envs)
+	}
+}
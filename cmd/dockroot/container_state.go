@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// containerStateFile records, in the container directory, the lifecycle of the container: what it was created from,
+// whether it runs, how its runs ended, and how it is supervised.
+const containerStateFile = "state.json"
+
+// Container statuses, as in docker.
+const (
+	containerCreated = "created"
+	containerRunning = "running"
+	containerExited  = "exited"
+	containerDead    = "dead" // rm failed to tear the container down; only rm may be tried again
+)
+
+// containerTransitions lists the statuses a container may move to from each status.
+var containerTransitions = map[string][]string{
+	containerCreated: {containerRunning, containerDead},
+	containerRunning: {containerExited, containerDead},
+	containerExited:  {containerRunning, containerDead},
+	containerDead:    {containerExited},
+}
+
+// exitCodeUnknown is the exit code of a container whose process went away without its exit being recorded,
+// as when the host rebooted.
+const exitCodeUnknown = 255
+
+// containerState is the content of containerStateFile.
+type containerState struct {
+	Status      string    `json:"status"`
+	Pid         int       `json:"pid,omitempty"`       // Of ruri, while the container runs
+	PidStart    uint64    `json:"pid-start,omitempty"` // Start time of Pid, to tell it from a later process with the same PID
+	StartedAt   time.Time `json:"started-at"`
+	FinishedAt  time.Time `json:"finished-at"`
+	ExitCode    int       `json:"exit-code"` // Of the last run
+	Image       string    `json:"image,omitempty"`
+	ImageDigest string    `json:"image-digest,omitempty"`
+	Created     time.Time `json:"created"`
+
+	RestartPolicy string `json:"restart-policy,omitempty"` // Of the last run -d
+	RestartCount  int    `json:"restart-count"`            // Restarts since the last run -d
+	ManualStop    bool   `json:"manual-stop,omitempty"`    // Set by stop, so that the container is not restarted
+	SupervisorPid int    `json:"supervisor-pid,omitempty"` // While a supervisor runs the container
+}
+
+// readContainerState returns the state of the container in containerDir. Containers from before state files were
+// kept have none, and count as created.
+func readContainerState(containerDir string) (*containerState, error) {
+	data, err := os.ReadFile(filepath.Join(containerDir, containerStateFile))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return &containerState{Status: containerCreated}, nil
+		}
+		return nil, err
+	}
+	var state containerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("Invalid %s: %w", filepath.Join(containerDir, containerStateFile), err)
+	}
+	if state.Status == "" {
+		state.Status = containerCreated
+	}
+	return &state, nil
+}
+
+// updateContainerState changes the state of the container in containerDir with fn, under a lock so that the
+// changes of run, stop, rm and the supervisor do not overwrite each other. The state is not written if fn fails.
+func updateContainerState(containerDir string, fn func(*containerState) error) error {
+	f, err := os.OpenFile(filepath.Join(containerDir, containerStateFile+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("locking container state: %w", err)
+	}
+	defer func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	}()
+
+	state, err := readContainerState(containerDir)
+	if err != nil {
+		return err
+	}
+	if err := fn(state); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Written atomically, for readers which do not take the lock.
+	path := filepath.Join(containerDir, containerStateFile)
+	if err := os.WriteFile(path+".syn", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".syn", path)
+}
+
+// setStatus moves s to status, if its status allows.
+func (s *containerState) setStatus(status string) error {
+	if !slices.Contains(containerTransitions[s.Status], status) {
+		return fmt.Errorf("Container is %s, cannot become %s", s.Status, status)
+	}
+	s.Status = status
+	return nil
+}
+
+// start records that the container runs as pid since now.
+func (s *containerState) start(pid int, now time.Time) error {
+	s.settle()
+	switch s.Status {
+	case containerRunning:
+		return errors.New("Container is already running")
+	case containerDead:
+		return errors.New("Container is dead, rm it first")
+	}
+	if err := s.setStatus(containerRunning); err != nil {
+		return err
+	}
+	s.Pid, s.StartedAt = pid, now
+	s.PidStart, _ = processStartTime(pid)
+	return nil
+}
+
+// exit records that the container exited with exitCode at now.
+func (s *containerState) exit(exitCode int, now time.Time) error {
+	if err := s.setStatus(containerExited); err != nil {
+		return err
+	}
+	s.Pid, s.PidStart = 0, 0
+	s.ExitCode, s.FinishedAt = exitCode, now
+	return nil
+}
+
+// settle records a container whose process went away without anyone recording its exit as exited.
+func (s *containerState) settle() {
+	if s.Status != containerRunning || s.running() {
+		return
+	}
+	s.Status = containerExited
+	s.Pid, s.PidStart = 0, 0
+	s.ExitCode = exitCodeUnknown
+}
+
+// running returns true if the process of a running container is still there.
+func (s *containerState) running() bool {
+	if s.Pid == 0 || !processAlive(s.Pid) {
+		return false
+	}
+	start, err := processStartTime(s.Pid)
+	return err != nil || s.PidStart == 0 || start == s.PidStart
+}
+
+// supervised returns true if a supervisor runs the container, or waits to restart it.
+func (s *containerState) supervised() bool {
+	return s.SupervisorPid != 0 && processAlive(s.SupervisorPid) && processCommandContains(s.SupervisorPid, "supervise")
+}
+
+// current returns s as it is now, with a container whose process went away counted as exited.
+func (s containerState) current() *containerState {
+	s.settle()
+	return &s
+}
+
+// containerInspect is what inspect shows for a container.
+type containerInspect struct {
+	Name  string          `json:"name"`
+	Dir   string          `json:"dir"`
+	State *containerState `json:"state"`
+}
+
+// inspectContainer returns what inspect shows for the container name, or nil if there is no container by that name.
+func (opts *globalOptions) inspectContainer(name string) (*containerInspect, error) {
+	// Image names with a registry, repository path, tag or transport are never container names.
+	if name == "" || name != CleanString(name) || strings.ContainsAny(name, `/\`) {
+		return nil, nil
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return nil, err
+	}
+	info, err := opts.readRegistryInfo(binaryDir)
+	if err != nil {
+		// Without dockroot.json there are no containers, but images can still be inspected.
+		return nil, nil
+	}
+	dir, err := filepath.Abs(filepath.Join(info.DataRoot, name))
+	if err != nil {
+		return nil, err
+	}
+	if !isDirValid(dir) {
+		return nil, nil
+	}
+	state, err := readContainerState(dir)
+	if err != nil {
+		return nil, err
+	}
+	state = state.current()
+	if state.Image == "" {
+		if img, err := readContainerImage(dir); err == nil {
+			state.Image, state.ImageDigest = img.Name, img.Digest
+		}
+	}
+	return &containerInspect{Name: name, Dir: dir, State: state}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerState(t *testing.T) {
+	dir := t.TempDir()
+	state, err := readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerCreated, state.Status)
+
+	created := time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		*s = containerState{Status: containerCreated, Image: "docker.io/library/alpine:latest", Created: created}
+		return nil
+	}))
+	// Exiting needs running first, and a failed change is not written.
+	err = updateContainerState(dir, func(s *containerState) error {
+		s.RestartCount = 5
+		return s.exit(1, time.Now())
+	})
+	assert.Error(t, err)
+
+	child := exec.Command("sleep", "30")
+	require.NoError(t, child.Start())
+	defer func() {
+		_ = child.Process.Kill()
+		_ = child.Wait()
+	}()
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.start(child.Process.Pid, time.Now())
+	}))
+	assert.Error(t, updateContainerState(dir, func(s *containerState) error {
+		return s.start(os.Getpid(), time.Now())
+	}))
+	state, err = readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerRunning, state.current().Status)
+	assert.Zero(t, state.RestartCount)
+	assert.Equal(t, created, state.Created)
+
+	// A process which went away unrecorded counts as exited, and the container can start again.
+	require.NoError(t, child.Process.Kill())
+	_ = child.Wait()
+	state, err = readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerRunning, state.Status)
+	assert.Equal(t, containerExited, state.current().Status)
+	assert.Equal(t, exitCodeUnknown, state.current().ExitCode)
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.start(os.Getpid(), time.Now())
+	}))
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.exit(3, time.Now())
+	}))
+
+	// Only rm may bring a dead container back.
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.setStatus(containerDead)
+	}))
+	assert.Error(t, updateContainerState(dir, func(s *containerState) error {
+		return s.start(os.Getpid(), time.Now())
+	}))
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.setStatus(containerExited)
+	}))
+	state, err = readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerExited, state.Status)
+	assert.Equal(t, 3, state.ExitCode)
+}
+
+func TestWaitContainer(t *testing.T) {
+	dir := t.TempDir()
+	child := exec.Command("sleep", "30")
+	require.NoError(t, child.Start())
+	defer func() {
+		_ = child.Process.Kill()
+		_ = child.Wait()
+	}()
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		return s.start(child.Process.Pid, time.Now())
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err := waitContainer(ctx, dir)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = updateContainerState(dir, func(s *containerState) error {
+			return s.exit(7, time.Now())
+		})
+	}()
+	state, err := waitContainer(context.Background(), dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerExited, state.Status)
+	assert.Equal(t, 7, state.ExitCode)
+}
+
+func TestWriteContainerTable(t *testing.T) {
+	now := time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)
+	rows := []containerRow{
+		{name: "web", state: &containerState{Status: containerRunning, Image: "docker.io/library/nginx:latest",
+			StartedAt: now.Add(-5 * time.Minute), Created: now.Add(-time.Hour)}},
+		{name: "job", state: &containerState{Status: containerExited, ExitCode: 3, Image: "registry.example.com/team/job:1.0",
+			FinishedAt: now.Add(-2 * time.Second), Created: now.Add(-time.Hour)}, restarting: true},
+		{name: "old", state: &containerState{Status: containerCreated}},
+		{name: "gone", state: &containerState{Status: containerDead, Image: "docker.io/library/alpine:3.20"}},
+	}
+	var out bytes.Buffer
+	require.NoError(t, writeContainerTable(&out, rows, now))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	require.Len(t, lines, 5)
+	assert.Equal(t, []string{"NAME", "IMAGE", "STATUS", "CREATED"}, strings.Fields(lines[0]))
+	assert.Regexp(t, `^web\s+nginx:latest\s+Up 5 minutes\s+About an hour ago$`, lines[1])
+	assert.Regexp(t, `^job\s+registry.example.com/team/job:1.0\s+Restarting \(3\) 2 seconds ago\s+About an hour ago$`, lines[2])
+	assert.Regexp(t, `^old\s+<none>\s+Created\s+N/A$`, lines[3])
+	assert.Regexp(t, `^gone\s+alpine:3.20\s+Dead\s+N/A$`, lines[4])
+}
+
+func TestRunContainer(t *testing.T) {
+	dir := t.TempDir()
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", `sleep 0.2; cat "$1"; exit 4`, "sh", dir+"/"+containerStateFile)
+	cmd.Stdout = &out
+	assert.Equal(t, exitCodeError(4), runContainer(dir, cmd))
+	// The command ran while the container was recorded as running.
+	assert.Contains(t, out.String(), `"status": "running"`)
+	state, err := readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerExited, state.Status)
+	assert.Equal(t, 4, state.ExitCode)
+	assert.Zero(t, state.Pid)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+type createOptions struct {
+	global *globalOptions
+	name   string
+}
+
+func createCmd(global *globalOptions) *cobra.Command {
+	opts := createOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "create --name NAME IMAGE[:TAG|@DIGEST]",
+		Short: "create a container from an image in the image store",
+		RunE:  commandAction(opts.run),
+		Example: `DockRoot pull alpine:latest
+DockRoot create --name alpine001 alpine:latest
+DockRoot create --name alpine002 sha256:<digest>`,
+	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.name, "name", "", "Name of the container")
+	return cmd
+}
+
+func (opts *createOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 || opts.name == "" {
+		return fmt.Errorf("Usage: %s create --name NAME IMAGE[:TAG|@DIGEST]", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	name := CleanString(opts.name)
+	if name == "" {
+		return fmt.Errorf("Invalid container name %q", opts.name)
+	}
+	destDir := filepath.Join(info.DataRoot, name)
+	if _, err := os.Stat(destDir); err == nil {
+		return fmt.Errorf("Container %s already exists", name)
+	}
+
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	img, err := resolveStoreImage(imageStoreDir(info.DataRoot), args[0])
+	if err != nil {
+		return err
+	}
+	if err := os.Mkdir(destDir, 0755); err != nil {
+		return err
+	}
+	if err := createContainer(ruriPath, info, img, destDir, ""); err != nil {
+		unmountContainerRootfs(destDir)
+		os.RemoveAll(destDir)
+		return err
+	}
+	fmt.Fprintf(stdout, "Container %s created from %s\n", name, img.Name)
+	return nil
+}
+
+// createContainer unpacks img from the image store into destDir, with the storage mode in info, and writes its ruri.conf.
+// The caller holds the image store lock.
+func createContainer(ruriPath string, info *registryInfo, img *containerImage, destDir, hostname string) error {
+	storage, err := info.storage()
+	if err != nil {
+		return err
+	}
+	if err := unpackContainer(info.DataRoot, img, destDir, storage); err != nil {
+		return err
+	}
+	if err := writeContainerImage(destDir, img); err != nil {
+		return err
+	}
+	if err := updateContainerState(destDir, func(s *containerState) error {
+		*s = containerState{Status: containerCreated, Image: img.Name, ImageDigest: img.Digest, Created: time.Now()}
+		return nil
+	}); err != nil {
+		return err
+	}
+	destAbsDir, err := filepath.Abs(destDir)
+	if err != nil {
+		return err
+	}
+	return writeRuri(ruriPath, destAbsDir, hostname, "", "", nil, nil, info.etcConfig())
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/containers/image/v5/pkg/compression"
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/docker/go-units"
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci/oci/casext"
+	"github.com/opencontainers/umoci/pkg/funchelpers"
+	"github.com/spf13/cobra"
+)
+
+// dedupeSkipDirs are the rootfs directories whose files dedupe leaves alone, besides the image's volumes.
+// Programs tend to rewrite files there in place, which would change the file in every container linking it.
+var dedupeSkipDirs = []string{"etc", "home", "root", "run", "tmp", "var"}
+
+// dedupeChangedDir is where dedupe --verify keeps, in the container directory, the content of linked files changed
+// in place, before restoring them from their layer.
+const dedupeChangedDir = "dedupe-changed"
+
+type dedupeOptions struct {
+	global *globalOptions
+	verify bool
+}
+
+func dedupeCmd(global *globalOptions) *cobra.Command {
+	opts := dedupeOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "dedupe [NAME...]",
+		Short: "hardlink identical image files across the rootfs of containers",
+		Long: `Hardlink the files containers got unchanged from the same image layer, to save disk
+where the overlay storage is unavailable. Files in ` + strings.Join(dedupeSkipDirs, ", ") + ` and in the
+image's volumes are left alone.
+
+Linked files are shared: a program rewriting one in place changes it in every container.
+Replacing it, as package managers do, is safe. dedupe --verify finds linked files which no
+longer match their layer and restores every container's copy from the image store, keeping
+the changed content in ` + dedupeChangedDir + ` in each container directory.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot dedupe
+DockRoot dedupe --verify homeassistant001 homeassistant002`,
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&opts.verify, "verify", false, "Check that linked files still match their layer, restoring changed ones")
+	return cmd
+}
+
+func (opts *dedupeOptions) run(args []string, stdout io.Writer) error {
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	names := args
+	if len(names) == 0 {
+		entries, err := os.ReadDir(info.DataRoot)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+	}
+	var containers []string
+	for _, name := range names {
+		dir := filepath.Join(info.DataRoot, CleanString(name))
+		if !isDirValid(dir) {
+			if len(args) > 0 {
+				return fmt.Errorf("Container %s does not exist", name)
+			}
+			continue
+		}
+		containers = append(containers, dir)
+	}
+
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	groups, err := dedupeGroups(imageStoreDir(info.DataRoot), containers)
+	if err != nil {
+		return err
+	}
+	if opts.verify {
+		changed, err := verifyDedupe(imageStoreDir(info.DataRoot), groups)
+		for _, p := range changed {
+			fmt.Fprintf(stdout, "Changed in place, restored from its layer: %s\n", p)
+		}
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			return fmt.Errorf("%d linked files no longer matched their layer, their changed content is in %s in each container directory", len(changed), dedupeChangedDir)
+		}
+		fmt.Fprintln(stdout, "All linked files match their layer")
+		return nil
+	}
+	files, saved, err := dedupeFiles(groups)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Linked %d files, saved %s\n", files, units.HumanSize(float64(saved)))
+	return nil
+}
+
+// layerFile is a regular file in a layer blob, which is the same in every rootfs unpacked from the layer until changed.
+type layerFile struct {
+	layer digest.Digest
+	name  string // Cleaned path in the rootfs, without a leading "/"
+}
+
+// layerFileContent is what a layerFile holds.
+type layerFileContent struct {
+	digest digest.Digest
+	size   int64
+}
+
+// dedupeGroup is a layerFile and its copies in the containers.
+type dedupeGroup struct {
+	content    layerFileContent
+	paths      []string
+	containers []string // Container directory of each of paths
+}
+
+// dedupeGroups returns the files of the copy storage containers which come from the same layer files.
+// Overlay storage containers already share their image, and are skipped.
+func dedupeGroups(storeDir string, containers []string) (_ map[layerFile]*dedupeGroup, Err error) {
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+
+	ctx := context.Background()
+	layers := map[digest.Digest]map[string]*layerFileContent{}
+	images := map[string]map[string]layerFile{}
+	groups := map[layerFile]*dedupeGroup{}
+	for _, containerDir := range containers {
+		img, err := readContainerImage(containerDir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if img.Storage == storageOverlay {
+			continue
+		}
+		files, ok := images[img.Digest]
+		if !ok {
+			files, err = imageLayerFiles(ctx, engineExt, img.Digest, layers)
+			if err != nil {
+				return nil, fmt.Errorf("reading image of %s: %w", filepath.Base(containerDir), err)
+			}
+			images[img.Digest] = files
+		}
+		skip := dedupeSkipDirs
+		if imgConfig, err := getImageConfig(filepath.Join(containerDir, imageConfigFile)); err == nil {
+			for vol := range imgConfig.Config.Volumes {
+				skip = append(skip, strings.TrimPrefix(path.Clean("/"+vol), "/"))
+			}
+		}
+		rootfs := filepath.Join(containerDir, "rootfs")
+		for name, lf := range files {
+			if underAny(name, skip) {
+				continue
+			}
+			// Symlinks in the rootfs are resolved inside it, so that they can not point the links at the host.
+			dir, err := securejoin.SecureJoin(rootfs, path.Dir(name))
+			if err != nil {
+				return nil, err
+			}
+			g, ok := groups[lf]
+			if !ok {
+				g = &dedupeGroup{content: *layers[lf.layer][lf.name]}
+				groups[lf] = g
+			}
+			g.paths = append(g.paths, filepath.Join(dir, path.Base(name)))
+			g.containers = append(g.containers, containerDir)
+		}
+	}
+	return groups, nil
+}
+
+// underAny reports whether name is one of dirs or inside one of them.
+func underAny(name string, dirs []string) bool {
+	for _, dir := range dirs {
+		if dir == "" || name == dir || strings.HasPrefix(name, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// imageLayerFiles returns the regular files of the rootfs unpacked from the image with manifestDigest, by the layer
+// file each comes from. layers caches the files of each layer blob.
+func imageLayerFiles(ctx context.Context, engineExt casext.Engine, manifestDigest string, layers map[digest.Digest]map[string]*layerFileContent) (_ map[string]layerFile, Err error) {
+	desc, err := resolveStoreReference(ctx, engineExt, manifestDigest)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := engineExt.FromDescriptor(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, blob)
+	m, ok := blob.Data.(imgspecv1.Manifest)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an image manifest: %s", desc.Digest, desc.MediaType)
+	}
+
+	files := map[string]layerFile{}
+	for _, l := range m.Layers {
+		entries, ok := layers[l.Digest]
+		if !ok {
+			entries, err = readLayerFiles(ctx, engineExt, l.Digest)
+			if err != nil {
+				return nil, err
+			}
+			layers[l.Digest] = entries
+		}
+		applyLayerFiles(files, l.Digest, entries)
+	}
+	return files, nil
+}
+
+// applyLayerFiles updates files, the regular files of a rootfs by the layer file each comes from, for unpacking
+// the layer with layerDigest and entries on top.
+func applyLayerFiles(files map[string]layerFile, layerDigest digest.Digest, entries map[string]*layerFileContent) {
+	// Whiteouts and anything else in this layer hide the files below.
+	for name, c := range entries {
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		switch {
+		case base == ".wh..wh..opq":
+			for lower := range files {
+				if dir == "" || strings.HasPrefix(lower, dir+"/") {
+					delete(files, lower)
+				}
+			}
+		case strings.HasPrefix(base, ".wh."):
+			hidden := path.Join(dir, strings.TrimPrefix(base, ".wh."))
+			for lower := range files {
+				if lower == hidden || strings.HasPrefix(lower, hidden+"/") {
+					delete(files, lower)
+				}
+			}
+		case c == nil:
+			delete(files, name)
+		}
+	}
+	for name, c := range entries {
+		if c != nil && !strings.HasPrefix(path.Base(name), ".wh.") {
+			files[name] = layerFile{layer: layerDigest, name: name}
+		}
+	}
+}
+
+// readLayerFiles returns the entries of the layer blob with layerDigest by cleaned path, with the content of regular
+// files and nil for anything else.
+func readLayerFiles(ctx context.Context, engineExt casext.Engine, layerDigest digest.Digest) (_ map[string]*layerFileContent, Err error) {
+	blob, err := engineExt.GetBlob(ctx, layerDigest)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, blob)
+	r, _, err := compression.AutoDecompress(blob)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, r)
+
+	entries := map[string]*layerFileContent{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading layer %s: %w", layerDigest, err)
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		if name == "" {
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			entries[name] = nil
+			continue
+		}
+		d, err := digest.SHA256.FromReader(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries[name] = &layerFileContent{digest: d, size: hdr.Size}
+	}
+}
+
+// matchesLayer reports whether p is a regular file still holding c.
+func matchesLayer(p string, st os.FileInfo, c layerFileContent) (bool, error) {
+	if !st.Mode().IsRegular() || st.Size() != c.size {
+		return false, nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	d, err := digest.SHA256.FromReader(f)
+	if err != nil {
+		return false, err
+	}
+	return d == c.digest, nil
+}
+
+// dedupeFiles hardlinks the copies of each group which still match their layer file, and returns how many files were
+// linked and how many bytes that freed. Copies with other owners or permissions are left alone.
+func dedupeFiles(groups map[layerFile]*dedupeGroup) (files int, saved int64, Err error) {
+	for _, g := range groups {
+		var target string
+		var targetSt os.FileInfo
+		for _, p := range g.paths {
+			st, err := os.Lstat(p)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return files, saved, err
+			}
+			if targetSt != nil && os.SameFile(targetSt, st) {
+				continue
+			}
+			if targetSt != nil && !sameFileMeta(targetSt, st) {
+				continue
+			}
+			ok, err := matchesLayer(p, st, g.content)
+			if err != nil {
+				return files, saved, err
+			}
+			if !ok {
+				continue
+			}
+			if targetSt == nil {
+				target, targetSt = p, st
+				continue
+			}
+			tmp := p + ".dedupe"
+			if err := os.Link(target, tmp); err != nil {
+				return files, saved, err
+			}
+			if err := os.Rename(tmp, p); err != nil {
+				os.Remove(tmp)
+				return files, saved, err
+			}
+			files++
+			if st.Sys().(*syscall.Stat_t).Nlink == 1 {
+				saved += st.Size()
+			}
+		}
+	}
+	return files, saved, nil
+}
+
+// sameFileMeta reports whether a and b can be one inode without either container noticing.
+func sameFileMeta(a, b os.FileInfo) bool {
+	sa, sb := a.Sys().(*syscall.Stat_t), b.Sys().(*syscall.Stat_t)
+	return sa.Dev == sb.Dev && a.Mode() == b.Mode() && sa.Uid == sb.Uid && sa.Gid == sb.Gid
+}
+
+// verifyDedupe checks the hardlinked copies of each group against their layer file. A change in place reached every
+// container linking the file, and which one made it cannot be told: each changed copy is restored from its layer in
+// the image store in storeDir, with the changed content kept under dedupeChangedDir in the container directory.
+// It returns the changed copies.
+func verifyDedupe(storeDir string, groups map[layerFile]*dedupeGroup) (_ []string, Err error) {
+	changed := map[digest.Digest]map[string]*changedFile{}
+	var paths []string
+	for lf, g := range groups {
+		for i, p := range g.paths {
+			st, err := os.Lstat(p)
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			if !st.Mode().IsRegular() || st.Sys().(*syscall.Stat_t).Nlink == 1 {
+				continue
+			}
+			ok, err := matchesLayer(p, st, g.content)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+			if changed[lf.layer] == nil {
+				changed[lf.layer] = map[string]*changedFile{}
+			}
+			f := changed[lf.layer][lf.name]
+			if f == nil {
+				f = &changedFile{content: g.content}
+				changed[lf.layer][lf.name] = f
+			}
+			f.copies = append(f.copies, changedCopy{container: g.containers[i], path: p, st: st})
+			paths = append(paths, p)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	slices.Sort(paths)
+
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return paths, err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	for layerDigest, files := range changed {
+		if err := restoreLayerFiles(context.Background(), engineExt, layerDigest, files); err != nil {
+			return paths, err
+		}
+	}
+	return paths, nil
+}
+
+// changedFile is a layer file whose linked copies were changed in place.
+type changedFile struct {
+	content layerFileContent
+	copies  []changedCopy
+}
+
+// changedCopy is a linked copy of a layer file in a container, which no longer matches the layer.
+type changedCopy struct {
+	container string
+	path      string
+	st        os.FileInfo
+}
+
+// restoreLayerFiles restores the changed copies of files, by cleaned path, from the layer blob with layerDigest.
+func restoreLayerFiles(ctx context.Context, engineExt casext.Engine, layerDigest digest.Digest, files map[string]*changedFile) (Err error) {
+	blob, err := engineExt.GetBlob(ctx, layerDigest)
+	if err != nil {
+		return err
+	}
+	defer funchelpers.VerifyClose(&Err, blob)
+	r, _, err := compression.AutoDecompress(blob)
+	if err != nil {
+		return err
+	}
+	defer funchelpers.VerifyClose(&Err, r)
+
+	tr := tar.NewReader(r)
+	for len(files) > 0 {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return fmt.Errorf("layer %s lacks %d changed files", layerDigest, len(files))
+		}
+		if err != nil {
+			return fmt.Errorf("reading layer %s: %w", layerDigest, err)
+		}
+		name := strings.TrimPrefix(path.Clean("/"+hdr.Name), "/")
+		f, ok := files[name]
+		if !ok || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := restoreChangedFile(tr, name, f); err != nil {
+			return err
+		}
+		delete(files, name)
+	}
+	return nil
+}
+
+// restoreChangedFile replaces each copy of f with the layer content read from r, after keeping the changed content
+// as name under dedupeChangedDir in its container directory.
+func restoreChangedFile(r io.Reader, name string, f *changedFile) (Err error) {
+	// The layer content is written out once, and copied from there.
+	tmp, err := os.CreateTemp(filepath.Dir(f.copies[0].path), ".dedupe-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+	verifier := f.content.digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(tmp, verifier), r); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("%s in its layer does not match %s", name, f.content.digest)
+	}
+
+	for _, c := range f.copies {
+		kept := filepath.Join(c.container, dedupeChangedDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(kept), 0755); err != nil {
+			return err
+		}
+		if err := os.Remove(kept); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Link(c.path, kept); err != nil {
+			return err
+		}
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := replaceFile(c.path, c.st, tmp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replaceFile replaces p with a file holding what src reads, with the owner, mode and times of st.
+func replaceFile(p string, st os.FileInfo, src io.Reader) (Err error) {
+	tmp := p + ".dedupe"
+	dst, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, st.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if Err != nil {
+			os.Remove(tmp)
+		}
+	}()
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	sys := st.Sys().(*syscall.Stat_t)
+	if err := dst.Chown(int(sys.Uid), int(sys.Gid)); err != nil {
+		dst.Close()
+		return err
+	}
+	// Chown drops setuid and setgid bits.
+	if err := dst.Chmod(st.Mode()); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp, st.ModTime(), st.ModTime()); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
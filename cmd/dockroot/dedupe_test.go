@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupe(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:latest"
+	addTestImage(t, storeDir, refName, map[string]string{
+		"usr/bin/app":  "application binary",
+		"usr/lib/libc": "shared library",
+		"etc/app.conf": "setting=1\n",
+		"data/seed":    "seed",
+	}, []string{"/data"})
+	c1 := unpackTestContainer(t, dataRoot, refName, "app001")
+	c2 := unpackTestContainer(t, dataRoot, refName, "app002")
+	c3 := unpackTestContainer(t, dataRoot, refName, "app003")
+	// A file changed in a container keeps its own copy.
+	require.NoError(t, os.WriteFile(filepath.Join(c3, "rootfs/usr/lib/libc"), []byte("patched library"), 0644))
+
+	groups, err := dedupeGroups(storeDir, []string{c1, c2, c3})
+	require.NoError(t, err)
+	files, saved, err := dedupeFiles(groups)
+	require.NoError(t, err)
+	assert.Equal(t, 3, files)
+	assert.Equal(t, int64(2*len("application binary")+len("shared library")), saved)
+
+	stat := func(p string) *syscall.Stat_t {
+		st, err := os.Stat(p)
+		require.NoError(t, err, p)
+		return st.Sys().(*syscall.Stat_t)
+	}
+	assert.EqualValues(t, 3, stat(filepath.Join(c1, "rootfs/usr/bin/app")).Nlink)
+	assert.EqualValues(t, 2, stat(filepath.Join(c1, "rootfs/usr/lib/libc")).Nlink)
+	assert.EqualValues(t, 1, stat(filepath.Join(c3, "rootfs/usr/lib/libc")).Nlink)
+	assert.EqualValues(t, 1, stat(filepath.Join(c1, "rootfs/etc/app.conf")).Nlink)
+	assert.EqualValues(t, 1, stat(filepath.Join(c1, "rootfs/data/seed")).Nlink)
+
+	// A second pass has nothing left to do.
+	files, saved, err = dedupeFiles(groups)
+	require.NoError(t, err)
+	assert.Zero(t, files)
+	assert.Zero(t, saved)
+
+	changed, err := verifyDedupe(storeDir, groups)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	// Writing in place changes every linked copy; verify restores them from the layer, keeping the change aside.
+	app := filepath.Join(c2, "rootfs/usr/bin/app")
+	require.NoError(t, os.WriteFile(app, []byte("rewritten in place"), 0755))
+	changed, err = verifyDedupe(storeDir, groups)
+	require.NoError(t, err)
+	assert.Len(t, changed, 3)
+	for _, c := range []string{c1, c2, c3} {
+		p := filepath.Join(c, "rootfs/usr/bin/app")
+		assert.EqualValues(t, 1, stat(p).Nlink)
+		content, err := os.ReadFile(p)
+		require.NoError(t, err)
+		assert.Equal(t, "application binary", string(content))
+		content, err = os.ReadFile(filepath.Join(c, dedupeChangedDir, "usr/bin/app"))
+		require.NoError(t, err)
+		assert.Equal(t, "rewritten in place", string(content))
+	}
+	// The library, linked in two containers, was left alone.
+	assert.EqualValues(t, 2, stat(filepath.Join(c1, "rootfs/usr/lib/libc")).Nlink)
+	changed, err = verifyDedupe(storeDir, groups)
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+}
+
+func TestImageLayerFiles(t *testing.T) {
+	storeDir := filepath.Join(t.TempDir(), imageStoreName)
+	const refName = "docker.io/library/app:latest"
+	addTestImage(t, storeDir, refName, map[string]string{"a/kept": "1", "a/gone": "2", "b/gone": "3"}, nil)
+	manifestDigest, err := tagImageDigest(storeDir, refName)
+	require.NoError(t, err)
+	engineExt, err := openImageStore(storeDir)
+	require.NoError(t, err)
+	defer engineExt.Close()
+
+	ctx := context.Background()
+	layers := map[digest.Digest]map[string]*layerFileContent{}
+	files, err := imageLayerFiles(ctx, engineExt, manifestDigest.String(), layers)
+	require.NoError(t, err)
+	assert.Len(t, files, 3)
+	assert.Equal(t, digest.FromString("1"), layers[files["a/kept"].layer]["a/kept"].digest)
+
+	// Whiteouts in a later layer hide files below, and its files replace theirs.
+	top := digest.FromString("top")
+	applyLayerFiles(files, top, map[string]*layerFileContent{
+		"a/.wh.gone":     {},
+		"b/.wh..wh..opq": {},
+		"b/new":          {digest: digest.FromString("4"), size: 1},
+		"a/kept":         {digest: digest.FromString("5"), size: 1},
+		"c":              nil,
+	})
+	assert.Equal(t, map[string]layerFile{
+		"a/kept": {layer: top, name: "a/kept"},
+		"b/new":  {layer: top, name: "b/new"},
+	}, files)
+}
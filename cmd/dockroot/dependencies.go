@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/sha256"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+const (
+	dependencyRuri     = "ruri"
+	dependencyKspeeder = "kspeeder"
+)
+
+// dependencyInfo pins a binary DockRoot downloads, in the dependencies section of dockroot.json.
+type dependencyInfo struct {
+	Version string            `json:"version,omitempty"` // Substituted for {version} in URL
+	URL     string            `json:"url,omitempty"`     // Download URL, with {arch} and {version} substituted
+	SHA256  map[string]string `json:"sha256,omitempty"`  // Expected hex SHA-256 digest of the binary, per architecture
+
+	arch          string // Asset name of this machine's platform, substituted for {arch} when downloading for it
+	allowUnpinned bool   // Accept downloads with no SHA-256 pinned for their architecture
+	latest        bool   // The latest build, for want of a release pinned in dependencies.json
+}
+
+// pinnedDependencies is the manifest of the ruri and kspeeder releases this DockRoot is tested with: their
+// versions, versioned download URLs and SHA-256 digests per architecture. pin-dependencies.sh updates it.
+//
+//go:embed dependencies.json
+var pinnedDependencies []byte
+
+// latestDependencyURLs are the unversioned downloads of the latest ruri and kspeeder builds.
+var latestDependencyURLs = map[string]string{
+	dependencyRuri:     RuriUrl + ".{arch}",
+	dependencyKspeeder: KspeederUrl + ".{arch}",
+}
+
+// defaultDependencies returns where the dependencies are downloaded from if dockroot.json says nothing else:
+// the releases pinned in dependencies.json, or the latest builds for those with no version pinned there.
+// Nothing being pinned for the latest builds, they are installed unverified, with a warning.
+func defaultDependencies() map[string]*dependencyInfo {
+	deps := map[string]*dependencyInfo{}
+	if err := json.Unmarshal(pinnedDependencies, &deps); err != nil {
+		panic(fmt.Sprintf("Invalid dependencies.json: %v", err))
+	}
+	for name, url := range latestDependencyURLs {
+		if dep := deps[name]; dep == nil || dep.Version == "" {
+			deps[name] = &dependencyInfo{URL: url, latest: true}
+		}
+	}
+	return deps
+}
+
+// dependency returns the settings for the named dependency, with defaults filled in.
+// info may be nil, in which case only the defaults are used.
+func (info *registryInfo) dependency(name string) *dependencyInfo {
+	dep := dependencyInfo{}
+	if d := defaultDependencies()[name]; d != nil {
+		dep = *d
+	}
+	if info != nil {
+		if d := info.Dependencies[name]; d != nil {
+			// Older versions of ensuredeps wrote the latest build URL to dockroot.json; that is not a choice of download.
+			// The pinned digests are only for the pinned download.
+			if d.URL != "" && d.URL != latestDependencyURLs[name] {
+				dep = dependencyInfo{URL: d.URL}
+			}
+			if d.Version != "" {
+				dep.Version = d.Version
+			}
+			if d.SHA256 != nil {
+				dep.SHA256 = d.SHA256
+			}
+		}
+		dep.allowUnpinned = info.AllowUnpinned
+	}
+	dep.arch = info.dependencyArch()
+	return &dep
+}
+
+// url returns the download URL for arch.
+func (dep *dependencyInfo) url(arch string) string {
+	return strings.NewReplacer("{arch}", arch, "{version}", dep.Version).Replace(dep.URL)
+}
+
+// digest returns the expected SHA-256 digest for arch, or "" if none is pinned.
+func (dep *dependencyInfo) digest(arch string) string {
+	return strings.ToLower(strings.TrimPrefix(dep.SHA256[arch], "sha256:"))
+}
+
+// pinnedDigest returns the SHA-256 digest a download of name for arch must have. With none pinned, it fails unless
+// unpinned downloads are allowed or dep is the latest build dependencies.json has no release pinned instead of,
+// and then returns "".
+func (dep *dependencyInfo) pinnedDigest(name, arch string) (string, error) {
+	expected := dep.digest(arch)
+	if expected == "" && !dep.allowUnpinned && !dep.latest {
+		return "", fmt.Errorf("No SHA-256 pinned for %s on %s: pin one under dependencies in dockroot.json, or set allow-unpinned-dependencies to install it unverified", name, arch)
+	}
+	return expected, nil
+}
+
+// matches returns true if the binary at path is the pinned one for arch, or if nothing is pinned.
+func (dep *dependencyInfo) matches(path, arch string) bool {
+	expected := dep.digest(arch)
+	if expected == "" {
+		return true
+	}
+	actual, err := fileSHA256(path)
+	return err == nil && actual == expected
+}
+
+// fileSHA256 returns the hex SHA-256 digest of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryInfoDependency(t *testing.T) {
+	var info *registryInfo
+	dep := info.dependency(dependencyRuri)
+	assert.Equal(t, RuriUrl+".arm64", dep.url("arm64"))
+	assert.Empty(t, dep.digest("arm64"))
+
+	info = &registryInfo{}
+	require.NoError(t, json.Unmarshal([]byte(`{"dependencies": {"ruri": {
+		"version": "v3.8",
+		"url": "https://mirror.lan/ruri-{version}.{arch}",
+		"sha256": {"amd64": "sha256:ABCDEF", "arm64": "0123"}
+	}}}`), info))
+	dep = info.dependency(dependencyRuri)
+	assert.Equal(t, "https://mirror.lan/ruri-v3.8.amd64", dep.url("amd64"))
+	assert.Equal(t, "abcdef", dep.digest("amd64"))
+	assert.Equal(t, "0123", dep.digest("arm64"))
+	assert.Empty(t, dep.digest("riscv64"))
+	// Dependencies not mentioned keep their defaults.
+	assert.Equal(t, KspeederUrl+".amd64", info.dependency(dependencyKspeeder).url("amd64"))
+	assert.False(t, dep.allowUnpinned)
+
+	// The latest build URL older versions wrote to dockroot.json keeps the pinned defaults.
+	info = &registryInfo{AllowUnpinned: true, Dependencies: map[string]*dependencyInfo{
+		dependencyRuri: {URL: RuriUrl + ".{arch}"},
+	}}
+	dep = info.dependency(dependencyRuri)
+	assert.Equal(t, *defaultDependencies()[dependencyRuri], dependencyInfo{URL: dep.URL, Version: dep.Version, SHA256: dep.SHA256, latest: dep.latest})
+	assert.True(t, dep.allowUnpinned)
+}
+
+func TestDefaultDependencies(t *testing.T) {
+	deps := defaultDependencies()
+	for _, name := range []string{dependencyRuri, dependencyKspeeder} {
+		dep := deps[name]
+		require.NotNil(t, dep, name)
+		// A pinned release has a versioned URL and a digest for each architecture.
+		if dep.Version != "" {
+			assert.Contains(t, dep.URL, "{version}", name)
+			assert.NotEmpty(t, dep.SHA256, name)
+		} else {
+			// Without a release pinned, the latest build is installed unverified rather than not at all.
+			assert.Equal(t, latestDependencyURLs[name], dep.URL, name)
+			expected, err := dep.pinnedDigest(name, "amd64")
+			require.NoError(t, err, name)
+			assert.Empty(t, expected, name)
+		}
+	}
+}
+
+func TestDownloadBinary(t *testing.T) {
+	good := []byte("#!/bin/sh\necho good\n")
+	bad := []byte("#!/bin/sh\necho tampered\n")
+	sum := sha256.Sum256(good)
+	goodDigest := hex.EncodeToString(sum[:])
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/good." + "armv7":
+			_, _ = w.Write(good)
+		case "/bad." + "armv7":
+			_, _ = w.Write(bad)
+		default:
+			http.Error(w, "<html>not found</html>", http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	binaryPath := filepath.Join(dir, "ruri")
+	pinned := func(name string) *dependencyInfo {
+		return &dependencyInfo{
+			URL:    server.URL + "/" + name + ".{arch}",
+			SHA256: map[string]string{"armv7": goodDigest},
+			arch:   "armv7",
+		}
+	}
+
+	// The matching payload is installed.
+	require.NoError(t, downloadBinary(server.Client(), pinned("good"), binaryPath, "ruri"))
+	b, err := os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	assert.Equal(t, good, b)
+	assert.True(t, pinned("good").matches(binaryPath, "armv7"))
+
+	// A tampered payload is rejected and the installed binary is left alone.
+	err = downloadBinary(server.Client(), pinned("bad"), binaryPath, "ruri")
+	assert.ErrorContains(t, err, "Checksum mismatch")
+	b, err = os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	assert.Equal(t, good, b)
+	_, err = os.Stat(binaryPath + ".syn")
+	assert.True(t, os.IsNotExist(err))
+
+	// An error page is not mistaken for the binary, pinned or not.
+	err = downloadBinary(server.Client(), pinned("missing"), binaryPath, "ruri")
+	assert.ErrorContains(t, err, "404")
+	b, err = os.ReadFile(binaryPath)
+	require.NoError(t, err)
+	assert.Equal(t, good, b)
+
+	// Without a pinned digest, the download is refused, unless unpinned dependencies are allowed.
+	unpinned := &dependencyInfo{URL: server.URL + "/bad.{arch}", arch: "armv7"}
+	assert.ErrorContains(t, downloadBinary(server.Client(), unpinned, binaryPath, "ruri"), "No SHA-256 pinned")
+	assert.True(t, pinned("good").matches(binaryPath, "armv7"))
+	unpinned.allowUnpinned = true
+	require.NoError(t, downloadBinary(server.Client(), unpinned, binaryPath, "ruri"))
+	assert.False(t, pinned("good").matches(binaryPath, "armv7"))
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// dockerfileCommands are the Dockerfile instructions build supports.
+var dockerfileCommands = []string{"FROM", "RUN", "COPY", "ADD", "ENV", "WORKDIR", "USER", "ENTRYPOINT", "CMD", "EXPOSE", "LABEL"}
+
+// dockerfileInstruction is one instruction of a Dockerfile, with its continuation lines joined.
+type dockerfileInstruction struct {
+	line    int      // Line the instruction starts on
+	command string   // Upper-cased instruction, e.g. RUN
+	flags   []string // Leading --name=value arguments
+	args    string   // Arguments after the flags
+	exec    []string // args in the JSON (exec) form, or nil for the shell form
+}
+
+// String returns the instruction in its canonical form, as build reports and caches it.
+func (inst *dockerfileInstruction) String() string {
+	parts := append([]string{inst.command}, inst.flags...)
+	return strings.Join(append(parts, inst.args), " ")
+}
+
+// parseDockerfile returns the instructions of the Dockerfile read from r.
+// Comments are dropped, and lines ending with a backslash continue on the next line.
+func parseDockerfile(r io.Reader) ([]*dockerfileInstruction, error) {
+	var instructions []*dockerfileInstruction
+	var text strings.Builder
+	start := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#") || (trimmed == "" && start == 0) {
+			continue
+		}
+		if start == 0 {
+			start = lineNo
+			line = trimmed
+		}
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if l, ok := strings.CutSuffix(line, `\`); ok {
+			text.WriteString(l)
+			continue
+		}
+		text.WriteString(line)
+		inst, err := parseDockerfileInstruction(start, text.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		text.Reset()
+		start = 0
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if start != 0 {
+		inst, err := parseDockerfileInstruction(start, text.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+	}
+	if len(instructions) == 0 {
+		return nil, fmt.Errorf("Dockerfile has no instructions")
+	}
+	return instructions, nil
+}
+
+func parseDockerfileInstruction(line int, text string) (*dockerfileInstruction, error) {
+	command, args := cutSpace(text)
+	command = strings.ToUpper(command)
+	if !slices.Contains(dockerfileCommands, command) {
+		return nil, fmt.Errorf("Dockerfile line %d: unsupported instruction %s, build supports %s", line, command, strings.Join(dockerfileCommands, ", "))
+	}
+	inst := &dockerfileInstruction{line: line, command: command, args: strings.TrimSpace(args)}
+	for strings.HasPrefix(inst.args, "--") {
+		flag, rest := cutSpace(inst.args)
+		inst.flags = append(inst.flags, flag)
+		inst.args = rest
+	}
+	if inst.args == "" {
+		return nil, fmt.Errorf("Dockerfile line %d: %s requires arguments", line, command)
+	}
+	// As with docker, an argument which is not a valid JSON array is taken as the shell form.
+	if strings.HasPrefix(inst.args, "[") {
+		var exec []string
+		if err := json.Unmarshal([]byte(inst.args), &exec); err == nil {
+			inst.exec = exec
+		}
+	}
+	return inst, nil
+}
+
+// cutSpace splits s around its first run of white space.
+func cutSpace(s string) (before, after string) {
+	i := strings.IndexFunc(s, unicode.IsSpace)
+	if i == -1 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// flag returns the value of the --name=value flag of inst, and whether it was given.
+// Flags other than allowed are rejected.
+func (inst *dockerfileInstruction) flag(name string, allowed ...string) (string, bool, error) {
+	value, found := "", false
+	for _, f := range inst.flags {
+		n, v, _ := strings.Cut(strings.TrimPrefix(f, "--"), "=")
+		if !slices.Contains(allowed, n) {
+			return "", false, fmt.Errorf("Dockerfile line %d: %s does not support the flag %s", inst.line, inst.command, f)
+		}
+		if n == name {
+			value, found = v, true
+		}
+	}
+	return value, found, nil
+}
+
+// shellWords splits s into words as the shell form of ENV, LABEL, COPY and friends does: quotes group words and are
+// removed, a backslash escapes the next character, and $VAR, ${VAR}, ${VAR:-default} and ${VAR:+alternative}
+// outside single quotes are replaced with the values lookup returns.
+func shellWords(s string, lookup func(string) (string, bool)) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, single, double := false, false, false
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c) && !single && !double:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\' && !single && i+1 < len(runes):
+			i++
+			word.WriteRune(runes[i])
+		case c == '\'' && !double:
+			single = !single
+		case c == '"' && !single:
+			double = !double
+		case c == '$' && !single:
+			value, n, err := expandVariable(runes[i+1:], lookup)
+			if err != nil {
+				return nil, fmt.Errorf("%w in %q", err, s)
+			}
+			word.WriteString(value)
+			i += n
+		default:
+			word.WriteRune(c)
+		}
+		inWord = true
+	}
+	if single || double {
+		return nil, fmt.Errorf("Unterminated quote in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// expandVariable returns the value of the variable reference at the start of s, which followed a $, and the number
+// of runes it took. A $ not followed by a variable name stands for itself.
+func expandVariable(s []rune, lookup func(string) (string, bool)) (string, int, error) {
+	isNameRune := func(c rune) bool { return c == '_' || unicode.IsLetter(c) || unicode.IsDigit(c) }
+	if len(s) > 0 && s[0] == '{' {
+		end := slices.Index(s, '}')
+		if end == -1 {
+			return "", 0, fmt.Errorf("Missing } after ${")
+		}
+		inner := string(s[1:end])
+		name, word := inner, ""
+		op := ""
+		for _, o := range []string{":-", ":+"} {
+			if n, w, ok := strings.Cut(inner, o); ok {
+				name, word, op = n, w, o
+				break
+			}
+		}
+		if name == "" || strings.IndexFunc(name, func(c rune) bool { return !isNameRune(c) }) != -1 {
+			return "", 0, fmt.Errorf("Invalid variable reference ${%s}", inner)
+		}
+		value, _ := lookup(name)
+		switch {
+		case op == ":-" && value == "":
+			value = word
+		case op == ":+" && value != "":
+			value = word
+		case op == ":+":
+			value = ""
+		}
+		return value, end + 1, nil
+	}
+	n := 0
+	for n < len(s) && isNameRune(s[n]) {
+		n++
+	}
+	if n == 0 {
+		return "$", 0, nil
+	}
+	value, _ := lookup(string(s[:n]))
+	return value, n, nil
+}
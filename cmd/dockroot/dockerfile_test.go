@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDockerfile(t *testing.T) {
+	instructions, err := parseDockerfile(strings.NewReader(`# syntax=docker/dockerfile:1
+FROM alpine:latest
+
+run apk add --no-cache \
+    curl \
+# a comment inside the continuation
+    jq
+COPY --chown=app:app src/ /app/
+CMD ["/app/run", "--port", "80"]
+CMD [not json
+`))
+	require.NoError(t, err)
+	require.Len(t, instructions, 5)
+	assert.Equal(t, &dockerfileInstruction{line: 2, command: "FROM", args: "alpine:latest"}, instructions[0])
+	assert.Equal(t, &dockerfileInstruction{line: 4, command: "RUN", args: "apk add --no-cache     curl     jq"}, instructions[1])
+	assert.Equal(t, &dockerfileInstruction{line: 8, command: "COPY", flags: []string{"--chown=app:app"}, args: "src/ /app/"}, instructions[2])
+	assert.Equal(t, []string{"/app/run", "--port", "80"}, instructions[3].exec)
+	assert.Nil(t, instructions[4].exec)
+	assert.Equal(t, "COPY --chown=app:app src/ /app/", instructions[2].String())
+
+	owner, ok, err := instructions[2].flag("chown", "chown")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "app:app", owner)
+	_, _, err = instructions[2].flag("chmod", "chmod")
+	assert.Error(t, err)
+
+	for _, df := range []string{"", "# only a comment\n", "FROM alpine\nARG VERSION=1\n", "FROM alpine\nRUN\n"} {
+		_, err := parseDockerfile(strings.NewReader(df))
+		assert.Error(t, err, df)
+	}
+}
+
+func TestShellWords(t *testing.T) {
+	env := map[string]string{"HOME": "/root", "EMPTY": ""}
+	lookup := func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+	for _, c := range []struct {
+		input    string
+		expected []string
+	}{
+		{`a  b`, []string{"a", "b"}},
+		{`KEY="a b" OTHER='c d'`, []string{"KEY=a b", "OTHER=c d"}},
+		{`a\ b ""`, []string{"a b", ""}},
+		{`$HOME/bin ${HOME}x '$HOME' "$HOME"`, []string{"/root/bin", "/rootx", "$HOME", "/root"}},
+		{`${MISSING:-dflt} ${EMPTY:-dflt} ${HOME:+set} ${MISSING:+set}`, []string{"dflt", "dflt", "set", ""}},
+		{`cost \$5 $`, []string{"cost", "$5", "$"}},
+		{`日本=${HOME}語`, []string{"日本=/root語"}},
+	} {
+		words, err := shellWords(c.input, lookup)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, words, c.input)
+	}
+	for _, input := range []string{`"open`, `'open`, `${HOME`, `${a-b}`} {
+		_, err := shellWords(input, lookup)
+		assert.Error(t, err, input)
+	}
+}
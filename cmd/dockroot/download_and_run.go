@@ -2,14 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
-	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 const (
@@ -33,20 +39,32 @@ func checkIsKspeederDownload(binaryPath string) bool {
 	return checkIsBinaryDownload(binaryPath, "--help", "localAddr")
 }
 
-func downloadKspeeder(client *http.Client, binaryPath string) error {
+func downloadKspeeder(client *http.Client, dep *dependencyInfo, binaryPath string) error {
 	return downloadBinary(client,
-		KspeederUrl,
+		dep,
 		binaryPath,
 		"kspeeder")
 }
 
-func downloadBinary(client *http.Client, urlPrefix, binaryPath, msgName string) error {
+// downloadBinary downloads dep for this machine to binaryPath.
+func downloadBinary(client *http.Client, dep *dependencyInfo, binaryPath, msgName string) error {
+	return downloadBinaryForArch(client, dep, dep.arch, binaryPath, msgName)
+}
+
+// downloadBinaryForArch downloads dep for arch to binaryPath.
+// The download is verified against the SHA-256 digest pinned in dep before it replaces binaryPath.
+func downloadBinaryForArch(client *http.Client, dep *dependencyInfo, arch, binaryPath, msgName string) error {
+	expected, err := dep.pinnedDigest(msgName, arch)
+	if err != nil {
+		return err
+	}
 	fmt.Printf("Downloading %s... please wait. This may take a while.\n", msgName)
+	url := dep.url(arch)
 	ctx, cancelFn := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancelFn()
 	req, err := http.NewRequestWithContext(ctx,
 		http.MethodGet,
-		fmt.Sprintf("%s.%s", urlPrefix, runtime.GOARCH),
+		url,
 		nil)
 	if err != nil {
 		return err
@@ -56,7 +74,18 @@ func downloadBinary(client *http.Client, urlPrefix, binaryPath, msgName string)
 		return err
 	}
 	defer resp.Body.Close()
-	f, err := os.OpenFile(binaryPath+".syn", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Failed to download %s from %s: %s", msgName, url, resp.Status)
+	}
+	return installBinary(resp.Body, expected, arch, url, binaryPath, msgName, nil)
+}
+
+// installBinary writes r, read from source, to binaryPath through a temporary file.
+// The file only replaces binaryPath once it matches the expected SHA-256 digest, and passes check, if set.
+// expected is only empty where pinnedDigest allows it.
+func installBinary(r io.Reader, expected, arch, source, binaryPath, msgName string, check func(path string) bool) (retErr error) {
+	tmpPath := binaryPath + ".syn"
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
 		return err
 	}
@@ -64,63 +93,129 @@ func downloadBinary(client *http.Client, urlPrefix, binaryPath, msgName string)
 		if f != nil {
 			f.Close()
 		}
+		if retErr != nil {
+			os.Remove(tmpPath)
+		}
 	}()
-	_, err = io.Copy(f, resp.Body)
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
 	if err != nil {
 		return err
 	}
-	f.Close()
+	err = f.Close()
 	f = nil
-	if err := os.Rename(binaryPath+".syn", binaryPath); err != nil {
+	if err != nil {
+		return err
+	}
+	actual := hex.EncodeToString(h.Sum(nil))
+	if expected == "" {
+		logrus.Warnf("No SHA-256 pinned for %s on %s, installing it unverified: sha256:%s", msgName, arch, actual)
+	} else if actual != expected {
+		return fmt.Errorf("Checksum mismatch for %s from %s: expected sha256:%s, got sha256:%s", msgName, source, expected, actual)
+	}
+	if check != nil && !check(tmpPath) {
+		return fmt.Errorf("%s from %s does not run on this machine", msgName, source)
+	}
+	if err := os.Rename(tmpPath, binaryPath); err != nil {
 		return err
 	}
 	return nil
 }
 
-func runKspeeder(binaryPath, cachePath string, client *http.Client) error {
+// acceleratorReadyTimeout bounds how long we wait for a freshly started accelerator to become healthy.
+const acceleratorReadyTimeout = 60 * time.Second
+
+// runKspeeder starts kspeeder detached from DockRoot, with its PID recorded in pidPath and its output appended to logPath,
+// and waits until it answers on healthURL. If exitAfterHours is positive, kspeeder exits by itself after that many hours.
+func runKspeeder(binaryPath, cachePath, pidPath, logPath, healthURL string, exitAfterHours int, client *http.Client) (int, error) {
 	fmt.Println("Running kspeeder... please wait. This may take a while.")
-	cmd := exec.Command(binaryPath, "--cachePath", cachePath, "--exitAfter", "1")
-	err := cmd.Start()
+	args := []string{"--cachePath", cachePath}
+	if exitAfterHours > 0 {
+		args = append(args, "--exitAfter", strconv.Itoa(exitAfterHours))
+	}
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 	if err != nil {
-		return err
+		return 0, err
 	}
-	var ok bool
-	for retry := 0; retry < 5; retry++ {
-		time.Sleep(time.Second * 2)
-		if checkIsKspeederRun(client) {
-			ok = true
-			break
-		}
+	defer logFile.Close()
+	cmd := exec.Command(binaryPath, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		return 0, err
 	}
-	if !ok {
-		return fmt.Errorf("Kspeeder did not start successfully")
+	pid := cmd.Process.Pid
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	if err := writePidFile(pidPath, pid); err != nil {
+		_ = terminateProcess(pid, 5*time.Second)
+		return 0, err
 	}
-	fmt.Println("kspeeder started but wait for 10 seconds.")
-	time.Sleep(time.Second * 10)
-	fmt.Println("kspeeder started successfully. It will stop after 1 hours.")
-
-	return nil
+	ready := func() bool {
+		return checkIsKspeederRun(client, healthURL)
+	}
+	if err := waitForReady("kspeeder", ready, acceleratorReadyTimeout, exited); err != nil {
+		_ = terminateProcess(pid, 5*time.Second)
+		_ = os.Remove(pidPath)
+		return 0, fmt.Errorf("%w, see %s", err, logPath)
+	}
+	if exitAfterHours > 0 {
+		fmt.Printf("kspeeder started successfully with PID %d. It will stop after %d hours.\n", pid, exitAfterHours)
+	} else {
+		fmt.Printf("kspeeder started successfully with PID %d.\n", pid)
+	}
+	return pid, nil
 }
 
-func checkAndRunKspeeder(binaryPath, cachePath string, client *http.Client) error {
-	fmt.Println("checking kspeeder")
-	if !checkIsKspeederRun(client) {
-		if !checkIsKspeederDownload(binaryPath) {
-			if err := downloadKspeeder(client, binaryPath); err != nil {
-				return err
+// waitForReady polls ready until it returns true, the process reports on exited that it is gone, or timeout expires.
+// exited may be nil if the process is not ours to wait for.
+func waitForReady(name string, ready func() bool, timeout time.Duration, exited <-chan error) error {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if ready() {
+			return nil
+		}
+		select {
+		case err := <-exited:
+			if err == nil {
+				return fmt.Errorf("%s exited before becoming ready", name)
 			}
+			return fmt.Errorf("%s exited before becoming ready: %w", name, err)
+		case <-deadline:
+			return fmt.Errorf("%s did not become ready within %s", name, timeout)
+		case <-ticker.C:
 		}
-		return runKspeeder(binaryPath, cachePath, client)
 	}
-	return nil
 }
 
-func checkIsKspeederRun(client *http.Client) bool {
+// stopKspeeder stops the kspeeder recorded in pidPath, if it is still running, and removes pidPath.
+func stopKspeeder(pidPath string) error {
+	pid, err := readPidFile(pidPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if processAlive(pid) && processCommandContains(pid, "kspeeder") {
+		if err := terminateProcess(pid, 10*time.Second); err != nil {
+			return err
+		}
+	}
+	return os.Remove(pidPath)
+}
+
+func checkIsKspeederRun(client *http.Client, healthURL string) bool {
 	ctx, cancelFn := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancelFn()
 	req, err := http.NewRequestWithContext(ctx,
 		http.MethodGet,
-		"https://registry.linkease.net:5443/v2/", nil)
+		healthURL, nil)
 	if err != nil {
 		return false
 	}
@@ -143,10 +238,24 @@ func checkIsRuriDownload(binaryPath string) bool {
 	return checkIsBinaryDownload(binaryPath, "-v", "ruri version")
 }
 
-func checkAndDownloadRuri(binaryPath string, client *http.Client) error {
-	if !checkIsBinaryDownload(binaryPath, "-v", "ruri version") {
+// checkAndDownloadKspeeder downloads kspeeder unless binaryPath already is a working kspeeder matching the pinned digest, if any.
+func checkAndDownloadKspeeder(binaryPath string, dep *dependencyInfo, client *http.Client) error {
+	if !checkIsKspeederDownload(binaryPath) || !dep.matches(binaryPath, dep.arch) {
+		if err := downloadKspeeder(client, dep, binaryPath); err != nil {
+			return err
+		}
+		if !checkIsKspeederDownload(binaryPath) {
+			return fmt.Errorf("failed to download kspeeder binary")
+		}
+	}
+	return nil
+}
+
+// checkAndDownloadRuri downloads ruri unless binaryPath already is a working ruri matching the pinned digest, if any.
+func checkAndDownloadRuri(binaryPath string, dep *dependencyInfo, client *http.Client) error {
+	if !checkIsRuriDownload(binaryPath) || !dep.matches(binaryPath, dep.arch) {
 		if err := downloadBinary(client,
-			RuriUrl,
+			dep,
 			binaryPath,
 			"ruri"); err != nil {
 			return err
@@ -2,7 +2,7 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
 	"net/http"
 	"os"
@@ -13,23 +13,43 @@ import (
 
 type ensureDepsOptions struct {
 	global *globalOptions
+	from   string   // Install from this bundle instead of downloading
+	bundle string   // Write a bundle here instead of installing
+	archs  []string // Architectures to put in the bundle
 }
 
 func ensureDepsCmd(global *globalOptions) *cobra.Command {
 	opts := ensureDepsOptions{global: global}
 	cmd := &cobra.Command{
-		Use:     "ensuredeps",
-		Short:   "download dependencies",
-		RunE:    commandAction(opts.run),
-		Example: `DockRoot ensuredeps`,
+		Use:   "ensuredeps",
+		Short: "download dependencies",
+		RunE:  commandAction(opts.run),
+		Example: `DockRoot ensuredeps
+DockRoot ensuredeps --bundle deps.tar.gz --arch arm64,arm,amd64
+DockRoot ensuredeps --from deps.tar.gz`,
 	}
+	flags := cmd.Flags()
+	flags.StringVar(&opts.from, "from", "", "Install the dependencies from the bundle `DIR|TARBALL` instead of downloading them")
+	flags.StringVar(&opts.bundle, "bundle", "", "Download the dependencies into a bundle at `OUT`, a directory or .tar/.tar.gz file, instead of installing them")
+	flags.StringSliceVar(&opts.archs, "arch", nil, "Dependency asset names (e.g. amd64, arm64, armv7, riscv64) to include with --bundle, default the --override-* platform or this machine's")
 	return cmd
 }
 
 type registryInfo struct {
-	Mirrors     []string `json:"registry-mirrors"`
-	DataRoot    string   `json:"data-root"`
-	UseKspeeder bool     `json:"useKspeeder"`
+	Mirrors       []string                   `json:"registry-mirrors"`
+	DataRoot      string                     `json:"data-root"`
+	UseKspeeder   bool                       `json:"useKspeeder"`                           // Fetch Docker Hub images through the accelerator
+	Accelerator   *acceleratorInfo           `json:"accelerator,omitempty"`                 // Defaults to kspeeder if unset
+	Dependencies  map[string]*dependencyInfo `json:"dependencies,omitempty"`                // Pinned ruri and kspeeder downloads, instead of those of dependencies.json
+	AllowUnpinned bool                       `json:"allow-unpinned-dependencies,omitempty"` // Install dependencies with no SHA-256 pinned, unverified
+	Platform      *platformInfo              `json:"platform,omitempty"`                    // Overrides the detected platform
+	DNS           []string                   `json:"dns,omitempty"`                         // Default nameservers for containers, instead of the host's
+	DNSSearch     []string                   `json:"dns-search,omitempty"`                  // Default search domains for containers, instead of the host's
+	Timezone      string                     `json:"tz,omitempty"`                          // Default --tz for containers
+	Storage       string                     `json:"storage,omitempty"`                     // storageOverlay to share unpacked images between containers
+	LogOpts       map[string]string          `json:"log-opts,omitempty"`                    // Default --log-opt for detached containers
+
+	overrides platform // Chosen by the --override-* flags, which take precedence over Platform for --bundle
 }
 
 func readRegistryInfo(binaryDir string) (*registryInfo, error) {
@@ -45,6 +65,16 @@ func readRegistryInfo(binaryDir string) (*registryInfo, error) {
 	return &info, nil
 }
 
+// readRegistryInfo reads dockroot.json like readRegistryInfo, keeping the --override-* flags in opts for --bundle.
+func (opts *globalOptions) readRegistryInfo(binaryDir string) (*registryInfo, error) {
+	info, err := readRegistryInfo(binaryDir)
+	if err != nil {
+		return nil, err
+	}
+	info.overrides = opts.overridePlatform()
+	return info, nil
+}
+
 func writeDefaultRegistry(binaryDir string) error {
 	var err error
 	baseDir := filepath.Base(binaryDir)
@@ -64,6 +94,7 @@ func writeDefaultRegistry(binaryDir string) error {
 			"https://docker.m.daocloud.io",
 		},
 		UseKspeeder: true,
+		Accelerator: defaultAcceleratorInfo(),
 		DataRoot:    dataRoot,
 	}
 	if _, err = os.Stat(info.DataRoot); os.IsNotExist(err) {
@@ -84,11 +115,17 @@ func writeRegistryInfo(binaryDir string, info *registryInfo) error {
 }
 
 func (opts *ensureDepsOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) != 0 {
+		return errorShouldDisplayUsage{errors.New("No arguments expected")}
+	}
+	if opts.from != "" && opts.bundle != "" {
+		return errorShouldDisplayUsage{errors.New("--from and --bundle cannot be used together")}
+	}
 	binaryDir, err := getBinaryDir()
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err == nil {
 		_, err = os.Stat(info.DataRoot)
 	}
@@ -97,27 +134,42 @@ func (opts *ensureDepsOptions) run(args []string, stdout io.Writer) (retErr erro
 		if err != nil {
 			return err
 		}
+		info, err = opts.global.readRegistryInfo(binaryDir)
+		if err != nil {
+			return err
+		}
+	}
+	acc, err := info.accelerator(binaryDir)
+	if err != nil {
+		return err
 	}
 
 	client := &http.Client{}
-	kspeederBin := filepath.Join(binaryDir, "kspeeder")
-	if !checkIsKspeederDownload(kspeederBin) {
-		if err := downloadKspeeder(client, kspeederBin); err != nil {
-			return err
+	if opts.bundle != "" {
+		archs := opts.archs
+		if len(archs) == 0 {
+			archs = []string{info.bundleArch()}
+		}
+		return writeBundle(client, info, archs, opts.bundle)
+	}
+	if opts.from != "" {
+		names := []string{dependencyRuri}
+		if _, ok := acc.(*kspeederAccelerator); ok {
+			names = append(names, dependencyKspeeder)
 		}
+		return installFromBundle(opts.from, info, binaryDir, names)
 	}
 
-	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
-		if err := downloadBinary(client,
-			RuriUrl,
-			ruriPath,
-			"ruri"); err != nil {
+	if _, ok := acc.(*kspeederAccelerator); ok {
+		kspeederBin := filepath.Join(binaryDir, "kspeeder")
+		if err := checkAndDownloadKspeeder(kspeederBin, info.dependency(dependencyKspeeder), client); err != nil {
 			return err
 		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
+	}
+
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), client); err != nil {
+		return err
 	}
 	return nil
 }
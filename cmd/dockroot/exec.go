@@ -0,0 +1,341 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/moby/sys/user"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// defaultPath is the PATH of commands exec runs if the container does not set one, as in docker.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+type execOptions struct {
+	global      *globalOptions
+	interactive bool
+	tty         bool
+	workDir     string
+	user        string
+	envVars     []string
+}
+
+func execCmd(global *globalOptions) *cobra.Command {
+	opts := execOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "exec [-it] NAME COMMAND [ARGS]",
+		Short: "run a command in a running container",
+		Long: `Run COMMAND in the running container NAME, in the mount, PID, UTS and IPC namespaces of the process ruri
+started for it, with the environment, working directory and user of its ruri.conf.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot exec alpine001 cat /etc/os-release
+DockRoot exec -it alpine001 /bin/sh`,
+	}
+	flags := cmd.Flags()
+	// As with docker exec, everything after NAME is the command, even if it looks like a flag.
+	flags.SetInterspersed(false)
+	flags.BoolVarP(&opts.interactive, "interactive", "i", false, "Keep STDIN open")
+	flags.BoolVarP(&opts.tty, "tty", "t", false, "Allocate a pseudo-TTY")
+	flags.StringVarP(&opts.workDir, "workdir", "w", "", "Working directory inside the container, default the one of ruri.conf")
+	flags.StringVarP(&opts.user, "user", "u", "", "Username or UID to run as inside the container, default the one of ruri.conf")
+	flags.StringSliceVarP(&opts.envVars, "env", "e", []string{}, "Set environment variables (e.g., -e UID=0 -e GID=0)")
+	return cmd
+}
+
+// exitCodeError reports the exit code of a command DockRoot ran, for DockRoot to exit with.
+type exitCodeError int
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+func (opts *execOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) < 2 {
+		return fmt.Errorf("Usage: %s exec [-it] NAME COMMAND [ARGS]", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, CleanString(args[0])))
+	if err != nil {
+		return err
+	}
+
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+	confPath := filepath.Join(destAbsDir, "ruri.conf")
+	conf, err := readRuriConf(confPath)
+	if err != nil {
+		return err
+	}
+	pids, err := RuriPids(ruriPath, confPath)
+	if err != nil {
+		return err
+	}
+	if len(pids) == 0 {
+		return fmt.Errorf("Container %s is not running", args[0])
+	}
+	pid, err := containerInitPid(pids)
+	if err != nil {
+		return err
+	}
+
+	workDir := opts.workDir
+	if workDir == "" && len(conf["work_dir"]) > 0 {
+		workDir = conf["work_dir"][0]
+	}
+	if workDir == "" {
+		workDir = "/"
+	}
+	userSpec := opts.user
+	if userSpec == "" && len(conf["user"]) > 0 {
+		userSpec = conf["user"][0]
+	}
+	env := execEnv(conf["env"], opts.envVars)
+
+	var master, slave *os.File
+	if opts.tty {
+		master, slave, err = openPty()
+		if err != nil {
+			return err
+		}
+		defer master.Close()
+		defer slave.Close()
+		if !slices.ContainsFunc(env, func(e string) bool { return strings.HasPrefix(e, "TERM=") }) {
+			env = append(env, "TERM=xterm")
+		}
+	}
+
+	cmd := &exec.Cmd{
+		Args:        args[1:],
+		Env:         env,
+		Dir:         workDir,
+		SysProcAttr: &syscall.SysProcAttr{},
+	}
+	start := func(cmd *exec.Cmd) error {
+		if err := startInContainer(pid, userSpec, cmd); err != nil {
+			return fmt.Errorf("Starting %s in container %s: %w", args[1], args[0], err)
+		}
+		return nil
+	}
+	if opts.tty {
+		err = runWithPty(cmd, start, master, slave, opts.interactive, stdout)
+	} else {
+		if opts.interactive {
+			cmd.Stdin = os.Stdin
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = os.Stderr
+		if err = start(cmd); err == nil {
+			err = cmd.Wait()
+		}
+	}
+	return commandExitError(err)
+}
+
+// startInContainer starts cmd in the container of the process pid, as userSpec and within the capability bounding
+// set of pid, looking cmd.Args[0] up and filling in HOME from the container's files.
+// The container is entered from a goroutine of its own, whose thread is never unlocked and goes away with it, so
+// that the rest of DockRoot keeps seeing the host's files.
+func startInContainer(pid int, userSpec string, cmd *exec.Cmd) error {
+	capBound, err := processCapBound(pid)
+	if err != nil {
+		return err
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- func() error {
+			if err := enterContainer(pid); err != nil {
+				return fmt.Errorf("entering the container: %w", err)
+			}
+			execUser, err := user.GetExecUserPath(userSpec, &user.ExecUser{Home: "/"}, "/etc/passwd", "/etc/group")
+			if err != nil {
+				return fmt.Errorf("Invalid user %q: %w", userSpec, err)
+			}
+			if !slices.ContainsFunc(cmd.Env, func(e string) bool { return strings.HasPrefix(e, "HOME=") }) {
+				cmd.Env = append(cmd.Env, "HOME="+execUser.Home)
+			}
+			if cmd.Path, err = lookContainerPath(cmd.Args[0], cmd.Env); err != nil {
+				return err
+			}
+			groups := make([]uint32, len(execUser.Sgids))
+			for i, g := range execUser.Sgids {
+				groups[i] = uint32(g)
+			}
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(execUser.Uid), Gid: uint32(execUser.Gid), Groups: groups}
+			// The bounding set of the thread is inherited by the command, which can not gain capabilities
+			// the container was started without.
+			if err := dropCapBound(capBound); err != nil {
+				return err
+			}
+			return cmd.Start()
+		}()
+	}()
+	return <-started
+}
+
+// commandExitError returns err, the result of running a command, as an exitCodeError if the command failed,
+// with 128 plus the signal number for a command killed by a signal, as a shell reports it.
+func commandExitError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return exitCodeError(128 + int(status.Signal()))
+		}
+		return exitCodeError(exitErr.ExitCode())
+	}
+	return err
+}
+
+// exitStatus returns the exit code of a command, as commandExitError does, from err, the result of waiting for it.
+// Errors other than a failed command are returned as they are.
+func exitStatus(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+	var code exitCodeError
+	if errors.As(commandExitError(err), &code) {
+		return int(code), nil
+	}
+	return 0, err
+}
+
+// runWithPty runs cmd, started by start, with the pseudo-terminal slave as its controlling terminal, copying its
+// output from master to stdout, and, if interactive, DockRoot's standard input to it, with the terminal in raw mode.
+func runWithPty(cmd *exec.Cmd, start func(*exec.Cmd) error, master, slave *os.File, interactive bool, stdout io.Writer) error {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = slave, slave, slave
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	if err := resizePty(master, os.Stdin); err != nil {
+		logrus.Debugf("Not resizing pseudo-terminal: %v", err)
+	}
+	if err := start(cmd); err != nil {
+		return err
+	}
+	// Reading master ends once the command, and whatever it started, closed the slave.
+	slave.Close()
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	go func() {
+		for range winch {
+			_ = resizePty(master, os.Stdin)
+		}
+	}()
+	if interactive {
+		if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+			state, err := term.MakeRaw(fd)
+			if err != nil {
+				return err
+			}
+			defer func() {
+				if err := term.Restore(fd, state); err != nil {
+					logrus.Warnf("Restoring terminal: %v", err)
+				}
+			}()
+		}
+		go func() {
+			_, _ = io.Copy(master, os.Stdin)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		// The read fails with EIO once the slave is closed.
+		_, _ = io.Copy(stdout, master)
+		close(done)
+	}()
+	err := cmd.Wait()
+	<-done
+	return err
+}
+
+// containerInitPid returns the first process in pids, as listed by RuriPids, which the others descend from:
+// the one ruri started for the container.
+func containerInitPid(pids []string) (int, error) {
+	nums := make([]int, 0, len(pids))
+	for _, p := range pids {
+		pid, err := strconv.Atoi(p)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid PID %q from ruri: %w", p, err)
+		}
+		nums = append(nums, pid)
+	}
+	for _, pid := range nums {
+		ppid, err := parentPid(pid)
+		if err != nil {
+			// The process exited since ruri listed it.
+			continue
+		}
+		if !slices.Contains(nums, ppid) {
+			return pid, nil
+		}
+	}
+	return 0, fmt.Errorf("No running process among %s", strings.Join(pids, " "))
+}
+
+// execEnv returns the environment of a command exec runs: that of ruri.conf, where env lists keys and values in turn,
+// with the KEY=VALUE settings of overrides applied, and PATH filled in if neither sets it.
+func execEnv(confEnv []string, overrides []string) []string {
+	var env []string
+	set := func(kv string) {
+		key, _, _ := strings.Cut(kv, "=")
+		if i := slices.IndexFunc(env, func(e string) bool { return strings.HasPrefix(e, key+"=") }); i != -1 {
+			env[i] = kv
+		} else {
+			env = append(env, kv)
+		}
+	}
+	for i := 0; i+1 < len(confEnv); i += 2 {
+		set(confEnv[i] + "=" + confEnv[i+1])
+	}
+	for _, kv := range overrides {
+		if strings.Contains(kv, "=") {
+			set(kv)
+		}
+	}
+	if !slices.ContainsFunc(env, func(e string) bool { return strings.HasPrefix(e, "PATH=") }) {
+		env = append(env, "PATH="+defaultPath)
+	}
+	return env
+}
+
+// lookContainerPath finds the executable name in the PATH of env, as a shell in the container would.
+// It must run after enterContainer, on the same goroutine, so that it looks at the container's files.
+func lookContainerPath(name string, env []string) (string, error) {
+	if strings.Contains(name, "/") {
+		return name, nil
+	}
+	path := defaultPath
+	for _, e := range env {
+		if p, ok := strings.CutPrefix(e, "PATH="); ok {
+			path = p
+		}
+	}
+	for _, dir := range filepath.SplitList(path) {
+		p := filepath.Join(dir, name)
+		if st, err := os.Stat(p); err == nil && st.Mode().IsRegular() && st.Mode().Perm()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s: executable file not found in $PATH", name)
+}
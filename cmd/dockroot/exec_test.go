@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecEnv(t *testing.T) {
+	env := execEnv([]string{"LANG", "C.UTF-8", "PATH", "/opt/bin:/bin"}, []string{"LANG=en_US.UTF-8", "DEBUG=1", "ignored"})
+	assert.Equal(t, []string{"LANG=en_US.UTF-8", "PATH=/opt/bin:/bin", "DEBUG=1"}, env)
+	assert.Equal(t, []string{"PATH=" + defaultPath}, execEnv(nil, nil))
+}
+
+func TestContainerInitPid(t *testing.T) {
+	child := exec.Command("sleep", "30")
+	require.NoError(t, child.Start())
+	defer func() {
+		_ = child.Process.Kill()
+		_ = child.Wait()
+	}()
+	self := os.Getpid()
+	pid, err := containerInitPid([]string{strconv.Itoa(child.Process.Pid), strconv.Itoa(self)})
+	require.NoError(t, err)
+	assert.Equal(t, self, pid)
+	_, err = containerInitPid([]string{"nope"})
+	assert.Error(t, err)
+}
+
+func TestEnterContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Joining namespaces needs root")
+	}
+	mnt := t.TempDir()
+	// The container has its own hostname and a tmpfs the host does not see.
+	container := exec.Command("sh", "-c", `mount --make-rprivate / && mount -t tmpfs none "$1" && touch "$1/marker" && hostname exec-test && exec sleep 30`, "sh", mnt)
+	container.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC}
+	require.NoError(t, container.Start())
+	defer func() {
+		_ = container.Process.Kill()
+		_ = container.Wait()
+	}()
+	pid := container.Process.Pid
+	require.Eventually(t, func() bool {
+		comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+		return err == nil && string(comm) == "sleep\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	_, err := os.Stat(filepath.Join(mnt, "marker"))
+	require.True(t, os.IsNotExist(err))
+
+	type result struct {
+		marker bool
+		out    string
+		err    error
+	}
+	results := make(chan result)
+	// The goroutine's thread stays in the container, and goes away with it.
+	go func() {
+		if err := enterContainer(pid); err != nil {
+			results <- result{err: err}
+			return
+		}
+		_, err := os.Stat(filepath.Join(mnt, "marker"))
+		var out bytes.Buffer
+		cmd := &exec.Cmd{Path: "/bin/sh", Args: []string{"sh", "-c", "hostname; echo $$"}, Stdout: &out, Stderr: &out}
+		runErr := cmd.Run()
+		results <- result{marker: err == nil, out: out.String(), err: runErr}
+	}()
+	r := <-results
+	require.NoError(t, r.err, r.out)
+	assert.True(t, r.marker)
+	fields := strings.Fields(r.out)
+	require.Len(t, fields, 2)
+	assert.Equal(t, "exec-test", fields[0])
+	// The shell only follows sleep, the container's PID 1, and the commands which set the container up.
+	shellPid, err := strconv.Atoi(fields[1])
+	require.NoError(t, err)
+	assert.Less(t, shellPid, 10)
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.NotEqual(t, "exec-test", hostname)
+}
+
+func TestStartInContainer(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Joining namespaces needs root")
+	}
+	const capNetRaw = 13
+	mnt := t.TempDir()
+	container := exec.Command("sh", "-c", `mount --make-rprivate / && mount -t tmpfs none "$1" && touch "$1/marker" && hostname exec-test && exec sleep 30`, "sh", mnt)
+	container.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC}
+	// The container runs without CAP_NET_RAW, dropped on a thread which goes away with its goroutine.
+	started := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		bound, err := processCapBound(os.Getpid())
+		if err == nil {
+			err = dropCapBound(bound &^ (1 << capNetRaw))
+		}
+		if err == nil {
+			err = container.Start()
+		}
+		started <- err
+	}()
+	require.NoError(t, <-started)
+	defer func() {
+		_ = container.Process.Kill()
+		_ = container.Wait()
+	}()
+	pid := container.Process.Pid
+	require.Eventually(t, func() bool {
+		comm, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "comm"))
+		return err == nil && string(comm) == "sleep\n"
+	}, 5*time.Second, 10*time.Millisecond)
+	bound, err := processCapBound(pid)
+	require.NoError(t, err)
+	assert.Zero(t, bound&(1<<capNetRaw))
+
+	var out bytes.Buffer
+	cmd := &exec.Cmd{
+		Args:        []string{"sh", "-c", `hostname; echo "$HOME"; test -e "$1/marker" && grep CapBnd /proc/self/status`, "sh", mnt},
+		Env:         []string{"PATH=" + defaultPath},
+		Stdout:      &out,
+		Stderr:      &out,
+		SysProcAttr: &syscall.SysProcAttr{},
+	}
+	require.NoError(t, startInContainer(pid, "", cmd))
+	require.NoError(t, cmd.Wait(), out.String())
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, "exec-test", lines[0])
+	assert.Equal(t, "/root", lines[1])
+	assert.Equal(t, fmt.Sprintf("CapBnd:\t%016x", bound), lines[2])
+	// DockRoot itself still sees the host.
+	assert.NoFileExists(t, filepath.Join(mnt, "marker"))
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+	assert.NotEqual(t, "exec-test", hostname)
+}
+
+func TestRunWithPty(t *testing.T) {
+	master, slave, err := openPty()
+	require.NoError(t, err)
+	defer master.Close()
+	defer slave.Close()
+	var out bytes.Buffer
+	cmd := &exec.Cmd{Path: "/bin/sh", Args: []string{"sh", "-c", "tty; exit 3"}, SysProcAttr: &syscall.SysProcAttr{}}
+	err = runWithPty(cmd, (*exec.Cmd).Start, master, slave, false, &out)
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+	assert.Equal(t, slave.Name()+"\r\n", out.String())
+}
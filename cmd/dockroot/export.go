@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/storage/pkg/archive"
+	"github.com/spf13/cobra"
+)
+
+type exportOptions struct {
+	global *globalOptions
+	output string
+}
+
+func exportCmd(global *globalOptions) *cobra.Command {
+	opts := exportOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "export NAME -o FILE",
+		Short: "write the filesystem of a container to a tar archive",
+		Long: `Write the rootfs of the container NAME, with all its changes, to a flat tar archive,
+as docker export does. The container must be stopped.`,
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot export homeassistant001 -o rootfs.tar`,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.output, "output", "o", "", "Write the archive to `FILE`")
+	return cmd
+}
+
+func (opts *exportOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 || opts.output == "" {
+		return fmt.Errorf("Usage: %s export NAME -o FILE", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	name := CleanString(args[0])
+	destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, name))
+	if err != nil {
+		return err
+	}
+	if !isDirValid(destAbsDir) {
+		return fmt.Errorf("Container %s does not exist", name)
+	}
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+	// The archive is to hold the files of the container, not the /proc, /sys and /dev of the host.
+	confPath := filepath.Join(destAbsDir, "ruri.conf")
+	if _, err := os.Stat(confPath); err == nil {
+		if err := releaseContainer(ruriPath, confPath, name); err != nil {
+			return err
+		}
+	}
+	if err := mountContainerRootfs(info.DataRoot, destAbsDir); err != nil {
+		return err
+	}
+	if err := exportContainer(destAbsDir, opts.output); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Exported %s to %s\n", name, opts.output)
+	return nil
+}
+
+// exportContainer writes the rootfs of the container in containerDir to a tar archive at output.
+func exportContainer(containerDir, output string) (retErr error) {
+	rc, err := archive.TarWithOptions(filepath.Join(containerDir, "rootfs"), &archive.TarOptions{Compression: archive.Uncompressed})
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+		if retErr != nil {
+			os.Remove(output)
+		}
+	}()
+	_, err = io.Copy(f, rc)
+	return err
+}
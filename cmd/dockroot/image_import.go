@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/containers/image/v5/docker/archive"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/distribution/reference"
+)
+
+// localTransports are the transports pull imports images from instead of a registry, for devices without access to one.
+var localTransports = []string{"docker-archive", "oci-archive", "oci", "dir"}
+
+// parseLocalSource returns the image s names with one of localTransports, or nil if s names no such image.
+func parseLocalSource(s string) (types.ImageReference, error) {
+	transport, _, ok := strings.Cut(s, ":")
+	if !ok || !slices.Contains(localTransports, transport) {
+		return nil, nil
+	}
+	ref, err := alltransports.ParseImageName(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid source name %s: %v", s, err)
+	}
+	return ref, nil
+}
+
+// localSourceName returns the name to keep the image srcRef in the image store under: tag if set, or else the name the
+// source gives the image, like the RepoTags of a docker save archive or an OCI ref name like alpine:3.20.
+func localSourceName(sys *types.SystemContext, srcRef types.ImageReference, tag string) (*imageReference, error) {
+	if tag != "" {
+		return parseImageReference(tag)
+	}
+	if named := srcRef.DockerReference(); named != nil {
+		return parseImageReference(named.String())
+	}
+	switch srcRef.Transport().Name() {
+	case "oci", "oci-archive":
+		// The ref name in the layout is an image name if save wrote it, but often just a tag.
+		_, image, _ := strings.Cut(srcRef.StringWithinTransport(), ":")
+		if named, err := reference.ParseNormalizedNamed(image); err == nil {
+			if _, ok := named.(reference.NamedTagged); ok {
+				return parseImageReference(image)
+			}
+		}
+	case archive.Transport.Name():
+		tarReader, _, err := archive.NewReaderForReference(sys, srcRef)
+		if err != nil {
+			return nil, err
+		}
+		defer tarReader.Close()
+		repoTags, err := tarReader.ManifestTagsForReference(srcRef)
+		if err != nil {
+			return nil, err
+		}
+		if len(repoTags) > 0 {
+			return parseImageReference(repoTags[0])
+		}
+	}
+	return nil, fmt.Errorf("Cannot tell the name of the image in %s, name it with --tag", transports.ImageName(srcRef))
+}
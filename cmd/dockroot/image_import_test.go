@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLocalSource(t *testing.T) {
+	for _, s := range []string{"alpine:latest", "docker://alpine", "localhost:5000/app"} {
+		ref, err := parseLocalSource(s)
+		require.NoError(t, err, s)
+		assert.Nil(t, ref, s)
+	}
+	for _, s := range []string{"docker-archive:/tmp/a.tar", "oci-archive:/tmp/a.tar:app", "oci:/tmp/layout:app", "dir:/tmp/image"} {
+		ref, err := parseLocalSource(s)
+		require.NoError(t, err, s)
+		assert.NotNil(t, ref, s)
+	}
+	_, err := parseLocalSource("docker-archive:")
+	assert.ErrorContains(t, err, "Invalid source name")
+}
+
+func TestLocalSourceName(t *testing.T) {
+	dir := t.TempDir()
+	layout := filepath.Join(dir, "layout")
+	addTestImage(t, layout, "app", map[string]string{"usr/bin/app": "v1"}, nil)
+
+	// docker save archives name their images.
+	archivePath := filepath.Join(dir, "app.tar")
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	srcRef, err := alltransports.ParseImageName("oci:" + layout + ":app")
+	require.NoError(t, err)
+	destRef, err := alltransports.ParseImageName("docker-archive:" + archivePath + ":myreg.local:5000/app:1.2")
+	require.NoError(t, err)
+	_, err = copy.Image(context.Background(), policyContext, destRef, srcRef, &copy.Options{})
+	require.NoError(t, err)
+
+	archiveRef, err := parseLocalSource("docker-archive:" + archivePath)
+	require.NoError(t, err)
+	name, err := localSourceName(nil, archiveRef, "")
+	require.NoError(t, err)
+	assert.Equal(t, "myreg.local:5000/app:1.2", name.storeName())
+
+	// OCI ref names are often only a tag, and image names otherwise.
+	layoutRef, err := parseLocalSource("oci:" + layout + ":app")
+	require.NoError(t, err)
+	_, err = localSourceName(nil, layoutRef, "")
+	assert.ErrorContains(t, err, "name it with --tag")
+	name, err = localSourceName(nil, layoutRef, "app:2")
+	require.NoError(t, err)
+	assert.Equal(t, "docker.io/library/app:2", name.storeName())
+	require.NoError(t, os.Rename(layout, filepath.Join(dir, "layout2")))
+	addTestImage(t, layout, "myreg.local:5000/app:1.2", map[string]string{"usr/bin/app": "v1"}, nil)
+	layoutRef, err = parseLocalSource("oci:" + layout + ":myreg.local:5000/app:1.2")
+	require.NoError(t, err)
+	name, err = localSourceName(nil, layoutRef, "")
+	require.NoError(t, err)
+	assert.Equal(t, "myreg.local:5000/app:1.2", name.storeName())
+}
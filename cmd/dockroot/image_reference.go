@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+const (
+	dockerHubDomain  = "docker.io"
+	defaultHubMirror = "registry.linkease.net:5443"
+)
+
+// imageReference is an image reference given on the command line, normalized the way docker does it.
+type imageReference struct {
+	named  reference.Named // Normalized; "latest" is filled in if neither a tag nor a digest was given
+	direct bool            // The user spelled out docker://, so the registry must not be rewritten to a mirror
+}
+
+// parseImageReference parses any valid Docker reference, optionally prefixed with docker://.
+// Short names are expanded to docker.io/library/NAME, and a missing tag defaults to latest.
+func parseImageReference(s string) (*imageReference, error) {
+	refString, direct := strings.CutPrefix(s, "docker://")
+	named, err := reference.ParseNormalizedNamed(refString)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid image reference %q: %w", s, err)
+	}
+	return &imageReference{
+		named:  reference.TagNameOnly(named),
+		direct: direct,
+	}, nil
+}
+
+// digest returns the digest the reference is pinned to, or "" if it only has a tag.
+func (ref *imageReference) digest() string {
+	if canonical, ok := ref.named.(reference.Canonical); ok {
+		return canonical.Digest().String()
+	}
+	return ""
+}
+
+// tag returns the tag of the reference, or "" if it only has a digest.
+func (ref *imageReference) tag() string {
+	if tagged, ok := ref.named.(reference.NamedTagged); ok {
+		return tagged.Tag()
+	}
+	return ""
+}
+
+// isDockerHub returns true if the reference points to Docker Hub.
+func (ref *imageReference) isDockerHub() bool {
+	return reference.Domain(ref.named) == dockerHubDomain
+}
+
+// storeName returns the name under which the image is kept in the image store, e.g. docker.io/library/alpine:latest.
+// Digest-pinned references are stored using the digest, so that they can not be confused with a moving tag.
+func (ref *imageReference) storeName() string {
+	return ref.named.Name() + ref.suffix()
+}
+
+// suffix returns the ":TAG" or "@DIGEST" part of the reference.
+// A digest wins over a tag, because the docker transport does not accept both at once.
+func (ref *imageReference) suffix() string {
+	if d := ref.digest(); d != "" {
+		return "@" + d
+	}
+	return ":" + ref.tag()
+}
+
+// sourceName returns the docker:// image name to copy from.
+// Docker Hub references are rewritten to go through hubMirror unless the user asked for docker:// explicitly.
+func (ref *imageReference) sourceName(hubMirror string) string {
+	if ref.isDockerHub() && !ref.direct && hubMirror != "" {
+		return fmt.Sprintf("docker://%s/%s%s", hubMirror, reference.Path(ref.named), ref.suffix())
+	}
+	return fmt.Sprintf("docker://%s%s", ref.named.Name(), ref.suffix())
+}
+
+// String returns the familiar form of the reference, e.g. alpine:latest.
+func (ref *imageReference) String() string {
+	return reference.FamiliarString(ref.named)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseImageReference(t *testing.T) {
+	const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	for _, c := range []struct{ input, storeName, source string }{
+		{"alpine", "docker.io/library/alpine:latest", "docker://" + defaultHubMirror + "/library/alpine:latest"},
+		{"alpine:3.20", "docker.io/library/alpine:3.20", "docker://" + defaultHubMirror + "/library/alpine:3.20"},
+		{"homeassistant/home-assistant:stable", "docker.io/homeassistant/home-assistant:stable", "docker://" + defaultHubMirror + "/homeassistant/home-assistant:stable"},
+		{"docker.io/library/alpine:3.20", "docker.io/library/alpine:3.20", "docker://" + defaultHubMirror + "/library/alpine:3.20"},
+		{"docker://alpine:3.20", "docker.io/library/alpine:3.20", "docker://docker.io/library/alpine:3.20"},
+		{"myreg.local:5000/app:1.2", "myreg.local:5000/app:1.2", "docker://myreg.local:5000/app:1.2"},
+		{"myreg.local:5000/app", "myreg.local:5000/app:latest", "docker://myreg.local:5000/app:latest"},
+		{"localhost/app:1.2", "localhost/app:1.2", "docker://localhost/app:1.2"},
+		{"alpine@" + testDigest, "docker.io/library/alpine@" + testDigest, "docker://" + defaultHubMirror + "/library/alpine@" + testDigest},
+		{"myreg.local:5000/app:1.2@" + testDigest, "myreg.local:5000/app@" + testDigest, "docker://myreg.local:5000/app@" + testDigest},
+	} {
+		ref, err := parseImageReference(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.storeName, ref.storeName(), c.input)
+		assert.Equal(t, c.source, ref.sourceName(defaultHubMirror), c.input)
+	}
+
+	for _, input := range []string{
+		"",
+		"Alpine",
+		"alpine:",
+		"alpine@sha256:abc",
+		"myreg.local:5000/",
+		"docker://",
+	} {
+		_, err := parseImageReference(input)
+		assert.Error(t, err, input)
+	}
+}
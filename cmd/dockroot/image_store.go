@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci/oci/cas/dir"
+	"github.com/opencontainers/umoci/oci/casext"
+	"github.com/opencontainers/umoci/pkg/funchelpers"
+)
+
+const (
+	// imageStoreName is the directory in DataRoot holding the OCI layout shared by all containers.
+	// CleanString drops ".", so no container can be named like it.
+	imageStoreName = ".images"
+
+	// containerImageFile records which image in the store a container was unpacked from.
+	containerImageFile = "image.json"
+)
+
+// containerImage is the content of containerImageFile.
+type containerImage struct {
+	Name    string `json:"name"`              // Reference name in the store, e.g. docker.io/library/alpine:latest
+	Digest  string `json:"digest"`            // Manifest digest, which is a reference name in the store too
+	Storage string `json:"storage,omitempty"` // storageOverlay if the rootfs is an overlayfs, otherwise a copy
+}
+
+func imageStoreDir(dataRoot string) string {
+	return filepath.Join(dataRoot, imageStoreName)
+}
+
+// lockImageStore locks the image store in dataRoot, exclusively to change it, and returns a function releasing the lock.
+func lockImageStore(dataRoot string, exclusive bool) (func(), error) {
+	f, err := os.OpenFile(filepath.Join(dataRoot, imageStoreName+".lock"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking image store: %w", err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// imageStoreExists reports whether an image was ever pulled into storeDir, which a failed first pull leaves empty.
+func imageStoreExists(storeDir string) bool {
+	_, err := os.Stat(filepath.Join(storeDir, "index.json"))
+	return err == nil
+}
+
+// openImageStore opens the OCI layout in storeDir; the caller must close the returned engine.
+func openImageStore(storeDir string) (casext.Engine, error) {
+	engine, err := dir.Open(storeDir)
+	if err != nil {
+		return casext.Engine{}, fmt.Errorf("open oci layout: %w", err)
+	}
+	return casext.NewEngine(engine), nil
+}
+
+// tagImageDigest makes the manifest digest of refName a reference name of its own in the store, and returns it.
+// This keeps the image reachable for the containers created from it when refName later moves to another image.
+func tagImageDigest(storeDir, refName string) (_ digest.Digest, Err error) {
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return "", err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+
+	ctx := context.Background()
+	desc, err := resolveStoreReference(ctx, engineExt, refName)
+	if err != nil {
+		return "", err
+	}
+	tagged := imgspecv1.Descriptor{
+		MediaType: desc.MediaType,
+		Digest:    desc.Digest,
+		Size:      desc.Size,
+		Platform:  desc.Platform,
+	}
+	if err := engineExt.UpdateReference(ctx, desc.Digest.String(), tagged); err != nil {
+		return "", err
+	}
+	return desc.Digest, nil
+}
+
+// resolveStoreReference returns the manifest descriptor named refName in the store.
+func resolveStoreReference(ctx context.Context, engineExt casext.Engine, refName string) (imgspecv1.Descriptor, error) {
+	paths, err := engineExt.ResolveReference(ctx, refName)
+	if err != nil {
+		return imgspecv1.Descriptor{}, fmt.Errorf("get descriptor: %w", err)
+	}
+	if len(paths) != 1 {
+		return imgspecv1.Descriptor{}, fmt.Errorf("tag is not found or ambiguous: %s", refName)
+	}
+	return paths[0].Descriptor(), nil
+}
+
+// storeRefName returns the reference name in the store for name, an image reference or a manifest digest.
+func storeRefName(name string) (string, error) {
+	if _, err := digest.Parse(name); err == nil {
+		return name, nil
+	}
+	ref, err := parseImageReference(name)
+	if err != nil {
+		return "", err
+	}
+	return ref.storeName(), nil
+}
+
+// resolveStoreImage returns the image in the store named name, an image reference or a manifest digest.
+func resolveStoreImage(storeDir, name string) (_ *containerImage, Err error) {
+	refName, err := storeRefName(name)
+	if err != nil {
+		return nil, err
+	}
+	if !imageStoreExists(storeDir) {
+		return nil, fmt.Errorf("Image %s is not in the image store, pull it first", name)
+	}
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	paths, err := engineExt.ResolveReference(context.Background(), refName)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("Image %s is not in the image store, pull it first", name)
+	}
+	return &containerImage{Name: refName, Digest: paths[0].Descriptor().Digest.String()}, nil
+}
+
+// storeImage is an image in the image store.
+type storeImage struct {
+	names   []string // Reference names other than the digest; empty for images only kept for their containers
+	digest  digest.Digest
+	created *time.Time
+	size    int64 // Compressed size of the config and layers
+}
+
+// listStoreImages returns the images in storeDir, one per manifest digest, sorted by name.
+func listStoreImages(storeDir string) (_ []*storeImage, Err error) {
+	if !imageStoreExists(storeDir) {
+		return nil, nil
+	}
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return nil, err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+
+	ctx := context.Background()
+	refNames, err := engineExt.ListReferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byDigest := map[digest.Digest]*storeImage{}
+	var images []*storeImage
+	for _, refName := range refNames {
+		if isBuildCacheRef(refName) {
+			continue
+		}
+		desc, err := resolveStoreReference(ctx, engineExt, refName)
+		if err != nil {
+			return nil, err
+		}
+		img, ok := byDigest[desc.Digest]
+		if !ok {
+			img = &storeImage{digest: desc.Digest}
+			if err := img.readManifest(ctx, engineExt, desc); err != nil {
+				return nil, err
+			}
+			byDigest[desc.Digest] = img
+			images = append(images, img)
+		}
+		if refName != desc.Digest.String() {
+			img.names = append(img.names, refName)
+		}
+	}
+	for _, img := range images {
+		sort.Strings(img.names)
+	}
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if len(a.names) == 0 || len(b.names) == 0 {
+			return len(a.names) > len(b.names)
+		}
+		return a.names[0] < b.names[0]
+	})
+	return images, nil
+}
+
+// readManifest fills in the creation time and size of img from its manifest desc.
+func (img *storeImage) readManifest(ctx context.Context, engineExt casext.Engine, desc imgspecv1.Descriptor) (Err error) {
+	blob, err := engineExt.FromDescriptor(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer funchelpers.VerifyClose(&Err, blob)
+	m, ok := blob.Data.(imgspecv1.Manifest)
+	if !ok {
+		return fmt.Errorf("%s is not an image manifest: %s", desc.Digest, desc.MediaType)
+	}
+	img.size = m.Config.Size
+	for _, l := range m.Layers {
+		img.size += l.Size
+	}
+	configBlob, err := engineExt.FromDescriptor(ctx, m.Config)
+	if err != nil {
+		return err
+	}
+	defer funchelpers.VerifyClose(&Err, configBlob)
+	if config, ok := configBlob.Data.(imgspecv1.Image); ok {
+		img.created = config.Created
+	}
+	return nil
+}
+
+// containersUsingImage returns the names of the containers in dataRoot created from the image with manifestDigest.
+func containersUsingImage(dataRoot, manifestDigest string) ([]string, error) {
+	entries, err := os.ReadDir(dataRoot)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		dir := filepath.Join(dataRoot, e.Name())
+		if !e.IsDir() || !isDirValid(dir) {
+			continue
+		}
+		if img, err := readContainerImage(dir); err == nil && img.Digest == manifestDigest {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+// removeStoreImage removes name, an image reference or a manifest digest, from the image store in dataRoot.
+// Removing a digest removes all its names. The image itself is only deleted once it has no names left and no
+// container was created from it. It returns the names removed, and the digest if the image was deleted.
+func removeStoreImage(dataRoot, name string) (untagged []string, deleted string, Err error) {
+	storeDir := imageStoreDir(dataRoot)
+	img, err := resolveStoreImage(storeDir, name)
+	if err != nil {
+		return nil, "", err
+	}
+	users, err := containersUsingImage(dataRoot, img.Digest)
+	if err != nil {
+		return nil, "", err
+	}
+	byDigest := img.Name == img.Digest
+	if byDigest && len(users) > 0 {
+		return nil, "", fmt.Errorf("Image %s is used by containers %s", name, strings.Join(users, ", "))
+	}
+
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return nil, "", err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	ctx := context.Background()
+	refNames, err := engineExt.ListReferences(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	remaining := 0
+	var cacheRefs []string
+	for _, refName := range refNames {
+		if refName == img.Digest {
+			continue
+		}
+		desc, err := resolveStoreReference(ctx, engineExt, refName)
+		if err != nil {
+			return nil, "", err
+		}
+		if desc.Digest.String() != img.Digest {
+			continue
+		}
+		if isBuildCacheRef(refName) {
+			cacheRefs = append(cacheRefs, refName)
+			continue
+		}
+		if byDigest || refName == img.Name {
+			if err := engineExt.DeleteReference(ctx, refName); err != nil {
+				return nil, "", err
+			}
+			untagged = append(untagged, refName)
+		} else {
+			remaining++
+		}
+	}
+	if remaining > 0 || len(users) > 0 {
+		return untagged, "", nil
+	}
+	// The build steps the image was the result of go with it.
+	for _, refName := range append(cacheRefs, img.Digest) {
+		if err := engineExt.DeleteReference(ctx, refName); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := engineExt.GC(ctx); err != nil {
+		return nil, "", err
+	}
+	if err := os.RemoveAll(lowerBundleDir(dataRoot, img.Digest)); err != nil {
+		return nil, "", err
+	}
+	return untagged, img.Digest, nil
+}
+
+// pruneBuildCache removes the build steps cached by build from the image store in dataRoot, and deletes the images
+// only kept for them. It returns the number of steps removed.
+func pruneBuildCache(dataRoot string) (_ int, Err error) {
+	storeDir := imageStoreDir(dataRoot)
+	if !imageStoreExists(storeDir) {
+		return 0, nil
+	}
+	engineExt, err := openImageStore(storeDir)
+	if err != nil {
+		return 0, err
+	}
+	defer funchelpers.VerifyClose(&Err, engineExt)
+	ctx := context.Background()
+	refNames, err := engineExt.ListReferences(ctx)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, refName := range refNames {
+		if !isBuildCacheRef(refName) {
+			continue
+		}
+		if err := engineExt.DeleteReference(ctx, refName); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	if removed == 0 {
+		return 0, nil
+	}
+	return removed, engineExt.GC(ctx)
+}
+
+func readContainerImage(containerDir string) (*containerImage, error) {
+	data, err := os.ReadFile(filepath.Join(containerDir, containerImageFile))
+	if err != nil {
+		return nil, err
+	}
+	var img containerImage
+	if err := json.Unmarshal(data, &img); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+func writeContainerImage(containerDir string, img *containerImage) error {
+	data, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(containerDir, containerImageFile), data, 0644)
+}
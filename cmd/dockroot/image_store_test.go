@@ -0,0 +1,243 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTestImage adds refName to the OCI layout in storeDir, creating the layout if needed.
+// The image has a single layer with files, and declares volumes.
+func addTestImage(t *testing.T, storeDir, refName string, files map[string]string, volumes []string) {
+	if os.Geteuid() != 0 {
+		t.Skip("unpacking images requires root")
+	}
+	ctx := context.Background()
+	engineExt, err := umoci.OpenLayout(storeDir)
+	if err != nil {
+		engineExt, err = umoci.CreateLayout(storeDir)
+	}
+	require.NoError(t, err)
+	defer engineExt.Close()
+	require.NoError(t, umoci.NewImage(engineExt, refName))
+	paths, err := engineExt.ResolveReference(ctx, refName)
+	require.NoError(t, err)
+	require.Len(t, paths, 1)
+	mutator, err := mutate.New(engineExt, paths[0])
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	dirs := map[string]bool{}
+	for _, name := range names {
+		for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+			if !dirs[dir] {
+				dirs[dir] = true
+				require.NoError(t, tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755}))
+			}
+		}
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(files[name]))}))
+		_, err := tw.Write([]byte(files[name]))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	_, err = mutator.Add(ctx, imgspecv1.MediaTypeImageLayer, buf, &imgspecv1.History{CreatedBy: "addTestImage"}, mutate.NoopCompressor, nil)
+	require.NoError(t, err)
+
+	config, err := mutator.Config(ctx)
+	require.NoError(t, err)
+	meta, err := mutator.Meta(ctx)
+	require.NoError(t, err)
+	config.Config.Volumes = map[string]struct{}{}
+	for _, v := range volumes {
+		config.Config.Volumes[v] = struct{}{}
+	}
+	config.Config.Cmd = []string{"/bin/sh"}
+	require.NoError(t, mutator.Set(ctx, config.Config, meta, nil, nil))
+	newPath, err := mutator.Commit(ctx)
+	require.NoError(t, err)
+	require.NoError(t, engineExt.UpdateReference(ctx, refName, newPath.Root()))
+}
+
+// unpackTestContainer unpacks refName from the store in dataRoot into the container name, as pull does.
+func unpackTestContainer(t *testing.T, dataRoot, refName, name string) string {
+	storeDir := imageStoreDir(dataRoot)
+	manifestDigest, err := tagImageDigest(storeDir, refName)
+	require.NoError(t, err)
+	containerDir := filepath.Join(dataRoot, name)
+	require.NoError(t, unpack(storeDir, manifestDigest.String(), containerDir))
+	require.NoError(t, writeContainerImage(containerDir, &containerImage{Name: refName, Digest: manifestDigest.String()}))
+	return containerDir
+}
+
+func TestResetContainer(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:latest"
+	addTestImage(t, storeDir, refName, map[string]string{
+		"etc/os-release": "ID=test\n",
+		"usr/bin/app":    "v1",
+		"data/seed":      "seed",
+	}, []string{"/data"})
+	containerDir := unpackTestContainer(t, dataRoot, refName, "app001")
+	rootfs := filepath.Join(containerDir, "rootfs")
+
+	// Changes to the container, some of which reset keeps.
+	require.NoError(t, os.WriteFile(filepath.Join(containerDir, "ruri.conf"), []byte("custom"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "usr/bin/app"), []byte("broken"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "tmp-file"), []byte("junk"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "data/db"), []byte("state"), 0644))
+	require.NoError(t, writeRootfsFile(rootfs, "etc/resolv.conf", []byte("nameserver 10.0.0.53\n")))
+
+	// Moving the tag does not affect which image the container resets to.
+	addTestImage(t, storeDir, refName, map[string]string{"usr/bin/app": "v2"}, nil)
+
+	read := func(p string) string {
+		b, err := os.ReadFile(filepath.Join(rootfs, p))
+		require.NoError(t, err, p)
+		return string(b)
+	}
+	img, err := readContainerImage(containerDir)
+	require.NoError(t, err)
+	// Nothing is removed while a host directory is still mounted in the rootfs, even from the same filesystem.
+	if os.Geteuid() == 0 {
+		host := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(host, "precious"), []byte("host data"), 0644))
+		mnt := filepath.Join(rootfs, "mnt")
+		require.NoError(t, os.Mkdir(mnt, 0755))
+		require.NoError(t, syscall.Mount(host, mnt, "", syscall.MS_BIND, ""))
+		err := resetContainer(storeDir, img, containerDir)
+		require.NoError(t, syscall.Unmount(mnt, 0))
+		assert.ErrorContains(t, err, "still mounted")
+		assert.FileExists(t, filepath.Join(host, "precious"))
+		assert.Equal(t, "broken", read("usr/bin/app"))
+	}
+	require.NoError(t, resetContainer(storeDir, img, containerDir))
+
+	assert.Equal(t, "v1", read("usr/bin/app"))
+	assert.NoFileExists(t, filepath.Join(rootfs, "tmp-file"))
+	assert.Equal(t, "state", read("data/db"))
+	assert.Equal(t, "seed", read("data/seed"))
+	assert.Equal(t, "nameserver 10.0.0.53\n", read("etc/resolv.conf"))
+	b, err := os.ReadFile(filepath.Join(containerDir, "ruri.conf"))
+	require.NoError(t, err)
+	assert.Equal(t, "custom", string(b))
+	assert.True(t, isDirValid(containerDir))
+
+	// Nothing is left behind next to the containers.
+	entries, err := os.ReadDir(dataRoot)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.False(t, strings.Contains(e.Name(), ".reset-"), e.Name())
+	}
+}
+
+func TestMoveRootfsPath(t *testing.T) {
+	oldRootfs, newRootfs, outside := t.TempDir(), t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(oldRootfs, "var", "lib"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(oldRootfs, "var", "lib", "db"), []byte("state"), 0644))
+	// A symlink in the new rootfs pointing at the host is resolved inside the rootfs.
+	require.NoError(t, os.Symlink(outside, filepath.Join(newRootfs, "var")))
+
+	require.NoError(t, moveRootfsPath(oldRootfs, newRootfs, "/var/lib/db"))
+	entries, err := os.ReadDir(outside)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+	// Missing paths are skipped.
+	require.NoError(t, moveRootfsPath(oldRootfs, newRootfs, "/does/not/exist"))
+	require.NoError(t, moveRootfsPath(oldRootfs, newRootfs, "/"))
+}
+
+func TestStoreImages(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	images, err := listStoreImages(storeDir)
+	require.NoError(t, err)
+	assert.Empty(t, images)
+
+	addTestImage(t, storeDir, "docker.io/library/app:1", map[string]string{"usr/bin/app": "v1"}, nil)
+	_, err = tagImageDigest(storeDir, "docker.io/library/app:1")
+	require.NoError(t, err)
+	addTestImage(t, storeDir, "docker.io/library/tool:latest", map[string]string{"usr/bin/tool": "v1"}, nil)
+	containerDir := unpackTestContainer(t, dataRoot, "docker.io/library/tool:latest", "tool001")
+
+	img, err := resolveStoreImage(storeDir, "tool")
+	require.NoError(t, err)
+	assert.Equal(t, "docker.io/library/tool:latest", img.Name)
+	byDigest, err := resolveStoreImage(storeDir, img.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, img.Digest, byDigest.Digest)
+	_, err = resolveStoreImage(storeDir, "missing:latest")
+	assert.ErrorContains(t, err, "not in the image store")
+
+	images, err = listStoreImages(storeDir)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Equal(t, []string{"docker.io/library/app:1"}, images[0].names)
+	assert.Equal(t, []string{"docker.io/library/tool:latest"}, images[1].names)
+	assert.Positive(t, images[0].size)
+
+	users, err := containersUsingImage(dataRoot, img.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tool001"}, users)
+
+	// An image used by a container loses its name but stays in the store.
+	_, _, err = removeStoreImage(dataRoot, img.Digest)
+	assert.ErrorContains(t, err, "used by containers tool001")
+	untagged, deleted, err := removeStoreImage(dataRoot, "tool:latest")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/tool:latest"}, untagged)
+	assert.Empty(t, deleted)
+	images, err = listStoreImages(storeDir)
+	require.NoError(t, err)
+	require.Len(t, images, 2)
+	assert.Empty(t, images[1].names)
+
+	// Once its container is gone, the image can be deleted by digest.
+	require.NoError(t, os.RemoveAll(containerDir))
+	_, deleted, err = removeStoreImage(dataRoot, img.Digest)
+	require.NoError(t, err)
+	assert.Equal(t, img.Digest, deleted)
+
+	// Unused images are deleted with their last name.
+	untagged, deleted, err = removeStoreImage(dataRoot, "app:1")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docker.io/library/app:1"}, untagged)
+	assert.NotEmpty(t, deleted)
+	images, err = listStoreImages(storeDir)
+	require.NoError(t, err)
+	assert.Empty(t, images)
+	blobs, err := os.ReadDir(filepath.Join(storeDir, "blobs", "sha256"))
+	require.NoError(t, err)
+	assert.Empty(t, blobs)
+}
+
+func TestSplitStoreName(t *testing.T) {
+	for _, c := range []struct{ name, repo, tag string }{
+		{"docker.io/library/alpine:3.20", "alpine", "3.20"},
+		{"myreg.local:5000/app:1.2", "myreg.local:5000/app", "1.2"},
+		{"docker.io/library/alpine@sha256:" + strings.Repeat("a", 64), "alpine", "<none>"},
+		{"", "<none>", "<none>"},
+	} {
+		repo, tag := splitStoreName(c.name)
+		assert.Equal(t, c.repo, repo, c.name)
+		assert.Equal(t, c.tag, tag, c.name)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/distribution/reference"
+	"github.com/docker/go-units"
+	"github.com/spf13/cobra"
+)
+
+type imagesOptions struct {
+	global *globalOptions
+	quiet  bool
+}
+
+func imagesCmd(global *globalOptions) *cobra.Command {
+	opts := imagesOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "images",
+		Short:   "list the images in the image store",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot images`,
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "Only show image digests")
+	return cmd
+}
+
+func (opts *imagesOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return fmt.Errorf("Usage: %s images", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	images, err := listStoreImages(imageStoreDir(info.DataRoot))
+	if err != nil {
+		return err
+	}
+	if opts.quiet {
+		for _, img := range images {
+			fmt.Fprintln(stdout, img.digest)
+		}
+		return nil
+	}
+	return writeImageTable(stdout, images, time.Now())
+}
+
+// writeImageTable lists images like docker images does, one line per name.
+func writeImageTable(w io.Writer, images []*storeImage, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tTAG\tDIGEST\tCREATED\tSIZE")
+	for _, img := range images {
+		created := "N/A"
+		if img.created != nil {
+			created = units.HumanDuration(now.Sub(*img.created)) + " ago"
+		}
+		short := img.digest.Encoded()
+		if len(short) > 12 {
+			short = short[:12]
+		}
+		names := img.names
+		if len(names) == 0 {
+			names = []string{""}
+		}
+		for _, name := range names {
+			repo, tag := splitStoreName(name)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", repo, tag, short, created, units.HumanSizeWithPrecision(float64(img.size), 3))
+		}
+	}
+	return tw.Flush()
+}
+
+// splitStoreName returns the familiar repository and the tag of a reference name in the store.
+// Names pinned to a digest have no tag.
+func splitStoreName(name string) (repo, tag string) {
+	named, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return "<none>", "<none>"
+	}
+	tag = "<none>"
+	if tagged, ok := named.(reference.NamedTagged); ok {
+		tag = tagged.Tag()
+	}
+	return reference.FamiliarName(named), tag
+}
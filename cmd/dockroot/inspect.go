@@ -13,6 +13,7 @@ import (
 	"github.com/containers/image/v5/image"
 	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
 	"github.com/containers/skopeo/cmd/skopeo/inspect"
 	"github.com/docker/distribution/registry/api/errcode"
@@ -43,7 +44,8 @@ func inspectCmd(global *globalOptions) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "inspect [command options] IMAGE-NAME",
 		Short: "Inspect image IMAGE-NAME",
-		Long: fmt.Sprintf(`Return low-level information about "IMAGE-NAME" in a registry/transport
+		Long: fmt.Sprintf(`Return low-level information about "IMAGE-NAME" in a registry/transport,
+or about the state of the container IMAGE-NAME if there is one.
 Supported transports:
 %s
 
@@ -84,20 +86,57 @@ func (opts *inspectOptions) run(args []string, stdout io.Writer) (retErr error)
 	}
 	imageName := args[0]
 
-	if err := reexecIfNecessaryForImages(imageName); err != nil {
-		return err
+	// As in docker, a container by that name is inspected rather than an image.
+	if !opts.raw && !opts.config {
+		container, err := opts.global.inspectContainer(imageName)
+		if err != nil {
+			return err
+		}
+		if container != nil {
+			return opts.writeOutput(stdout, container)
+		}
 	}
 
-	sys, err := opts.image.newSystemContext()
-	if err != nil {
+	if err := reexecIfNecessaryForImages(imageName); err != nil {
 		return err
 	}
 
-	if err := retry.IfNecessary(ctx, func() error {
-		src, err = parseImageSource(ctx, opts.image, imageName)
+	var (
+		sys              *types.SystemContext
+		served           imageSourceCandidate
+		unparsedInstance *image.UnparsedImage
+	)
+	if err := tryImageSources(ctx, imageNameCandidates(imageName, configuredHubMirrors()), imageName, func(c imageSourceCandidate) error {
+		candidateSys, err := opts.image.newSystemContext()
+		if err != nil {
+			return err
+		}
+		c.updateSystemContext(candidateSys)
+		var candidateSrc types.ImageSource
+		if err := retry.IfNecessary(ctx, func() error {
+			ref, err := alltransports.ParseImageName(c.name)
+			if err != nil {
+				return err
+			}
+			candidateSrc, err = ref.NewImageSource(ctx, candidateSys)
+			return err
+		}, opts.retryOpts); err != nil {
+			return fmt.Errorf("Error parsing image name %q: %w", c.name, err)
+		}
+		candidateInstance := image.UnparsedInstance(candidateSrc, nil)
+		if err := retry.IfNecessary(ctx, func() error {
+			rawManifest, _, err = candidateInstance.Manifest(ctx)
+			return err
+		}, opts.retryOpts); err != nil {
+			if closeErr := candidateSrc.Close(); closeErr != nil {
+				err = noteCloseFailure(err, "closing image", closeErr)
+			}
+			return fmt.Errorf("Error retrieving manifest for image: %w", err)
+		}
+		sys, served, src, unparsedInstance = candidateSys, c, candidateSrc, candidateInstance
+		return nil
+	}); err != nil {
 		return err
-	}, opts.retryOpts); err != nil {
-		return fmt.Errorf("Error parsing image name %q: %w", imageName, err)
 	}
 
 	defer func() {
@@ -106,14 +145,6 @@ func (opts *inspectOptions) run(args []string, stdout io.Writer) (retErr error)
 		}
 	}()
 
-	unparsedInstance := image.UnparsedInstance(src, nil)
-	if err := retry.IfNecessary(ctx, func() error {
-		rawManifest, _, err = unparsedInstance.Manifest(ctx)
-		return err
-	}, opts.retryOpts); err != nil {
-		return fmt.Errorf("Error retrieving manifest for image: %w", err)
-	}
-
 	if opts.raw && !opts.config {
 		_, err := stdout.Write(rawManifest)
 		if err != nil {
@@ -183,11 +214,18 @@ func (opts *inspectOptions) run(args []string, stdout io.Writer) (retErr error)
 	if dockerRef := img.Reference().DockerReference(); dockerRef != nil {
 		outputData.Name = dockerRef.Name()
 	}
+	if served.mirror != nil {
+		// Report the image the user asked for, not the mirror it happened to come from.
+		if ref, err := parseImageReference(strings.TrimPrefix(imageName, "docker://")); err == nil {
+			outputData.Name = ref.named.Name()
+		}
+	}
 	if !opts.doNotListTags && img.Reference().Transport() == docker.Transport {
 		sys, err := opts.image.newSystemContext()
 		if err != nil {
 			return err
 		}
+		served.updateSystemContext(sys)
 		outputData.RepoTags, err = docker.GetRepositoryTags(ctx, sys, img.Reference())
 		if err != nil {
 			// Some registries may decide to block the "list all tags" endpoint;
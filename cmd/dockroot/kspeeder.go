@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func kspeederCmd(global *globalOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kspeeder",
+		Short: "manage the kspeeder accelerator",
+		RunE:  requireSubcommand,
+	}
+	cmd.AddCommand(
+		kspeederStartCmd(global),
+		kspeederStopCmd(global),
+		kspeederStatusCmd(global),
+		kspeederLogsCmd(global),
+	)
+	return cmd
+}
+
+// loadKspeeder returns the kspeeder accelerator configured in dockroot.json.
+func loadKspeeder(global *globalOptions) (*kspeederAccelerator, error) {
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return nil, err
+	}
+	info, err := global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return nil, err
+	}
+	return newKspeederAccelerator(binaryDir, info), nil
+}
+
+type kspeederStartOptions struct {
+	global    *globalOptions
+	exitAfter int
+}
+
+func kspeederStartCmd(global *globalOptions) *cobra.Command {
+	opts := kspeederStartOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "start",
+		Short:   "start kspeeder in the background",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot kspeeder start --exit-after 4`,
+	}
+	flags := cmd.Flags()
+	flags.IntVar(&opts.exitAfter, "exit-after", 0, "Stop kspeeder after `HOURS`, 0 to keep it running until stopped")
+	return cmd
+}
+
+func (opts *kspeederStartOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return errorShouldDisplayUsage{errors.New("No arguments expected")}
+	}
+	acc, err := loadKspeeder(opts.global)
+	if err != nil {
+		return err
+	}
+	if acc.healthy() {
+		fmt.Fprintln(stdout, "kspeeder is already running")
+		return nil
+	}
+	return acc.startFor(opts.exitAfter)
+}
+
+type kspeederStopOptions struct {
+	global *globalOptions
+}
+
+func kspeederStopCmd(global *globalOptions) *cobra.Command {
+	opts := kspeederStopOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "stop",
+		Short:   "stop kspeeder started by DockRoot",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot kspeeder stop`,
+	}
+	return cmd
+}
+
+func (opts *kspeederStopOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return errorShouldDisplayUsage{errors.New("No arguments expected")}
+	}
+	acc, err := loadKspeeder(opts.global)
+	if err != nil {
+		return err
+	}
+	return acc.stop()
+}
+
+type kspeederStatusOptions struct {
+	global *globalOptions
+}
+
+func kspeederStatusCmd(global *globalOptions) *cobra.Command {
+	opts := kspeederStatusOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "status",
+		Short:   "show whether kspeeder is running and healthy",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot kspeeder status`,
+	}
+	return cmd
+}
+
+func (opts *kspeederStatusOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return errorShouldDisplayUsage{errors.New("No arguments expected")}
+	}
+	acc, err := loadKspeeder(opts.global)
+	if err != nil {
+		return err
+	}
+	pid, err := readPidFile(acc.pidPath)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		fmt.Fprintln(stdout, "process: not started by DockRoot")
+	case err != nil:
+		return err
+	case processAlive(pid) && processCommandContains(pid, "kspeeder"):
+		fmt.Fprintf(stdout, "process: running (PID %d)\n", pid)
+	default:
+		fmt.Fprintf(stdout, "process: exited (stale PID %d)\n", pid)
+	}
+	fmt.Fprintf(stdout, "endpoint: %s\n", acc.healthURL())
+	if checkIsKspeederRun(acc.client(), acc.healthURL()) {
+		fmt.Fprintln(stdout, "health: ok")
+	} else {
+		fmt.Fprintln(stdout, "health: not responding")
+	}
+	return nil
+}
+
+type kspeederLogsOptions struct {
+	global *globalOptions
+	tail   int
+}
+
+func kspeederLogsCmd(global *globalOptions) *cobra.Command {
+	opts := kspeederLogsOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "logs",
+		Short:   "show the output of kspeeder started by DockRoot",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot kspeeder logs --tail 50`,
+	}
+	flags := cmd.Flags()
+	flags.IntVarP(&opts.tail, "tail", "n", 0, "Only show the last `N` lines, 0 for all")
+	return cmd
+}
+
+func (opts *kspeederLogsOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 0 {
+		return errorShouldDisplayUsage{errors.New("No arguments expected")}
+	}
+	acc, err := loadKspeeder(opts.global)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(acc.logPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if opts.tail <= 0 {
+		_, err = io.Copy(stdout, f)
+		return err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > opts.tail {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, line := range lines {
+		fmt.Fprintln(stdout, line)
+	}
+	return nil
+}
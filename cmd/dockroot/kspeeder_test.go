@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForReady(t *testing.T) {
+	polls := 0
+	err := waitForReady("test", func() bool {
+		polls++
+		return polls == 3
+	}, 10*time.Second, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, polls)
+
+	err = waitForReady("test", func() bool { return false }, time.Second, nil)
+	assert.ErrorContains(t, err, "did not become ready")
+
+	exited := make(chan error, 1)
+	exited <- errors.New("exit status 1")
+	err = waitForReady("test", func() bool { return false }, 10*time.Second, exited)
+	assert.ErrorContains(t, err, "exited before becoming ready: exit status 1")
+}
+
+func TestStopKspeeder(t *testing.T) {
+	sleepPath, err := exec.LookPath("sleep")
+	if err != nil {
+		t.Skip("sleep not available")
+	}
+	dir := t.TempDir()
+	pidPath := filepath.Join(dir, "kspeeder.pid")
+
+	// Nothing to do without a PID file.
+	require.NoError(t, stopKspeeder(pidPath))
+
+	// A stale PID file pointing at an unrelated process must not kill it.
+	other := exec.Command(sleepPath, "60")
+	require.NoError(t, other.Start())
+	defer func() {
+		_ = other.Process.Kill()
+		_ = other.Wait()
+	}()
+	require.NoError(t, writePidFile(pidPath, other.Process.Pid))
+	require.NoError(t, stopKspeeder(pidPath))
+	assert.True(t, processAlive(other.Process.Pid))
+	_, err = os.Stat(pidPath)
+	assert.True(t, os.IsNotExist(err))
+
+	fakeKspeeder := filepath.Join(dir, "kspeeder")
+	require.NoError(t, os.Symlink(sleepPath, fakeKspeeder))
+	cmd := exec.Command(fakeKspeeder, "60")
+	require.NoError(t, cmd.Start())
+	exited := make(chan struct{})
+	go func() {
+		_ = cmd.Wait()
+		close(exited)
+	}()
+	require.NoError(t, writePidFile(pidPath, cmd.Process.Pid))
+	pid, err := readPidFile(pidPath)
+	require.NoError(t, err)
+	assert.Equal(t, cmd.Process.Pid, pid)
+
+	require.NoError(t, stopKspeeder(pidPath))
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("kspeeder was not stopped")
+	}
+	_, err = os.Stat(pidPath)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/containers/image/v5/docker"
 	"github.com/containers/image/v5/docker/archive"
 	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/transports/alltransports"
 	"github.com/containers/image/v5/types"
 	"github.com/spf13/cobra"
@@ -29,6 +30,7 @@ type tagsOptions struct {
 	global    *globalOptions
 	image     *imageOptions
 	retryOpts *retry.Options
+	mirrors   []*registryMirror // Docker Hub mirrors to try, in order
 }
 
 var transportHandlers = map[string]func(ctx context.Context, sys *types.SystemContext, opts *tagsOptions, userInput string) (repositoryName string, tagListing []string, err error){
@@ -107,18 +109,27 @@ func listDockerTags(ctx context.Context, sys *types.SystemContext, imgRef types.
 }
 
 // return the tagLists from a docker repo
+// Docker Hub repositories are listed through the configured mirrors, falling back to Docker Hub itself.
 func listDockerRepoTags(ctx context.Context, sys *types.SystemContext, opts *tagsOptions, userInput string) (repositoryName string, tagListing []string, err error) {
 	// Do transport-specific parsing and validation to get an image reference
 	imgRef, err := parseDockerRepositoryReference(userInput)
 	if err != nil {
 		return
 	}
-	if err = retry.IfNecessary(ctx, func() error {
-		repositoryName, tagListing, err = listDockerTags(ctx, sys, imgRef)
-		return err
-	}, opts.retryOpts); err != nil {
-		return
-	}
+	repositoryName = imgRef.DockerReference().Name()
+	candidates := imageNameCandidates(transports.ImageName(imgRef), opts.mirrors)
+	err = tryImageSources(ctx, candidates, repositoryName, func(c imageSourceCandidate) error {
+		candidateRef, err := docker.ParseReference(strings.TrimPrefix(c.name, docker.Transport.Name()+":"))
+		if err != nil {
+			return err
+		}
+		candidateSys := *sys
+		c.updateSystemContext(&candidateSys)
+		return retry.IfNecessary(ctx, func() error {
+			_, tagListing, err = listDockerTags(ctx, &candidateSys, candidateRef)
+			return err
+		}, opts.retryOpts)
+	})
 	return
 }
 
@@ -170,6 +181,7 @@ func (opts *tagsOptions) run(args []string, stdout io.Writer) (retErr error) {
 	if err != nil {
 		return err
 	}
+	opts.mirrors = configuredHubMirrors()
 
 	transport := alltransports.TransportFromImageName(args[0])
 	if transport == nil {
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	logOptMaxSize  = "max-size"
+	logOptMaxFile  = "max-file"
+	logOptCompress = "compress"
+)
+
+// maxLogLineSize is the longest line written to a log as one, as in docker; longer ones are split.
+const maxLogLineSize = 16 * 1024
+
+// logOptions are the --log-opt settings of the log of a detached container.
+type logOptions struct {
+	maxSize  int64 // Rotate the log before it grows past this many bytes; no limit if 0
+	maxFile  int   // Number of logs to keep, counting the current one
+	compress bool  // gzip rotated logs
+}
+
+// logOptions returns the log options of a detached container: the log-opts of dockroot.json, with the KEY=VALUE
+// settings of overrides applied.
+func (info *registryInfo) logOptions(overrides []string) (logOptions, error) {
+	var defaults map[string]string
+	if info != nil {
+		defaults = info.LogOpts
+	}
+	return parseLogOptions(defaults, overrides)
+}
+
+// parseLogOptions parses the log options max-size (e.g. 10m), max-file and compress of defaults, with the
+// KEY=VALUE settings of overrides applied.
+func parseLogOptions(defaults map[string]string, overrides []string) (logOptions, error) {
+	settings := map[string]string{}
+	for k, v := range defaults {
+		settings[k] = v
+	}
+	for _, kv := range overrides {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return logOptions{}, fmt.Errorf("Invalid --log-opt %q, must be KEY=VALUE", kv)
+		}
+		settings[k] = v
+	}
+	opts := logOptions{maxFile: 1}
+	for k, v := range settings {
+		var err error
+		switch k {
+		case logOptMaxSize:
+			if opts.maxSize, err = units.RAMInBytes(v); err == nil && opts.maxSize < 0 {
+				err = errors.New("negative size")
+			}
+		case logOptMaxFile:
+			if opts.maxFile, err = strconv.Atoi(v); err == nil && opts.maxFile < 1 {
+				err = errors.New("must be at least 1")
+			}
+		case logOptCompress:
+			opts.compress, err = strconv.ParseBool(v)
+		default:
+			return logOptions{}, fmt.Errorf("Unknown log option %q, must be %s, %s or %s", k, logOptMaxSize, logOptMaxFile, logOptCompress)
+		}
+		if err != nil {
+			return logOptions{}, fmt.Errorf("Invalid log option %s=%q: %w", k, v, err)
+		}
+	}
+	if opts.maxSize == 0 && opts.maxFile > 1 {
+		return logOptions{}, fmt.Errorf("Log option %s needs %s", logOptMaxFile, logOptMaxSize)
+	}
+	return opts, nil
+}
+
+// args returns opts as --log-opt flags of supervise.
+func (opts logOptions) args() []string {
+	return []string{
+		"--log-opt", fmt.Sprintf("%s=%d", logOptMaxSize, opts.maxSize),
+		"--log-opt", fmt.Sprintf("%s=%d", logOptMaxFile, opts.maxFile),
+		"--log-opt", fmt.Sprintf("%s=%t", logOptCompress, opts.compress),
+	}
+}
+
+// startLogged starts cmd with its standard output and error written to log line by line, and returns a function
+// waiting for it to exit and for its output to be written.
+func startLogged(log *rotatingLog, cmd *exec.Cmd) (func() error, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdout, cmd.Stderr = w, w
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return func() error {
+		// Reading ends once the command, and whatever it started, closed the pipe.
+		if err := shipLog(r, log); err != nil {
+			logrus.Warnf("Writing the log %s: %v", log.path, err)
+		}
+		r.Close()
+		return cmd.Wait()
+	}, nil
+}
+
+// shipLog writes each line read from r to log, stamped with the time it was read, until r ends. Lines longer than
+// maxLogLineSize are split, so that a command never writing a newline does not make the log shipper buffer it all.
+// Lines which cannot be written are dropped rather than holding up the command writing them; the first such error
+// is returned at the end.
+func shipLog(r io.Reader, log *rotatingLog) error {
+	var writeErr error
+	br := bufio.NewReaderSize(r, maxLogLineSize)
+	for {
+		line, err := br.ReadSlice('\n')
+		if len(line) > 0 {
+			if werr := log.writeLine(time.Now(), strings.TrimSuffix(string(line), "\n")); werr != nil && writeErr == nil {
+				writeErr = werr
+			}
+		}
+		if err != nil {
+			if errors.Is(err, bufio.ErrBufferFull) {
+				continue
+			}
+			if errors.Is(err, io.EOF) {
+				return writeErr
+			}
+			return err
+		}
+	}
+}
+
+// rotatingLog is a container log which is rotated as its options say.
+type rotatingLog struct {
+	path string
+	opts logOptions
+	f    *os.File
+	size int64
+}
+
+// openRotatingLog opens the log at path for appending.
+func openRotatingLog(path string, opts logOptions) (*rotatingLog, error) {
+	l := &rotatingLog{path: path, opts: opts}
+	if err := l.open(0); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *rotatingLog) open(flag int) error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|flag, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.f, l.size = f, st.Size()
+	return nil
+}
+
+// writeLine writes text as a line of the log with the timestamp t, rotating the log first if the line would
+// make it grow past its maximum size.
+func (l *rotatingLog) writeLine(t time.Time, text string) error {
+	line := t.Format(logTimeFormat) + " " + text + "\n"
+	if l.opts.maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > l.opts.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := l.f.WriteString(line)
+	l.size += int64(n)
+	return err
+}
+
+// rotate moves the log to path.1, the older logs one up, and drops those beyond the maximum number of files,
+// or truncates the log if only one is kept. The log is open again afterwards even if moving files failed.
+func (l *rotatingLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	if l.opts.maxFile <= 1 {
+		dropErr := dropRotatedLogs(l.path, 1)
+		if err := l.open(os.O_TRUNC); err != nil {
+			return err
+		}
+		return dropErr
+	}
+	shiftErr := l.shift()
+	if err := l.open(0); err != nil {
+		return err
+	}
+	if shiftErr != nil {
+		return shiftErr
+	}
+	if l.opts.compress {
+		return compressLogFile(l.path + ".1")
+	}
+	return nil
+}
+
+// shift moves the log to path.1 and the rotated logs one up, dropping the oldest.
+func (l *rotatingLog) shift() error {
+	// Drop the oldest log, and any left by an earlier run keeping more.
+	if err := dropRotatedLogs(l.path, l.opts.maxFile-1); err != nil {
+		return err
+	}
+	for i := l.opts.maxFile - 2; i >= 1; i-- {
+		p := rotatedLogFile(l.path, i)
+		if p == "" {
+			continue
+		}
+		next := fmt.Sprintf("%s.%d", l.path, i+1)
+		if strings.HasSuffix(p, ".gz") {
+			next += ".gz"
+		}
+		if err := os.Rename(p, next); err != nil {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.path+".1")
+}
+
+func (l *rotatingLog) Close() error {
+	return l.f.Close()
+}
+
+// dropRotatedLogs removes the rotated logs of the log at path from number first on.
+func dropRotatedLogs(path string, first int) error {
+	for i := first; rotatedLogFile(path, i) != ""; i++ {
+		p := fmt.Sprintf("%s.%d", path, i)
+		for _, name := range []string{p, p + ".gz"} {
+			if err := os.Remove(name); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// rotatedLogFile returns the rotated log number i of the log at path, path.i or path.i.gz, or "" if there is none.
+func rotatedLogFile(path string, i int) string {
+	p := fmt.Sprintf("%s.%d", path, i)
+	for _, name := range []string{p, p + ".gz"} {
+		if _, err := os.Lstat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+// compressLogFile replaces the log at path with path.gz.
+func compressLogFile(path string) (retErr error) {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".log-*.gz")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if retErr != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+	zw := gzip.NewWriter(tmp)
+	if _, err := io.Copy(zw, src); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// Keep the time of the last line, which logs goes by for lines without a timestamp.
+	if err := os.Chtimes(tmp.Name(), st.ModTime(), st.ModTime()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path+".gz"); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogOptions(t *testing.T) {
+	opts, err := parseLogOptions(map[string]string{"max-size": "1k", "max-file": "5"}, []string{"max-file=3", "compress=true"})
+	require.NoError(t, err)
+	assert.Equal(t, logOptions{maxSize: 1024, maxFile: 3, compress: true}, opts)
+
+	opts, err = parseLogOptions(nil, nil)
+	require.NoError(t, err)
+	assert.Equal(t, logOptions{maxFile: 1}, opts)
+
+	// args passes the options on to log-shipper.
+	opts = logOptions{maxSize: 10 << 20, maxFile: 2, compress: true}
+	var overrides []string
+	args := opts.args()
+	for i := 1; i < len(args); i += 2 {
+		overrides = append(overrides, args[i])
+	}
+	opts, err = parseLogOptions(nil, overrides)
+	require.NoError(t, err)
+	assert.Equal(t, logOptions{maxSize: 10 << 20, maxFile: 2, compress: true}, opts)
+	for _, bad := range [][]string{{"max-size"}, {"max-size=big"}, {"max-file=0", "max-size=1m"}, {"max-file=3"}, {"compress=maybe"}, {"mode=non-blocking"}} {
+		_, err := parseLogOptions(nil, bad)
+		assert.Error(t, err, "%v", bad)
+	}
+}
+
+func TestRotatingLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), containerLogFile)
+	stamp := time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)
+	// Each line is 32 bytes with its timestamp, so two fit.
+	line := func(i int) string { return strings.Repeat(string(rune('a'+i)), 10) }
+	log, err := openRotatingLog(logPath, logOptions{maxSize: 64, maxFile: 3, compress: true})
+	require.NoError(t, err)
+	for i := range 7 {
+		require.NoError(t, log.writeLine(stamp, line(i)))
+	}
+	require.NoError(t, log.Close())
+
+	assert.Equal(t, []string{logPath + ".2.gz", logPath + ".1.gz", logPath}, containerLogFiles(logPath))
+	current, err := os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02T13:00:00Z "+line(6)+"\n", string(current))
+	f, err := os.Open(logPath + ".2.gz")
+	require.NoError(t, err)
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	require.NoError(t, err)
+	oldest, err := io.ReadAll(zr)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02T13:00:00Z "+line(2)+"\n2024-01-02T13:00:00Z "+line(3)+"\n", string(oldest))
+
+	lines, _, err := readContainerLogs(logPath, time.Time{}, -1, true)
+	require.NoError(t, err)
+	var texts []string
+	for _, l := range lines {
+		texts = append(texts, l.text)
+	}
+	assert.Equal(t, []string{line(2), line(3), line(4), line(5), line(6)}, texts)
+
+	// Keeping a single file truncates it, and drops the rotated logs once it is full.
+	log, err = openRotatingLog(logPath, logOptions{maxSize: 64, maxFile: 1})
+	require.NoError(t, err)
+	require.NoError(t, log.writeLine(stamp, line(7)))
+	require.NoError(t, log.writeLine(stamp, line(8)))
+	require.NoError(t, log.Close())
+	current, err = os.ReadFile(logPath)
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-02T13:00:00Z "+line(8)+"\n", string(current))
+	assert.Equal(t, []string{logPath}, containerLogFiles(logPath))
+}
+
+func TestStartLogged(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), containerLogFile)
+	log, err := openRotatingLog(logPath, logOptions{maxFile: 1})
+	require.NoError(t, err)
+	defer log.Close()
+	wait, err := startLogged(log, exec.Command("sh", "-c", "echo out; echo err >&2; printf partial; exit 3"))
+	require.NoError(t, err)
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, wait(), &exitErr)
+	assert.Equal(t, 3, exitErr.ExitCode())
+
+	lines, _, err := readContainerLogs(logPath, time.Time{}, -1, true)
+	require.NoError(t, err)
+	var texts []string
+	for _, l := range lines {
+		assert.WithinDuration(t, time.Now(), l.time, time.Minute)
+		texts = append(texts, l.text)
+	}
+	assert.Equal(t, []string{"out", "err", "partial"}, texts)
+}
+
+func TestShipLogLongLines(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), containerLogFile)
+	log, err := openRotatingLog(logPath, logOptions{maxFile: 1})
+	require.NoError(t, err)
+	defer log.Close()
+	long := strings.Repeat("x", 2*maxLogLineSize+10)
+	require.NoError(t, shipLog(strings.NewReader(long+"\nend\n"), log))
+
+	lines, _, err := readContainerLogs(logPath, time.Time{}, -1, true)
+	require.NoError(t, err)
+	var texts []string
+	for _, l := range lines {
+		texts = append(texts, l.text)
+	}
+	assert.Equal(t, []string{long[:maxLogLineSize], long[:maxLogLineSize], long[:10], "end"}, texts)
+}
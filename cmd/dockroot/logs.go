@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// containerLogFile is the file in the container directory the output of a detached container goes to.
+// Rotated logs sit next to it as containerLogFile.1 (the newest), containerLogFile.2 and so on, gzipped with a .gz
+// suffix if the log options ask for it.
+const containerLogFile = "ruri.log"
+
+// logTimeFormat is the format of the timestamp which may start each line of a container log.
+const logTimeFormat = time.RFC3339Nano
+
+const (
+	logPollInterval    = 250 * time.Millisecond
+	logRunningInterval = 2 * time.Second // How often follow checks that the container still runs
+)
+
+type logsOptions struct {
+	global     *globalOptions
+	follow     bool
+	tail       string
+	since      string
+	timestamps bool
+}
+
+func logsCmd(global *globalOptions) *cobra.Command {
+	opts := logsOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "logs [-f] [--tail N] [--since T] [-t] NAME",
+		Short: "show the output of a detached container",
+		Long: `Show the output of the container NAME run with run -d, including rotated logs.
+
+Each line is stamped with the time DockRoot read it from the container. Logs written by ruri itself,
+by earlier versions, hold the raw output: for their lines --since goes by the time the log file was
+last written, and -t shows the time the line was read while following.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot logs alpine001
+DockRoot logs -f --tail 100 alpine001
+DockRoot logs --since 10m -t alpine001`,
+	}
+	flags := cmd.Flags()
+	flags.BoolVarP(&opts.follow, "follow", "f", false, "Follow log output until the container stops")
+	flags.StringVarP(&opts.tail, "tail", "n", "all", "Number of lines to show from the end of the logs, or \"all\"")
+	flags.StringVar(&opts.since, "since", "", "Show logs since a timestamp (e.g. 2024-01-02T13:23:37Z) or relative time (e.g. 42m)")
+	flags.BoolVarP(&opts.timestamps, "timestamps", "t", false, "Show timestamps")
+	return cmd
+}
+
+func (opts *logsOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s logs [-f] [--tail N] [--since T] [-t] NAME", os.Args[0])
+	}
+	tail := -1
+	if opts.tail != "all" {
+		n, err := strconv.Atoi(opts.tail)
+		if err != nil || n < 0 {
+			return fmt.Errorf("Invalid --tail %q, must be a number of lines or \"all\"", opts.tail)
+		}
+		tail = n
+	}
+	var since time.Time
+	if opts.since != "" {
+		var err error
+		if since, err = parseSince(opts.since, time.Now()); err != nil {
+			return err
+		}
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, CleanString(args[0])))
+	if err != nil {
+		return err
+	}
+	if !isDirValid(destAbsDir) {
+		return fmt.Errorf("No such container: %s", args[0])
+	}
+	logPath := filepath.Join(destAbsDir, containerLogFile)
+
+	lines, offset, err := readContainerLogs(logPath, since, tail, !opts.follow)
+	if err != nil {
+		return err
+	}
+	for _, l := range lines {
+		if err := l.write(stdout, opts.timestamps); err != nil {
+			return err
+		}
+	}
+	if !opts.follow {
+		return nil
+	}
+
+	ruriPath := filepath.Join(binaryDir, "ruri")
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+	confPath := filepath.Join(destAbsDir, "ruri.conf")
+	running := func() bool {
+		// A container waiting to be restarted has no processes, but its supervisor runs.
+		if state, err := readContainerState(destAbsDir); err == nil && state.supervised() {
+			return true
+		}
+		pids, err := RuriPids(ruriPath, confPath)
+		return err == nil && len(pids) > 0
+	}
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+	return followContainerLog(ctx, logPath, offset, running, func(l logLine) error {
+		return l.write(stdout, opts.timestamps)
+	})
+}
+
+// logLine is one line of a container log.
+type logLine struct {
+	time time.Time // Zero if the line has no timestamp
+	text string    // Without the timestamp and the newline
+}
+
+// parseLogLine splits the timestamp off line, if it starts with one.
+func parseLogLine(line string) logLine {
+	line = strings.TrimSuffix(line, "\n")
+	if stamp, text, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(logTimeFormat, stamp); err == nil {
+			return logLine{time: t, text: text}
+		}
+	}
+	return logLine{text: line}
+}
+
+// write writes l to w, with its timestamp if timestamps is set and it has one.
+func (l logLine) write(w io.Writer, timestamps bool) error {
+	var err error
+	if timestamps && !l.time.IsZero() {
+		_, err = fmt.Fprintf(w, "%s %s\n", l.time.Format(logTimeFormat), l.text)
+	} else {
+		_, err = fmt.Fprintln(w, l.text)
+	}
+	return err
+}
+
+// parseSince parses the --since value s: an RFC 3339 time or date, Unix seconds, or a duration before now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Unix(0, int64(secs*float64(time.Second))), nil
+	}
+	return time.Time{}, fmt.Errorf("Invalid --since %q, must be a time like 2024-01-02T13:23:37Z or a duration like 42m", s)
+}
+
+// containerLogFiles returns the logs of the container whose log is path, oldest first.
+func containerLogFiles(path string) []string {
+	var rotated []string
+	for i := 1; ; i++ {
+		p := rotatedLogFile(path, i)
+		if p == "" {
+			break
+		}
+		rotated = append(rotated, p)
+	}
+	files := make([]string, 0, len(rotated)+1)
+	for i := len(rotated) - 1; i >= 0; i-- {
+		files = append(files, rotated[i])
+	}
+	return append(files, path)
+}
+
+// readContainerLogs returns the last tail lines (all if tail is negative) of the logs of the container whose log
+// is path, leaving out lines from before since, and the offset in path up to which it read.
+// A trailing line without a newline is only returned if partial is set; otherwise it is left for following.
+// Lines without a timestamp count as written when the file they are in was last modified.
+func readContainerLogs(path string, since time.Time, tail int, partial bool) ([]logLine, int64, error) {
+	var lines []logLine
+	var offset int64
+	for _, p := range containerLogFiles(path) {
+		f, err := os.Open(p)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, 0, err
+		}
+		st, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, 0, err
+		}
+		var r io.Reader = f
+		if strings.HasSuffix(p, ".gz") {
+			zr, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				return nil, 0, fmt.Errorf("Reading %s: %w", p, err)
+			}
+			r = zr
+		}
+		n, err := readLogLines(r, p == path && !partial, func(l logLine) {
+			lineTime := l.time
+			if lineTime.IsZero() {
+				lineTime = st.ModTime()
+			}
+			if !since.IsZero() && lineTime.Before(since) {
+				return
+			}
+			lines = append(lines, l)
+			if tail >= 0 && len(lines) > tail {
+				lines = lines[1:]
+			}
+		})
+		f.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		if p == path {
+			offset = n
+		}
+	}
+	return lines, offset, nil
+}
+
+// readLogLines calls fn for each line read from r, and returns the number of bytes of the lines it was called for.
+// If completeOnly is set, a trailing line without a newline is left alone.
+func readLogLines(r io.Reader, completeOnly bool, fn func(logLine)) (int64, error) {
+	var n int64
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return n, err
+		}
+		if line != "" && (err == nil || !completeOnly) {
+			fn(parseLogLine(line))
+			n += int64(len(line))
+		}
+		if err != nil {
+			return n, nil
+		}
+	}
+}
+
+// followContainerLog calls fn for each line written to the log at path after offset, until ctx is done or the
+// container stops, as running tells. It goes on with the new file if the log is rotated, and from the start if it
+// is truncated. Lines without a timestamp are given the time they were read.
+func followContainerLog(ctx context.Context, path string, offset int64, running func() bool, fn func(logLine) error) error {
+	var f *os.File
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+	var fnErr error
+	stamp := func(l logLine) {
+		if l.time.IsZero() {
+			l.time = time.Now()
+		}
+		if fnErr == nil {
+			fnErr = fn(l)
+		}
+	}
+	var lastCheck time.Time
+	stopped := false
+	for {
+		if f == nil {
+			var err error
+			if f, err = os.Open(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+		if f != nil {
+			if _, err := f.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+			n, err := readLogLines(f, !stopped, stamp)
+			offset += n
+			if err != nil {
+				return err
+			}
+			if fnErr != nil {
+				return fnErr
+			}
+			if n > 0 {
+				continue
+			}
+			// Nothing new in the file we have open; see if it was rotated or truncated.
+			st, err := os.Stat(path)
+			switch {
+			case err == nil && !sameFileInfo(f, st):
+				// Whatever was left of a line in the old file will not be finished. Reading stopped before it.
+				if _, err := f.Seek(offset, io.SeekStart); err != nil {
+					return err
+				}
+				if _, err := readLogLines(f, false, stamp); err != nil {
+					return err
+				}
+				if fnErr != nil {
+					return fnErr
+				}
+				f.Close()
+				f, offset = nil, 0
+				continue
+			case err == nil && st.Size() < offset:
+				offset = 0
+				continue
+			}
+		}
+		if stopped {
+			return nil
+		}
+		if time.Since(lastCheck) >= logRunningInterval {
+			lastCheck = time.Now()
+			// Read once more after the container stopped, for what it wrote last.
+			stopped = !running()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(logPollInterval):
+		}
+	}
+}
+
+// sameFileInfo returns true if st describes the file f is open on.
+func sameFileInfo(f *os.File, st os.FileInfo) bool {
+	fst, err := f.Stat()
+	return err == nil && os.SameFile(fst, st)
+}
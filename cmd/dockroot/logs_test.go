@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)
+	for _, c := range []struct {
+		input    string
+		expected time.Time
+	}{
+		{"42m", now.Add(-42 * time.Minute)},
+		{"2024-01-02T13:23:37Z", time.Date(2024, 1, 2, 13, 23, 37, 0, time.UTC)},
+		{"2024-01-02T13:23:37.5+08:00", time.Date(2024, 1, 2, 5, 23, 37, 500000000, time.UTC)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)},
+		{"1704200617", time.Unix(1704200617, 0)},
+	} {
+		since, err := parseSince(c.input, now)
+		require.NoError(t, err, c.input)
+		assert.True(t, c.expected.Equal(since), "%s: %v", c.input, since)
+	}
+	_, err := parseSince("yesterday", now)
+	assert.Error(t, err)
+}
+
+func TestReadContainerLogs(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, containerLogFile)
+	require.NoError(t, os.WriteFile(logPath+".2", []byte("raw old\n"), 0644))
+	old := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+	require.NoError(t, os.Chtimes(logPath+".2", old, old))
+	require.NoError(t, os.WriteFile(logPath+".1", []byte("2024-01-02T13:00:00Z first\n2024-01-02T14:00:00Z second\n"), 0644))
+	require.NoError(t, os.WriteFile(logPath, []byte("raw new\npartial"), 0644))
+
+	texts := func(lines []logLine) []string {
+		var s []string
+		for _, l := range lines {
+			s = append(s, l.text)
+		}
+		return s
+	}
+	lines, offset, err := readContainerLogs(logPath, time.Time{}, -1, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"raw old", "first", "second", "raw new", "partial"}, texts(lines))
+	assert.EqualValues(t, len("raw new\npartial"), offset)
+	assert.True(t, lines[1].time.Equal(time.Date(2024, 1, 2, 13, 0, 0, 0, time.UTC)))
+
+	// A partial line is left for following.
+	lines, offset, err = readContainerLogs(logPath, time.Time{}, 2, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second", "raw new"}, texts(lines))
+	assert.EqualValues(t, len("raw new\n"), offset)
+
+	// Raw lines go by the time their file was written.
+	lines, _, err = readContainerLogs(logPath, time.Date(2024, 1, 2, 13, 30, 0, 0, time.UTC), -1, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"second", "raw new", "partial"}, texts(lines))
+
+	lines, _, err = readContainerLogs(filepath.Join(t.TempDir(), containerLogFile), time.Time{}, -1, true)
+	require.NoError(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestFollowContainerLog(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), containerLogFile)
+	require.NoError(t, os.WriteFile(logPath, []byte("seen\n"), 0644))
+	var running atomic.Bool
+	running.Store(true)
+	var mu sync.Mutex
+	var got []string
+	done := make(chan error)
+	go func() {
+		done <- followContainerLog(context.Background(), logPath, int64(len("seen\n")), running.Load, func(l logLine) error {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.False(t, l.time.IsZero())
+			got = append(got, l.text)
+			return nil
+		})
+	}()
+	waitFor := func(expected ...string) {
+		t.Helper()
+		require.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return assert.ObjectsAreEqual(expected, got)
+		}, 5*time.Second, 10*time.Millisecond, "%v", got)
+	}
+	appendLog := func(path, s string) {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		require.NoError(t, err)
+		_, err = f.WriteString(s)
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+	}
+
+	appendLog(logPath, "one\ntw")
+	waitFor("one")
+	appendLog(logPath, "o\n")
+	waitFor("one", "two")
+	// Truncated in place.
+	require.NoError(t, os.Truncate(logPath, 0))
+	appendLog(logPath, "three\n")
+	waitFor("one", "two", "three")
+	// Rotated, with the end of the old file written after the rename.
+	require.NoError(t, os.Rename(logPath, logPath+".1"))
+	appendLog(logPath+".1", "four\n")
+	appendLog(logPath, "five\n")
+	waitFor("one", "two", "three", "four", "five")
+	// Rotated with an unfinished line, which is shown as it is.
+	appendLog(logPath, "six\nunfin")
+	waitFor("one", "two", "three", "four", "five", "six")
+	require.NoError(t, os.Rename(logPath, logPath+".1"))
+	appendLog(logPath, "seven\n")
+	waitFor("one", "two", "three", "four", "five", "six", "unfin", "seven")
+
+	appendLog(logPath, "last")
+	running.Store(false)
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("follow did not end after the container stopped")
+	}
+	waitFor("one", "two", "three", "four", "five", "six", "unfin", "seven", "last")
+}
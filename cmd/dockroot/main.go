@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -83,9 +84,9 @@ func createApp() (*cobra.Command, *globalOptions) {
 	rootCommand.PersistentFlags().StringVar(&opts.policyPath, "policy", "", "Path to a trust policy file")
 	rootCommand.PersistentFlags().BoolVar(&opts.insecurePolicy, "insecure-policy", false, "run the tool without any policy check")
 	rootCommand.PersistentFlags().StringVar(&opts.registriesDirPath, "registries.d", "", "use registry configuration files in `DIR` (e.g. for container signature storage)")
-	rootCommand.PersistentFlags().StringVar(&opts.overrideArch, "override-arch", "", "use `ARCH` instead of the architecture of the machine for choosing images")
+	rootCommand.PersistentFlags().StringVar(&opts.overrideArch, "override-arch", "", "use `ARCH` instead of the architecture of the machine for choosing images, and for ensuredeps --bundle")
 	rootCommand.PersistentFlags().StringVar(&opts.overrideOS, "override-os", "", "use `OS` instead of the running OS for choosing images")
-	rootCommand.PersistentFlags().StringVar(&opts.overrideVariant, "override-variant", "", "use `VARIANT` instead of the running architecture variant for choosing images")
+	rootCommand.PersistentFlags().StringVar(&opts.overrideVariant, "override-variant", "", "use `VARIANT` instead of the running architecture variant for choosing images, and for ensuredeps --bundle")
 	rootCommand.PersistentFlags().DurationVar(&opts.commandTimeout, "command-timeout", 0, "timeout for the command execution")
 	rootCommand.PersistentFlags().StringVar(&opts.registriesConfPath, "registries-conf", "", "path to the registries.conf file")
 	if err := rootCommand.PersistentFlags().MarkHidden("registries-conf"); err != nil {
@@ -96,15 +97,31 @@ func createApp() (*cobra.Command, *globalOptions) {
 	flag.Hidden = true
 	rootCommand.AddCommand(
 		pullCmd(&opts),
+		pushCmd(&opts),
+		imagesCmd(&opts),
+		rmiCmd(&opts),
+		createCmd(&opts),
+		saveCmd(&opts),
+		exportCmd(&opts),
+		syncCmd(&opts),
+		commitCmd(&opts),
+		buildCmd(&opts),
 		ensureDepsCmd(&opts),
 		ruriRunCmd(&opts),
+		execCmd(&opts),
 		ruriStopCmd(&opts),
+		waitCmd(&opts),
 		ruriPidsCmd(&opts),
+		logsCmd(&opts),
+		superviseCmd(),
 		ruriRmCmd(&opts),
+		resetCmd(&opts),
+		dedupeCmd(&opts),
 		inspectCmd(&opts),
 		layersCmd(&opts),
 		manifestDigestCmd(),
 		tagsCmd(&opts),
+		kspeederCmd(&opts),
 	)
 	return rootCommand, &opts
 }
@@ -126,6 +143,11 @@ func main() {
 	}
 	rootCmd, _ := createApp()
 	if err := rootCmd.Execute(); err != nil {
+		// exec and run exit with the status of the command they ran, which reported its own errors.
+		var exitCode exitCodeError
+		if errors.As(err, &exitCode) {
+			logrus.Exit(int(exitCode))
+		}
 		if isNotFoundImageError(err) {
 			logrus.StandardLogger().Log(logrus.FatalLevel, err)
 			logrus.Exit(2)
@@ -165,11 +187,12 @@ func (opts *globalOptions) commandTimeoutContext() (context.Context, context.Can
 // newSystemContext returns a *types.SystemContext corresponding to opts.
 // It is guaranteed to return a fresh instance, so it is safe to make additional updates to it.
 func (opts *globalOptions) newSystemContext() *types.SystemContext {
+	platform := opts.imagePlatform()
 	ctx := &types.SystemContext{
 		RegistriesDirPath:        opts.registriesDirPath,
-		ArchitectureChoice:       opts.overrideArch,
-		OSChoice:                 opts.overrideOS,
-		VariantChoice:            opts.overrideVariant,
+		ArchitectureChoice:       platform.arch,
+		OSChoice:                 platform.os,
+		VariantChoice:            platform.variant,
 		SystemRegistriesConfPath: opts.registriesConfPath,
 		BigFilesTemporaryDir:     opts.tmpDir,
 		DockerRegistryUserAgent:  defaultUserAgent,
@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -49,3 +50,13 @@ func TestGlobalOptionsNewSystemContext(t *testing.T) {
 		DockerRegistryUserAgent:     defaultUserAgent,
 	}, res)
 }
+
+// fixturesTestImageManifestDigest is the Docker manifest digest of "image.manifest.json"
+var fixturesTestImageManifestDigest = digest.Digest("sha256:20bf21ed457b390829cdbeec8795a7bea1626991fda603e0d01b4e7f60427e55")
+
+// Test that results of runSkopeo failed with nothing on stdout, and substring
+// within the error message.
+func assertTestFailed(t *testing.T, stdout string, err error, substring string) {
+	assert.ErrorContains(t, err, substring)
+	assert.Empty(t, stdout)
+}
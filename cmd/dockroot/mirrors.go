@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/containers/image/v5/types"
+	"github.com/sirupsen/logrus"
+)
+
+// mirrorProbeTimeout bounds how long we wait for a mirror to answer before falling back to the next one.
+const mirrorProbeTimeout = 5 * time.Second
+
+// registryMirror is a Docker Hub mirror, as configured in registry-mirrors in dockroot.json.
+type registryMirror struct {
+	host     string // HOST[:PORT], as used in docker:// image names
+	insecure bool   // The mirror was configured with http://, so plain HTTP and unverified TLS are accepted
+}
+
+// parseRegistryMirror parses a registry-mirrors entry, either a URL like https://mirror.example.com or a bare HOST[:PORT].
+func parseRegistryMirror(s string) (*registryMirror, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid registry mirror %q: %w", s, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid registry mirror %q: no host", s)
+	}
+	switch u.Scheme {
+	case "https":
+		return &registryMirror{host: u.Host}, nil
+	case "http":
+		return &registryMirror{host: u.Host, insecure: true}, nil
+	default:
+		return nil, fmt.Errorf("Invalid registry mirror %q: unsupported scheme %q", s, u.Scheme)
+	}
+}
+
+// hubMirrors returns the usable entries of registry-mirrors, in the configured order.
+func (info *registryInfo) hubMirrors() []*registryMirror {
+	var mirrors []*registryMirror
+	for _, s := range info.Mirrors {
+		m, err := parseRegistryMirror(s)
+		if err != nil {
+			logrus.Warnf("Ignoring registry mirror: %v", err)
+			continue
+		}
+		mirrors = append(mirrors, m)
+	}
+	return mirrors
+}
+
+// configuredHubMirrors returns the Docker Hub mirrors from dockroot.json, or nil if there is no usable configuration.
+func configuredHubMirrors() []*registryMirror {
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return nil
+	}
+	info, err := readRegistryInfo(binaryDir)
+	if err != nil {
+		logrus.Debugf("Not using registry mirrors: %v", err)
+		return nil
+	}
+	return info.hubMirrors()
+}
+
+// probe checks that the mirror answers the registry API endpoint within mirrorProbeTimeout.
+// Any response other than a server error counts, since most registries answer 401 for anonymous clients.
+func (m *registryMirror) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	scheme := "https"
+	if m.insecure {
+		scheme = "http"
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	}
+	client := &http.Client{Transport: transport}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s://%s/v2/", scheme, m.host), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("mirror %s returned %s", m.host, resp.Status)
+	}
+	return nil
+}
+
+// imageSourceCandidate is one place an image can be fetched from.
+type imageSourceCandidate struct {
+	name   string          // docker:// image name
+	mirror *registryMirror // nil when fetching from the registry named in the reference
+}
+
+// String returns the registry the candidate fetches from, for logging.
+func (c imageSourceCandidate) String() string {
+	if c.mirror != nil {
+		return c.mirror.host
+	}
+	return strings.SplitN(strings.TrimPrefix(c.name, "docker://"), "/", 2)[0]
+}
+
+// updateSystemContext adjusts sys for fetching from the candidate.
+func (c imageSourceCandidate) updateSystemContext(sys *types.SystemContext) {
+	if c.mirror != nil && c.mirror.insecure {
+		sys.DockerInsecureSkipTLSVerify = types.OptionalBoolTrue
+	}
+}
+
+// sourceCandidates returns where ref can be fetched from, in order of preference.
+// Docker Hub references go through each mirror in turn and then Docker Hub itself;
+// other references, and ones where the user asked for docker:// explicitly, are only fetched from their own registry.
+func (ref *imageReference) sourceCandidates(mirrors []*registryMirror) []imageSourceCandidate {
+	upstream := imageSourceCandidate{name: ref.sourceName("")}
+	if !ref.isDockerHub() || ref.direct {
+		return []imageSourceCandidate{upstream}
+	}
+	candidates := make([]imageSourceCandidate, 0, len(mirrors)+1)
+	for _, m := range mirrors {
+		candidates = append(candidates, imageSourceCandidate{name: ref.sourceName(m.host), mirror: m})
+	}
+	return append(candidates, upstream)
+}
+
+// tryImageSources calls fn for each candidate in turn until one succeeds, skipping mirrors which
+// do not answer in time. It logs which candidate served what, and returns all errors if none did.
+func tryImageSources(ctx context.Context, candidates []imageSourceCandidate, what string, fn func(c imageSourceCandidate) error) error {
+	var errs []error
+	for _, c := range candidates {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+		if c.mirror != nil {
+			if err := c.mirror.probe(ctx); err != nil {
+				logrus.Warnf("Skipping mirror %s: %v", c, err)
+				errs = append(errs, err)
+				continue
+			}
+		}
+		if err := fn(c); err != nil {
+			if len(candidates) > 1 {
+				logrus.Warnf("Fetching %s from %s failed: %v", what, c, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if len(candidates) > 1 {
+			logrus.Infof("%s served by %s", what, c)
+		}
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// imageNameCandidates returns where a TRANSPORT:NAME image name, as accepted by inspect and list-tags, can be fetched from.
+// Only docker:// names on Docker Hub go through the mirrors; everything else is used as is.
+func imageNameCandidates(imageName string, mirrors []*registryMirror) []imageSourceCandidate {
+	if refString, ok := strings.CutPrefix(imageName, "docker://"); ok {
+		if ref, err := parseImageReference(refString); err == nil && ref.isDockerHub() {
+			return ref.sourceCandidates(mirrors)
+		}
+	}
+	return []imageSourceCandidate{{name: imageName}}
+}
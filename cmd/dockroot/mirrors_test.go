@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newFakeRegistry returns a registry stand-in which serves tags for library/alpine,
+// and counts the requests it gets.
+func newFakeRegistry(t *testing.T, tags []string) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		switch r.URL.Path {
+		case "/v2/":
+			w.WriteHeader(http.StatusOK)
+		case "/v2/library/alpine/tags/list":
+			if tags == nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"name": "library/alpine", "tags": tags})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &hits
+}
+
+func TestParseRegistryMirror(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		host     string
+		insecure bool
+	}{
+		{"https://registry.istoreos.com", "registry.istoreos.com", false},
+		{"https://docker1.linkease.com:60005", "docker1.linkease.com:60005", false},
+		{"https://docker.m.daocloud.io/", "docker.m.daocloud.io", false},
+		{"http://192.168.1.2:5000", "192.168.1.2:5000", true},
+		{"mirror.local:5000", "mirror.local:5000", false},
+	} {
+		m, err := parseRegistryMirror(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.host, m.host, c.input)
+		assert.Equal(t, c.insecure, m.insecure, c.input)
+	}
+
+	for _, input := range []string{"", "ftp://mirror.local", "https://"} {
+		_, err := parseRegistryMirror(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestSourceCandidates(t *testing.T) {
+	info := registryInfo{Mirrors: []string{"https://m1.example.com", "not a url://", "http://m2.example.com:5000"}}
+	mirrors := info.hubMirrors()
+	require.Len(t, mirrors, 2)
+
+	ref, err := parseImageReference("alpine:3.20")
+	require.NoError(t, err)
+	var names []string
+	for _, c := range ref.sourceCandidates(mirrors) {
+		names = append(names, c.name)
+	}
+	assert.Equal(t, []string{
+		"docker://m1.example.com/library/alpine:3.20",
+		"docker://m2.example.com:5000/library/alpine:3.20",
+		"docker://docker.io/library/alpine:3.20",
+	}, names)
+
+	// Explicit docker:// and other registries never go through the mirrors.
+	for _, input := range []string{"docker://alpine:3.20", "myreg.local:5000/app:1.2"} {
+		ref, err := parseImageReference(input)
+		require.NoError(t, err)
+		candidates := ref.sourceCandidates(mirrors)
+		require.Len(t, candidates, 1, input)
+		assert.Nil(t, candidates[0].mirror, input)
+	}
+
+	// Only docker:// names on Docker Hub are expanded for inspect and list-tags.
+	assert.Len(t, imageNameCandidates("docker://alpine", mirrors), 3)
+	assert.Len(t, imageNameCandidates("docker://quay.io/foo/bar", mirrors), 1)
+	assert.Len(t, imageNameCandidates("oci:/tmp/layout:alpine", mirrors), 1)
+}
+
+func TestListDockerRepoTagsMirrorFallback(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer broken.Close()
+	missing, missingHits := newFakeRegistry(t, nil)
+	good, goodHits := newFakeRegistry(t, []string{"3.19", "3.20", "latest"})
+	unused, unusedHits := newFakeRegistry(t, []string{"never"})
+
+	var mirrors []*registryMirror
+	for _, server := range []*httptest.Server{dead, broken, missing, good, unused} {
+		m, err := parseRegistryMirror(server.URL)
+		require.NoError(t, err)
+		mirrors = append(mirrors, m)
+	}
+	opts := &tagsOptions{retryOpts: &retry.Options{}, mirrors: mirrors}
+	repositoryName, tags, err := listDockerRepoTags(context.Background(), &types.SystemContext{}, opts, "docker://alpine")
+	require.NoError(t, err)
+	assert.Equal(t, "docker.io/library/alpine", repositoryName)
+	assert.Equal(t, []string{"3.19", "3.20", "latest"}, tags)
+	assert.NotZero(t, missingHits.Load())
+	assert.NotZero(t, goodHits.Load())
+	assert.Zero(t, unusedHits.Load())
+}
+
+func TestTryImageSourcesAllFail(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	dead.Close()
+	m, err := parseRegistryMirror(dead.URL)
+	require.NoError(t, err)
+	ref, err := parseImageReference("alpine")
+	require.NoError(t, err)
+
+	var tried []string
+	err = tryImageSources(context.Background(), ref.sourceCandidates([]*registryMirror{m}), "alpine", func(c imageSourceCandidate) error {
+		tried = append(tried, c.String())
+		return assert.AnError
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	// The dead mirror is skipped without calling fn; only Docker Hub itself is tried.
+	assert.Equal(t, []string{"docker.io"}, tried)
+	assert.True(t, strings.Contains(err.Error(), "connection refused"))
+}
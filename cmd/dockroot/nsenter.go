@@ -0,0 +1,24 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func enterContainer(pid int) error {
+	return errors.ErrUnsupported
+}
+
+func dropCapBound(keep uint64) error {
+	return errors.ErrUnsupported
+}
+
+func openPty() (master, slave *os.File, retErr error) {
+	return nil, nil, errors.ErrUnsupported
+}
+
+func resizePty(master, term *os.File) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// containerNamespaces are the namespaces exec joins, in order. The mount namespace comes last, as joining it
+// changes what /proc refers to.
+var containerNamespaces = []struct {
+	name string
+	flag int
+}{
+	{"ipc", unix.CLONE_NEWIPC},
+	{"uts", unix.CLONE_NEWUTS},
+	{"pid", unix.CLONE_NEWPID},
+	{"mnt", unix.CLONE_NEWNS},
+}
+
+// enterContainer moves the calling goroutine into the mount, PID, UTS and IPC namespaces and the root directory of
+// the process pid, so that processes it starts run in the container pid is in.
+// Without cgo the process can not be made single-threaded to do this, so it locks the goroutine to its OS thread
+// and changes only that thread, which is never unlocked and ends with the goroutine.
+func enterContainer(pid int) error {
+	runtime.LockOSThread()
+	root, err := os.Open(fmt.Sprintf("/proc/%d/root", pid))
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	var nsFiles []*os.File
+	defer func() {
+		for _, f := range nsFiles {
+			f.Close()
+		}
+	}()
+	var flags []int
+	for _, ns := range containerNamespaces {
+		path := fmt.Sprintf("/proc/%d/ns/%s", pid, ns.name)
+		same, err := sameFile(path, "/proc/thread-self/ns/"+ns.name)
+		if err != nil {
+			return err
+		}
+		if same {
+			continue
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		nsFiles = append(nsFiles, f)
+		flags = append(flags, ns.flag)
+	}
+	// The thread shares its root and working directory with the rest of the process, which setns
+	// into a mount namespace refuses.
+	if err := unix.Unshare(unix.CLONE_FS); err != nil {
+		return fmt.Errorf("unsharing filesystem attributes: %w", err)
+	}
+	for i, f := range nsFiles {
+		if err := unix.Setns(int(f.Fd()), flags[i]); err != nil {
+			return fmt.Errorf("joining namespace %s: %w", f.Name(), err)
+		}
+	}
+	if err := unix.Fchdir(int(root.Fd())); err != nil {
+		return err
+	}
+	if err := unix.Chroot("."); err != nil {
+		return fmt.Errorf("changing root to that of process %d: %w", pid, err)
+	}
+	return unix.Chdir("/")
+}
+
+// dropCapBound drops the capabilities not in keep, one bit per capability, from the bounding set of the calling
+// thread. It must run on a goroutine locked to its thread, as after enterContainer.
+func dropCapBound(keep uint64) error {
+	for c := 0; c < 64; c++ {
+		if keep&(1<<c) != 0 {
+			continue
+		}
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0); err != nil {
+			// Capabilities past the last one the kernel knows do not exist.
+			if errors.Is(err, unix.EINVAL) {
+				return nil
+			}
+			return fmt.Errorf("dropping capability %d: %w", c, err)
+		}
+	}
+	return nil
+}
+
+// sameFile returns true if the paths a and b are the same file, e.g. the same namespace.
+func sameFile(a, b string) (bool, error) {
+	stA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	stB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(stA, stB), nil
+}
+
+// openPty returns the master and slave ends of a new pseudo-terminal.
+func openPty() (master, slave *os.File, retErr error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			master.Close()
+		}
+	}()
+	if err := unix.IoctlSetPointerInt(int(master.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		return nil, nil, fmt.Errorf("unlocking pseudo-terminal: %w", err)
+	}
+	n, err := unix.IoctlGetInt(int(master.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		return nil, nil, fmt.Errorf("getting pseudo-terminal number: %w", err)
+	}
+	slave, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// resizePty gives the pseudo-terminal master the window size of the terminal term, if it is one.
+func resizePty(master, term *os.File) error {
+	ws, err := unix.IoctlGetWinsize(int(term.Fd()), unix.TIOCGWINSZ)
+	if err != nil {
+		return nil
+	}
+	return unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws)
+}
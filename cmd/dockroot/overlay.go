@@ -0,0 +1,21 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func mountOverlay(lower, upper, work, rootfs string) error {
+	return errors.ErrUnsupported
+}
+
+func unmountRootfs(rootfs string) error {
+	return errors.ErrUnsupported
+}
+
+func isMountPoint(dir string) (bool, error) {
+	return false, nil
+}
+
+func mountsUnder(dir string) ([]string, error) {
+	return nil, nil
+}
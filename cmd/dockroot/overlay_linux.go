@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// mountOverlay mounts lower, read-only, under upper as an overlayfs on rootfs.
+func mountOverlay(lower, upper, work, rootfs string) error {
+	dirs := []string{lower, upper, work}
+	for i, p := range dirs {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return err
+		}
+		// The mount options can not quote these.
+		if strings.ContainsAny(abs, ",:") {
+			return fmt.Errorf("overlayfs can not use %s, which contains ',' or ':'", abs)
+		}
+		dirs[i] = abs
+	}
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s", dirs[0], dirs[1], dirs[2])
+	if err := syscall.Mount("overlay", rootfs, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mounting overlayfs on %s: %w", rootfs, err)
+	}
+	return nil
+}
+
+func unmountRootfs(rootfs string) error {
+	if err := syscall.Unmount(rootfs, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmounting %s: %w", rootfs, err)
+	}
+	return nil
+}
+
+// mountsUnder returns the mount points on or under dir, as the mountinfo of the calling thread lists them. The main
+// thread, which /proc/self is, may have been left in a container by a goroutine which entered it.
+func mountsUnder(dir string) ([]string, error) {
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile("/proc/thread-self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	var mounts []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 5 {
+			continue
+		}
+		p := unescapeMountPath(fields[4])
+		if p == dir || strings.HasPrefix(p, dir+"/") {
+			mounts = append(mounts, p)
+		}
+	}
+	return mounts, nil
+}
+
+// unescapeMountPath undoes the octal escapes of spaces, tabs, newlines and backslashes in mountinfo paths.
+func unescapeMountPath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		if p[i] == '\\' && i+3 < len(p) {
+			if n, err := strconv.ParseUint(p[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(p[i])
+	}
+	return b.String()
+}
+
+// isMountPoint reports whether something is mounted on dir, which is then on another device than its parent.
+func isMountPoint(dir string) (bool, error) {
+	st, err := os.Stat(dir)
+	if err != nil {
+		return false, err
+	}
+	parent, err := os.Stat(filepath.Dir(dir))
+	if err != nil {
+		return false, err
+	}
+	return st.Sys().(*syscall.Stat_t).Dev != parent.Sys().(*syscall.Stat_t).Dev, nil
+}
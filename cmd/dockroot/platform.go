@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"github.com/sirupsen/logrus"
+)
+
+// platformInfo is the platform section of dockroot.json, overriding what DockRoot detects about this machine.
+type platformInfo struct {
+	Arch    string `json:"arch,omitempty"`    // Architecture, as in GOARCH and OCI image indexes
+	Variant string `json:"variant,omitempty"` // Architecture variant, e.g. "v7" for arm
+	Asset   string `json:"asset,omitempty"`   // Substituted for {arch} in dependency URLs, instead of the name derived from Arch and Variant
+}
+
+// platform is the OS, architecture and variant images and dependencies are chosen for.
+// Empty fields mean "whatever this machine is".
+type platform struct {
+	os      string
+	arch    string
+	variant string
+	asset   string
+}
+
+// overridePlatform returns the platform chosen by the --override-* flags.
+func (opts *globalOptions) overridePlatform() platform {
+	return platform{os: opts.overrideOS, arch: opts.overrideArch, variant: opts.overrideVariant}
+}
+
+// withConfig fills the fields of p not already chosen from conf.
+// A variant or asset in conf only applies if it is for the architecture p ends up with.
+func (p platform) withConfig(conf *platformInfo) platform {
+	if conf == nil {
+		return p
+	}
+	if p.arch == "" || p.arch == conf.Arch || conf.Arch == "" {
+		if p.arch == "" {
+			p.arch = conf.Arch
+		}
+		if p.variant == "" {
+			p.variant = conf.Variant
+		}
+		if p.asset == "" {
+			p.asset = conf.Asset
+		}
+	}
+	return p
+}
+
+// resolve fills the fields of p not already chosen from this machine.
+func (p platform) resolve() platform {
+	if p.os == "" {
+		p.os = runtime.GOOS
+	}
+	if p.arch == "" {
+		p.arch = runtime.GOARCH
+	}
+	if p.variant == "" && p.arch == "arm" {
+		if runtime.GOARCH == "arm" {
+			p.variant = detectArmVariant()
+		} else {
+			p.variant = "v7"
+		}
+	}
+	return p
+}
+
+// assetArch returns the name substituted for {arch} in dependency URLs, e.g. "armv7" or "riscv64".
+func (p platform) assetArch() string {
+	if p.asset != "" {
+		return p.asset
+	}
+	if p.arch == "arm" && p.variant != "" {
+		return "arm" + p.variant
+	}
+	return p.arch
+}
+
+// platform returns the platform dependencies are downloaded for: this machine, as dockroot.json describes it.
+// The --override-* flags choose images, not the binaries this machine runs.
+// info may be nil, in which case only this machine is considered.
+func (info *registryInfo) platform() platform {
+	if info == nil {
+		return platform{}.resolve()
+	}
+	return platform{}.withConfig(info.Platform).resolve()
+}
+
+// dependencyArch returns the asset name used for dependency downloads.
+func (info *registryInfo) dependencyArch() string {
+	return info.platform().assetArch()
+}
+
+// bundleArch returns the asset name ensuredeps --bundle downloads for by default: the platform chosen by the
+// --override-* flags, if any, for preparing a bundle for another machine.
+func (info *registryInfo) bundleArch() string {
+	return info.overrides.withConfig(info.Platform).resolve().assetArch()
+}
+
+// configuredPlatform returns the platform section of dockroot.json, or nil if there is no usable configuration.
+func configuredPlatform() *platformInfo {
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return nil
+	}
+	info, err := readRegistryInfo(binaryDir)
+	if err != nil {
+		logrus.Debugf("Not using a configured platform: %v", err)
+		return nil
+	}
+	return info.Platform
+}
+
+// imagePlatform returns the platform images are chosen for, leaving fields empty where containers/image should decide by itself.
+func (opts *globalOptions) imagePlatform() platform {
+	p := opts.overridePlatform().withConfig(configuredPlatform())
+	if p.arch == "" && p.variant == "" && runtime.GOARCH == "arm" {
+		p.variant = detectArmVariant()
+	}
+	return p
+}
+
+// parsePlatform parses an OS/ARCH[/VARIANT] platform, as given to sync --platform, e.g. linux/arm/v7.
+func parsePlatform(s string) (platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || slices.Contains(parts, "") {
+		return platform{}, fmt.Errorf("Invalid platform %q, expected OS/ARCH[/VARIANT]", s)
+	}
+	p := platform{os: parts[0], arch: parts[1]}
+	if len(parts) == 3 {
+		p.variant = parts[2]
+	}
+	return p, nil
+}
+
+// String returns p in the OS/ARCH[/VARIANT] form parsePlatform accepts.
+func (p platform) String() string {
+	s := p.os + "/" + p.arch
+	if p.variant != "" {
+		s += "/" + p.variant
+	}
+	return s
+}
+
+// detectArmVariant returns the ARM architecture version this machine runs 32-bit code as, e.g. "v7".
+func detectArmVariant() string {
+	if f, err := os.Open("/proc/cpuinfo"); err == nil {
+		defer f.Close()
+		if v := armVariantFromCPUInfo(f); v != "" {
+			return v
+		}
+	}
+	if b, err := os.ReadFile("/proc/self/auxv"); err == nil {
+		if hwcap, ok := parseAuxv(b, int(unsafe.Sizeof(uintptr(0))))[atHWCap]; ok {
+			return armVariantFromHWCap(hwcap)
+		}
+	}
+	logrus.Debugf("Unable to detect the ARM variant, assuming v7")
+	return "v7"
+}
+
+var cpuArchitectureRegexp = regexp.MustCompile(`^CPU architecture\s*:\s*(\d+)`)
+
+// armVariantFromCPUInfo returns the variant named by the "CPU architecture" line in r, in /proc/cpuinfo format, or "".
+// ARMv8 and later CPUs run 32-bit code as v7.
+func armVariantFromCPUInfo(r io.Reader) string {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		m := cpuArchitectureRegexp.FindStringSubmatch(strings.TrimSpace(scanner.Text()))
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		switch {
+		case n >= 8:
+			return "v7"
+		case n >= 5:
+			return "v" + m[1]
+		}
+		return ""
+	}
+	return ""
+}
+
+// ELF auxiliary vector entries and ARM hardware capability bits, from the Linux UAPI headers.
+const (
+	atNull  = 0
+	atHWCap = 16
+
+	hwcapARMNEON  = 1 << 12
+	hwcapARMVFPv3 = 1 << 13
+	hwcapARMTLS   = 1 << 15
+)
+
+// parseAuxv parses an ELF auxiliary vector as found in /proc/self/auxv, with words of wordSize bytes in native byte order.
+func parseAuxv(b []byte, wordSize int) map[uint64]uint64 {
+	word := func(b []byte) uint64 {
+		if wordSize == 4 {
+			return uint64(binary.NativeEndian.Uint32(b))
+		}
+		return binary.NativeEndian.Uint64(b)
+	}
+	auxv := map[uint64]uint64{}
+	for len(b) >= 2*wordSize {
+		tag, val := word(b), word(b[wordSize:])
+		if tag == atNull {
+			break
+		}
+		auxv[tag] = val
+		b = b[2*wordSize:]
+	}
+	return auxv
+}
+
+// armVariantFromHWCap returns the variant implied by the AT_HWCAP bits: VFPv3 and NEON first appeared in v7, and the TLS register in v6.
+func armVariantFromHWCap(hwcap uint64) string {
+	switch {
+	case hwcap&(hwcapARMNEON|hwcapARMVFPv3) != 0:
+		return "v7"
+	case hwcap&hwcapARMTLS != 0:
+		return "v6"
+	default:
+		return "v5"
+	}
+}
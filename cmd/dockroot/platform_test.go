@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/binary"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArmVariantFromCPUInfo(t *testing.T) {
+	for _, c := range []struct{ cpuinfo, expected string }{
+		{"processor\t: 0\nmodel name\t: ARMv7 Processor rev 5 (v7l)\nCPU architecture: 7\n", "v7"},
+		{"processor\t: 0\nmodel name\t: ARMv6-compatible processor rev 7 (v6l)\nCPU architecture: 7\n", "v7"},
+		{"Processor\t: ARM926EJ-S rev 5 (v5l)\nCPU architecture: 5TEJ\n", "v5"},
+		{"processor\t: 0\nCPU architecture: 6TEJ\n", "v6"},
+		{"processor\t: 0\nCPU architecture: 8\n", "v7"},
+		{"processor\t: 0\nvendor_id\t: GenuineIntel\n", ""},
+	} {
+		assert.Equal(t, c.expected, armVariantFromCPUInfo(strings.NewReader(c.cpuinfo)), c.cpuinfo)
+	}
+}
+
+func TestParseAuxv(t *testing.T) {
+	words := []uint64{6, 4096, atHWCap, hwcapARMTLS | 1, atNull, 0, 99, 99}
+	for _, wordSize := range []int{4, 8} {
+		b := make([]byte, 0, len(words)*wordSize)
+		for _, w := range words {
+			if wordSize == 4 {
+				b = binary.NativeEndian.AppendUint32(b, uint32(w))
+			} else {
+				b = binary.NativeEndian.AppendUint64(b, w)
+			}
+		}
+		auxv := parseAuxv(b, wordSize)
+		assert.Equal(t, map[uint64]uint64{6: 4096, atHWCap: hwcapARMTLS | 1}, auxv)
+	}
+}
+
+func TestArmVariantFromHWCap(t *testing.T) {
+	assert.Equal(t, "v7", armVariantFromHWCap(hwcapARMNEON|hwcapARMVFPv3|hwcapARMTLS))
+	assert.Equal(t, "v7", armVariantFromHWCap(hwcapARMVFPv3))
+	assert.Equal(t, "v6", armVariantFromHWCap(hwcapARMTLS))
+	assert.Equal(t, "v5", armVariantFromHWCap(0))
+}
+
+func TestPlatformAssetArch(t *testing.T) {
+	for _, c := range []struct {
+		p        platform
+		expected string
+	}{
+		{platform{arch: "arm", variant: "v7"}, "armv7"},
+		{platform{arch: "arm", variant: "v6"}, "armv6"},
+		{platform{arch: "arm64", variant: "v8"}, "arm64"},
+		{platform{arch: "riscv64"}, "riscv64"},
+		{platform{arch: "arm", variant: "v5", asset: "armel"}, "armel"},
+	} {
+		assert.Equal(t, c.expected, c.p.assetArch())
+	}
+	// A foreign arm without a variant defaults to v7.
+	if runtime.GOARCH != "arm" {
+		assert.Equal(t, "armv7", platform{arch: "arm"}.resolve().assetArch())
+	}
+}
+
+func TestRegistryInfoPlatform(t *testing.T) {
+	var info *registryInfo
+	assert.Equal(t, runtime.GOOS, info.platform().os)
+	assert.Equal(t, runtime.GOARCH, info.platform().arch)
+
+	info = &registryInfo{Platform: &platformInfo{Arch: "arm", Variant: "v6"}}
+	assert.Equal(t, "armv6", info.dependencyArch())
+	assert.Equal(t, "armv6", info.dependency(dependencyRuri).arch)
+
+	// The --override-* flags win over dockroot.json for bundles, but never choose what this machine runs.
+	info.overrides = platform{arch: "riscv64"}
+	assert.Equal(t, "riscv64", info.bundleArch())
+	assert.Equal(t, "armv6", info.dependencyArch())
+	assert.Equal(t, "armv6", info.dependency(dependencyRuri).arch)
+	info.overrides = platform{arch: "arm"}
+	assert.Equal(t, "armv6", info.bundleArch())
+	info.overrides = platform{arch: "arm", variant: "v7"}
+	assert.Equal(t, "armv7", info.bundleArch())
+	assert.Equal(t, "armv6", info.dependencyArch())
+
+	// An explicit asset name wins over the derived one.
+	info = &registryInfo{Platform: &platformInfo{Asset: "armhf"}}
+	assert.Equal(t, "armhf", info.dependencyArch())
+}
+
+func TestGlobalOptionsOverridePlatform(t *testing.T) {
+	opts, _ := fakeGlobalOptions(t, []string{"--override-arch", "arm", "--override-variant", "v5"})
+	assert.Equal(t, platform{arch: "arm", variant: "v5"}, opts.overridePlatform())
+	info := &registryInfo{overrides: opts.overridePlatform()}
+	assert.Equal(t, "armv5", info.bundleArch())
+	assert.Equal(t, platform{}.resolve().assetArch(), info.dependencyArch())
+}
+
+func TestParsePlatform(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected platform
+	}{
+		{"linux/amd64", platform{os: "linux", arch: "amd64"}},
+		{"linux/arm/v7", platform{os: "linux", arch: "arm", variant: "v7"}},
+	} {
+		p, err := parsePlatform(c.input)
+		if assert.NoError(t, err, c.input) {
+			assert.Equal(t, c.expected, p, c.input)
+			assert.Equal(t, c.input, p.String())
+		}
+	}
+	for _, input := range []string{"", "amd64", "linux/", "linux/arm/v7/extra", "/arm64"} {
+		_, err := parsePlatform(input)
+		assert.Error(t, err, input)
+	}
+}
@@ -24,6 +24,7 @@ type pullOptions struct {
 	destImage           *imageDestOptions
 	retryOpts           *retry.Options
 	digestFile          string // Write digest to this file
+	tag                 string // Name to keep an image imported from localTransports under
 }
 
 func pullCmd(global *globalOptions) *cobra.Command {
@@ -39,10 +40,19 @@ func pullCmd(global *globalOptions) *cobra.Command {
 		retryOpts:           retryOpts,
 	}
 	cmd := &cobra.Command{
-		Use:     "pull IMAGE:TAG NAME",
-		Short:   "pull an image from a registry",
-		RunE:    commandAction(opts.run),
-		Example: `DockRoot pull alpine:latest alpine001`,
+		Use:   "pull IMAGE[:TAG|@DIGEST] [NAME]",
+		Short: "pull an image from a registry into the image store, and create the container NAME from it if given",
+		Long: `Pull an image from a registry into the image store, and create the container NAME from it if given.
+
+Images can also be imported without a registry, from ` + strings.Join(localTransports, ":, ") + `: sources.
+They are kept under the name the source gives them, or the one given with --tag.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot pull alpine:latest
+DockRoot pull alpine:latest alpine001
+DockRoot pull docker-archive:/mnt/usb/alpine.tar alpine001
+DockRoot pull --tag myapp:1.0 oci-archive:/mnt/usb/myapp.tar
+DockRoot pull myreg.local:5000/app:1.2 app001
+DockRoot pull alpine@sha256:<digest> alpine002`,
 	}
 	flags := cmd.Flags()
 	flags.AddFlagSet(&sharedFlags)
@@ -51,42 +61,51 @@ func pullCmd(global *globalOptions) *cobra.Command {
 	flags.AddFlagSet(&destFlags)
 	flags.AddFlagSet(&retryFlags)
 	flags.StringVar(&opts.digestFile, "digestfile", "", "Write the digest of the pushed image to the specified file")
+	flags.StringVar(&opts.tag, "tag", "", "Keep an image imported from a local source under `IMAGE[:TAG]`")
 
 	return cmd
 }
 
 func (opts *pullOptions) run(args []string, stdout io.Writer) (retErr error) {
-	if len(args) != 2 {
-		return fmt.Errorf("Usage: %s pull IMAGE:TAG DESTINATION", os.Args[0])
+	if len(args) != 1 && len(args) != 2 {
+		return fmt.Errorf("Usage: %s pull IMAGE[:TAG|@DIGEST] [NAME]", os.Args[0])
 	}
-	arg0Arr := strings.Split(args[0], ":")
-	if len(arg0Arr) != 2 {
-		return fmt.Errorf("Invalid image format: %s", args[0])
+	srcRef, err := parseLocalSource(args[0])
+	if err != nil {
+		return err
 	}
-	imageTag := arg0Arr[1]
-	var imageURL string
-	if strings.HasPrefix(arg0Arr[0], "docker://") {
-		imageURL = arg0Arr[0]
+	var imageRef *imageReference
+	var candidates []imageSourceCandidate
+	if srcRef == nil {
+		if opts.tag != "" {
+			return fmt.Errorf("--tag only applies to images from %s: sources", strings.Join(localTransports, ":, "))
+		}
+		imageRef, err = parseImageReference(args[0])
 	} else {
-		i := strings.Index(arg0Arr[0], "/")
-		if i > 0 && strings.Contains(arg0Arr[0], ".") {
-			imageURL = "docker://" + arg0Arr[0]
-		} else {
-			imageURL = fmt.Sprintf("docker://registry.linkease.net:5443/%s", args[0])
+		sys, err2 := opts.srcImage.newSystemContext()
+		if err2 != nil {
+			return err2
 		}
+		imageRef, err = localSourceName(sys, srcRef, opts.tag)
+		candidates = []imageSourceCandidate{{name: args[0]}}
 	}
+	if err != nil {
+		return err
+	}
+	storeName := imageRef.storeName()
+	imageURL := imageRef.sourceName("")
 
 	binaryDir, err := getBinaryDir()
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err != nil {
 		err = writeDefaultRegistry(binaryDir)
 		if err != nil {
 			return err
 		}
-		info, err = readRegistryInfo(binaryDir)
+		info, err = opts.global.readRegistryInfo(binaryDir)
 		if err != nil {
 			return err
 		}
@@ -97,42 +116,40 @@ func (opts *pullOptions) run(args []string, stdout io.Writer) (retErr error) {
 	}
 
 	var client *http.Client
-	if strings.Contains(imageURL, "registry.linkease.net:5443") {
-		client = &http.Client{}
-		err = checkAndRunKspeeder(
-			filepath.Join(binaryDir, "kspeeder"),
-			filepath.Join(info.DataRoot, "cache"),
-			client,
-		)
+	mirrors := info.hubMirrors()
+	if srcRef == nil && imageRef.isDockerHub() && !imageRef.direct {
+		acc, err := info.accelerator(binaryDir)
 		if err != nil {
 			return err
 		}
+		if acc != nil {
+			if err := ensureAccelerator(acc); err != nil {
+				logrus.Warnf("Not using accelerator: %v", err)
+			} else {
+				mirrors = append([]*registryMirror{acc.mirror()}, mirrors...)
+			}
+		}
 	}
 
 	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
+	var destDir string
+	if len(args) == 2 {
 		if client == nil {
 			client = &http.Client{}
 		}
-		if err := downloadBinary(client,
-			RuriUrl,
-			ruriPath,
-			"ruri"); err != nil {
+		if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), client); err != nil {
 			return err
 		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
-	}
 
-	destDir := filepath.Join(info.DataRoot, CleanString(args[1]))
-	if _, err := os.Stat(destDir); os.IsNotExist(err) {
-		err = os.Mkdir(destDir, 0755)
-		if err != nil {
-			return err
+		destDir = filepath.Join(info.DataRoot, CleanString(args[1]))
+		if _, err := os.Stat(destDir); os.IsNotExist(err) {
+			err = os.Mkdir(destDir, 0755)
+			if err != nil {
+				return err
+			}
+		} else {
+			fmt.Printf("Destination directory %s already exists\n", destDir)
 		}
-	} else {
-		fmt.Printf("Destination directory %s already exists\n", destDir)
 	}
 
 	if !opts.global.debug {
@@ -153,23 +170,22 @@ func (opts *pullOptions) run(args []string, stdout io.Writer) (retErr error) {
 		}
 	}()
 
-	imageNames := []string{
-		imageURL,
-		fmt.Sprintf("oci:%s/images:%s", destDir, imageTag)}
-
-	srcRef, err := alltransports.ParseImageName(imageNames[0])
-	if err != nil {
-		return fmt.Errorf("Invalid source name %s: %v", imageNames[0], err)
+	storeDir := imageStoreDir(info.DataRoot)
+	if err := os.MkdirAll(storeDir, 0755); err != nil {
+		return err
 	}
-	destRef, err := alltransports.ParseImageName(imageNames[1])
+	// The store is shared by all pulls, which update its index.json.
+	unlock, err := lockImageStore(info.DataRoot, true)
 	if err != nil {
-		return fmt.Errorf("Invalid destination name %s: %v", imageNames[1], err)
+		return err
 	}
-
-	sourceCtx, err := opts.srcImage.newSystemContext()
+	defer unlock()
+	destName := fmt.Sprintf("oci:%s:%s", storeDir, storeName)
+	destRef, err := alltransports.ParseImageName(destName)
 	if err != nil {
-		return err
+		return fmt.Errorf("Invalid destination name %s: %v", destName, err)
 	}
+
 	destinationCtx, err := opts.destImage.newSystemContext()
 	if err != nil {
 		return err
@@ -180,43 +196,59 @@ func (opts *pullOptions) run(args []string, stdout io.Writer) (retErr error) {
 
 	opts.destImage.warnAboutIneffectiveOptions(destRef.Transport())
 
-	err = retry.IfNecessary(ctx, func() error {
-		manifestBytes, err := copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
-			ReportWriter:         stdout,
-			SourceCtx:            sourceCtx,
-			DestinationCtx:       destinationCtx,
-			MaxParallelDownloads: 2,
-		})
+	if srcRef == nil {
+		candidates = imageRef.sourceCandidates(mirrors)
+	}
+	err = tryImageSources(ctx, candidates, imageRef.String(), func(c imageSourceCandidate) error {
+		srcRef, err := alltransports.ParseImageName(c.name)
+		if err != nil {
+			return fmt.Errorf("Invalid source name %s: %v", c.name, err)
+		}
+		sourceCtx, err := opts.srcImage.newSystemContext()
 		if err != nil {
 			return err
 		}
-		if opts.digestFile != "" {
-			manifestDigest, err := manifest.Digest(manifestBytes)
+		c.updateSystemContext(sourceCtx)
+		return retry.IfNecessary(ctx, func() error {
+			manifestBytes, err := copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
+				ReportWriter:         stdout,
+				SourceCtx:            sourceCtx,
+				DestinationCtx:       destinationCtx,
+				MaxParallelDownloads: 2,
+			})
 			if err != nil {
 				return err
 			}
-			if err = os.WriteFile(opts.digestFile, []byte(manifestDigest.String()), 0644); err != nil {
-				return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+			if opts.digestFile != "" {
+				manifestDigest, err := manifest.Digest(manifestBytes)
+				if err != nil {
+					return err
+				}
+				if err = os.WriteFile(opts.digestFile, []byte(manifestDigest.String()), 0644); err != nil {
+					return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+				}
 			}
-		}
-		return nil
-	}, opts.retryOpts)
+			return nil
+		}, opts.retryOpts)
+	})
 	if err != nil {
 		return err
 	}
-	err = unpack(fmt.Sprintf("%s/images", destDir), imageTag, destDir)
-	os.RemoveAll(filepath.Join(destDir, "images"))
-	destAbsDir, err2 := filepath.Abs(destDir)
-	if err == nil && err2 == nil {
-		imageName := imageURL
-		ss := strings.Split(imageURL, "/")
-		if len(ss) > 2 {
-			imageName = strings.Join(ss[len(ss)-2:], "/")
-		}
-		err = writeRuri(ruriPath, destAbsDir, imageName, "", nil, nil)
+	manifestDigest, err := tagImageDigest(storeDir, storeName)
+	if err != nil {
+		return err
 	}
-
-	return err
+	img := &containerImage{Name: storeName, Digest: manifestDigest.String()}
+	if destDir == "" {
+		fmt.Fprintf(stdout, "%s: %s\n", storeName, img.Digest)
+		return nil
+	}
+	imageName := imageURL
+	ss := strings.Split(imageURL, "/")
+	if len(ss) > 2 {
+		imageName = strings.Join(ss[len(ss)-2:], "/")
+	}
+	return createContainer(ruriPath, info, img, destDir, imageName)
 }
 
 func CleanString(s string) string {
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/pkg/cli"
+	"github.com/containers/image/v5/pkg/cli/sigstore"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/signature/signer"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/spf13/cobra"
+)
+
+type pushOptions struct {
+	global                   *globalOptions
+	deprecatedTLSVerify      *deprecatedTLSVerifyOption
+	destImage                *imageDestOptions
+	retryOpts                *retry.Options
+	digestFile               string // Write digest to this file
+	signByFingerprint        string // Sign the image using a GPG key with the specified fingerprint
+	signBySigstoreParamFile  string // Sign the image using a sigstore signature per configuration in a param file
+	signBySigstorePrivateKey string // Sign the image using a sigstore private key
+	signPassphraseFile       string // Path pointing to a passphrase file when signing
+	signIdentity             string // Identity of the signed image, must be a fully specified docker reference
+}
+
+func pushCmd(global *globalOptions) *cobra.Command {
+	sharedFlags, sharedOpts := sharedImageFlags()
+	deprecatedTLSVerifyFlags, deprecatedTLSVerifyOpt := deprecatedTLSVerifyFlags()
+	destFlags, destOpts := imageDestFlags(global, sharedOpts, deprecatedTLSVerifyOpt, "", "")
+	retryFlags, retryOpts := retryFlags()
+	opts := pushOptions{global: global,
+		deprecatedTLSVerify: deprecatedTLSVerifyOpt,
+		destImage:           destOpts,
+		retryOpts:           retryOpts,
+	}
+	cmd := &cobra.Command{
+		Use:   "push IMAGE[:TAG|@DIGEST] DESTINATION",
+		Short: "push an image from the image store to a registry",
+		Long: `Push an image from the image store to DESTINATION.
+
+DESTINATION is a docker:// reference, or any other transport skopeo copy accepts.
+A reference without a transport is pushed to a registry.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot push alpine:latest docker://myreg.local:5000/alpine:latest
+DockRoot push --creds user:pass myapp:1.0 registry.example.com/team/myapp:1.0
+DockRoot push --tls-verify=false --digestfile app.digest app:1.0 myreg.local:5000/app:1.0`,
+	}
+	flags := cmd.Flags()
+	flags.AddFlagSet(&sharedFlags)
+	flags.AddFlagSet(&deprecatedTLSVerifyFlags)
+	flags.AddFlagSet(&destFlags)
+	flags.AddFlagSet(&retryFlags)
+	flags.StringVar(&opts.digestFile, "digestfile", "", "Write the digest of the pushed image to the specified file")
+	flags.StringVar(&opts.signByFingerprint, "sign-by", "", "Sign the image using a GPG key with the specified `FINGERPRINT`")
+	flags.StringVar(&opts.signBySigstoreParamFile, "sign-by-sigstore", "", "Sign the image using a sigstore parameter file at `PATH`")
+	flags.StringVar(&opts.signBySigstorePrivateKey, "sign-by-sigstore-private-key", "", "Sign the image using a sigstore private key at `PATH`")
+	flags.StringVar(&opts.signPassphraseFile, "sign-passphrase-file", "", "Read a passphrase for signing an image from `PATH`")
+	flags.StringVar(&opts.signIdentity, "sign-identity", "", "Identity of signed image, must be a fully specified docker reference. Defaults to the target docker reference.")
+	return cmd
+}
+
+func (opts *pushOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: %s push IMAGE[:TAG|@DIGEST] DESTINATION", os.Args[0])
+	}
+	destRef, err := parsePushDestination(args[1])
+	if err != nil {
+		return err
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+
+	copyOpts, cleanup, err := opts.signOptions(stdout)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	copyOpts.ReportWriter = stdout
+	copyOpts.DestinationCtx, err = opts.destImage.newSystemContext()
+	if err != nil {
+		return err
+	}
+	opts.destImage.warnAboutIneffectiveOptions(destRef.Transport())
+
+	if opts.global.policyPath == "" {
+		opts.global.insecurePolicy = true
+	}
+	policyContext, err := opts.global.getPolicyContext()
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %v", err)
+	}
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		}
+	}()
+
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	storeDir := imageStoreDir(info.DataRoot)
+	img, err := resolveStoreImage(storeDir, args[0])
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+	manifestDigest, err := pushImage(ctx, policyContext, storeDir, img, destRef, copyOpts, opts.retryOpts)
+	if err != nil {
+		return err
+	}
+	if opts.digestFile != "" {
+		if err = os.WriteFile(opts.digestFile, []byte(manifestDigest.String()), 0644); err != nil {
+			return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+		}
+	}
+	fmt.Fprintf(stdout, "Pushed %s to %s: %s\n", img.Name, transports.ImageName(destRef), manifestDigest)
+	return nil
+}
+
+// signOptions returns copy.Options with the signing requested by opts, and a cleanup function closing its signers.
+func (opts *pushOptions) signOptions(stdout io.Writer) (*copy.Options, func(), error) {
+	var passphrase string
+	if opts.signPassphraseFile != "" {
+		if opts.signByFingerprint != "" && opts.signBySigstorePrivateKey != "" {
+			return nil, nil, fmt.Errorf("Only one of --sign-by and sign-by-sigstore-private-key can be used with sign-passphrase-file")
+		}
+		p, err := cli.ReadPassphraseFile(opts.signPassphraseFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		passphrase = p
+	} else if opts.signBySigstorePrivateKey != "" {
+		p, err := promptForPassphrase(opts.signBySigstorePrivateKey, os.Stdin, os.Stdout)
+		if err != nil {
+			return nil, nil, err
+		}
+		passphrase = p
+	} // opts.signByFingerprint triggers a GPG-agent passphrase prompt, possibly using a more secure channel, so we usually shouldn’t prompt ourselves if no passphrase was explicitly provided.
+
+	var signers []*signer.Signer
+	cleanup := func() {
+		for _, s := range signers {
+			s.Close()
+		}
+	}
+	if opts.signBySigstoreParamFile != "" {
+		s, err := sigstore.NewSignerFromParameterFile(opts.signBySigstoreParamFile, &sigstore.Options{
+			PrivateKeyPassphrasePrompt: func(keyFile string) (string, error) {
+				return promptForPassphrase(keyFile, os.Stdin, os.Stdout)
+			},
+			Stdin:  os.Stdin,
+			Stdout: stdout,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("Error using --sign-by-sigstore: %w", err)
+		}
+		signers = append(signers, s)
+	}
+
+	var signIdentity reference.Named
+	if opts.signIdentity != "" {
+		var err error
+		signIdentity, err = reference.ParseNamed(opts.signIdentity)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("Could not parse --sign-identity: %v", err)
+		}
+	}
+
+	copyOpts := &copy.Options{
+		Signers:        signers,
+		SignBy:         opts.signByFingerprint,
+		SignPassphrase: passphrase,
+		SignIdentity:   signIdentity,
+	}
+	if opts.signBySigstorePrivateKey != "" {
+		copyOpts.SignBySigstorePrivateKeyFile = opts.signBySigstorePrivateKey
+		copyOpts.SignSigstorePrivateKeyPassphrase = []byte(passphrase)
+	}
+	return copyOpts, cleanup, nil
+}
+
+// parsePushDestination parses dest as a transport:reference, or as a registry reference if it names no transport.
+func parsePushDestination(dest string) (types.ImageReference, error) {
+	if ref, err := alltransports.ParseImageName(dest); err == nil {
+		return ref, nil
+	}
+	ref, err := alltransports.ParseImageName("docker://" + dest)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid destination name %s: %v", dest, err)
+	}
+	return ref, nil
+}
+
+// pushImage copies img from storeDir to destRef with options, retrying per retryOpts, and returns the digest of the
+// manifest written to destRef.
+func pushImage(ctx context.Context, policyContext *signature.PolicyContext, storeDir string, img *containerImage, destRef types.ImageReference, options *copy.Options, retryOpts *retry.Options) (digest.Digest, error) {
+	srcRef, err := layout.NewReference(storeDir, img.Digest)
+	if err != nil {
+		return "", err
+	}
+	var manifestBytes []byte
+	if err := retry.IfNecessary(ctx, func() error {
+		manifestBytes, err = copy.Image(ctx, policyContext, destRef, srcRef, options)
+		return err
+	}, retryOpts); err != nil {
+		return "", err
+	}
+	return manifest.Digest(manifestBytes)
+}
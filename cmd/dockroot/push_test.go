@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRegistry is an in-memory stand-in for the parts of the registry v2 API copy.Image uses to push and pull,
+// and list-tags uses.
+type testRegistry struct {
+	mu          sync.Mutex
+	blobs       map[digest.Digest][]byte
+	uploads     map[string][]byte
+	manifests   map[string][]byte // By repository:reference
+	types       map[string]string
+	blobFetches map[digest.Digest]int // GET requests by blob
+}
+
+func newTestRegistry() *testRegistry {
+	return &testRegistry{
+		blobs:     map[digest.Digest][]byte{},
+		uploads:   map[string][]byte{},
+		manifests: map[string][]byte{},
+		types:     map[string]string{},
+
+		blobFetches: map[digest.Digest]int{},
+	}
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "" {
+		return
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/uploads/"); i != -1 {
+		repo, id := path[:i], path[i+len("/blobs/uploads/"):]
+		switch req.Method {
+		case http.MethodPost:
+			id = fmt.Sprintf("upload-%d", len(r.uploads))
+			r.uploads[id] = nil
+		case http.MethodPatch:
+			r.uploads[id] = append(r.uploads[id], body...)
+		case http.MethodPut:
+			data := append(r.uploads[id], body...)
+			d := digest.FromBytes(data)
+			if d.String() != req.URL.Query().Get("digest") {
+				http.Error(w, "digest mismatch", http.StatusBadRequest)
+				return
+			}
+			r.blobs[d] = data
+			delete(r.uploads, id)
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.Header().Set("Location", "/v2/"+repo+"/blobs/"+d.String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Location", "/v2/"+repo+"/blobs/uploads/"+id)
+		w.Header().Set("Range", fmt.Sprintf("0-%d", max(len(r.uploads[id])-1, 0)))
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if i := strings.LastIndex(path, "/blobs/"); i != -1 {
+		d := digest.Digest(path[i+len("/blobs/"):])
+		data, ok := r.blobs[d]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method == http.MethodGet {
+			r.blobFetches[d]++
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+		w.Write(data)
+		return
+	}
+	if repo, ok := strings.CutSuffix(path, "/tags/list"); ok {
+		tags := []string{}
+		for k := range r.manifests {
+			if tag, ok := strings.CutPrefix(k, repo+":"); ok && !strings.Contains(tag, ":") {
+				tags = append(tags, tag)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"name": repo, "tags": tags})
+		return
+	}
+	if i := strings.LastIndex(path, "/manifests/"); i != -1 {
+		key := path[:i] + ":" + path[i+len("/manifests/"):]
+		if req.Method == http.MethodPut {
+			d := digest.FromBytes(body)
+			for _, k := range []string{key, path[:i] + ":" + d.String()} {
+				r.manifests[k] = body
+				r.types[k] = req.Header.Get("Content-Type")
+			}
+			w.Header().Set("Docker-Content-Digest", d.String())
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		data, ok := r.manifests[key]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", r.types[key])
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(data).String())
+		w.Write(data)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func TestParsePushDestination(t *testing.T) {
+	for _, c := range []struct{ input, expected string }{
+		{"docker://myreg.local:5000/app:1.0", "docker://myreg.local:5000/app:1.0"},
+		{"myreg.local:5000/app:1.0", "docker://myreg.local:5000/app:1.0"},
+		{"alpine:latest", "docker://alpine:latest"},
+		{"dir:/tmp/app", "dir:/tmp/app"},
+	} {
+		ref, err := parsePushDestination(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, transports.ImageName(ref), c.input)
+	}
+	_, err := parsePushDestination("docker://UPPER/app")
+	assert.Error(t, err)
+}
+
+func TestPushImage(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:1.0"
+	addTestImage(t, storeDir, refName, map[string]string{"usr/bin/app": "v1"}, nil)
+	// pull keeps a digest reference next to the name.
+	_, err := tagImageDigest(storeDir, refName)
+	require.NoError(t, err)
+	img, err := resolveStoreImage(storeDir, "app:1.0")
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	destRef, err := parsePushDestination(host + "/team/app:1.0")
+	require.NoError(t, err)
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	options := &copy.Options{
+		ReportWriter: io.Discard,
+		DestinationCtx: &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			SystemRegistriesConfPath:    os.DevNull,
+			RegistriesDirPath:           t.TempDir(),
+		},
+	}
+	manifestDigest, err := pushImage(context.Background(), policyContext, storeDir, img, destRef, options, &retry.Options{})
+	require.NoError(t, err)
+	pushed, ok := registry.manifests["team/app:1.0"]
+	require.True(t, ok)
+	assert.Equal(t, digest.FromBytes(pushed), manifestDigest)
+	assert.Contains(t, registry.manifests, "team/app:"+manifestDigest.String())
+
+	// Pushing again only uploads the manifest.
+	uploaded := len(registry.blobs)
+	_, err = pushImage(context.Background(), policyContext, storeDir, img, destRef, options, &retry.Options{})
+	require.NoError(t, err)
+	assert.Len(t, registry.blobs, uploaded)
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	"github.com/spf13/cobra"
+)
+
+// keptRootfsPaths are the files DockRoot writes into a rootfs, which reset carries over along with the image's volumes.
+var keptRootfsPaths = []string{"etc/resolv.conf", "etc/hosts", "etc/localtime", "root/entry.sh"}
+
+type resetOptions struct {
+	global *globalOptions
+}
+
+func resetCmd(global *globalOptions) *cobra.Command {
+	opts := resetOptions{global: global}
+	cmd := &cobra.Command{
+		Use:     "reset NAME",
+		Short:   "re-create the rootfs of a container from its image, keeping volumes and ruri.conf",
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot reset alpine001`,
+	}
+	return cmd
+}
+
+func (opts *resetOptions) run(args []string, stdout io.Writer) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Usage: %s reset NAME", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	name := CleanString(args[0])
+	destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, name))
+	if err != nil {
+		return err
+	}
+	if !isDirValid(destAbsDir) {
+		return fmt.Errorf("Container %s does not exist", name)
+	}
+	img, err := readContainerImage(destAbsDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Container %s was pulled before images were kept, pull it again", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	confPath := filepath.Join(destAbsDir, "ruri.conf")
+	if _, err := os.Stat(confPath); err == nil {
+		ruriPath := filepath.Join(binaryDir, "ruri")
+		if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+			return err
+		}
+		// stop leaves ruri's mounts and the -v volumes mounted in the rootfs, which is about to be removed.
+		if err := releaseContainer(ruriPath, confPath, name); err != nil {
+			return err
+		}
+	}
+
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if img.Storage == storageOverlay {
+		err = resetOverlayContainer(info.DataRoot, img, destAbsDir)
+	} else {
+		err = resetContainer(imageStoreDir(info.DataRoot), img, destAbsDir)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Container %s reset to %s\n", name, img.Name)
+	return nil
+}
+
+// resetContainer replaces the rootfs of the container in containerDir with a fresh copy of img from storeDir.
+// The image's volumes and keptRootfsPaths move over from the old rootfs, and ruri.conf is left alone.
+func resetContainer(storeDir string, img *containerImage, containerDir string) error {
+	oldRootfs := filepath.Join(containerDir, "rootfs")
+	if err := checkNoMounts(oldRootfs); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(containerDir), "."+filepath.Base(containerDir)+".reset-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	bundle := filepath.Join(tmpDir, "bundle")
+	if err := unpack(storeDir, img.Digest, bundle); err != nil {
+		return err
+	}
+	imgConfig, err := getImageConfig(filepath.Join(bundle, imageConfigFile))
+	if err != nil {
+		return err
+	}
+
+	kept := keptRootfsPaths
+	for vol := range imgConfig.Config.Volumes {
+		kept = append(kept, vol)
+	}
+	newRootfs := filepath.Join(bundle, "rootfs")
+	for _, p := range kept {
+		if err := moveRootfsPath(oldRootfs, newRootfs, p); err != nil {
+			return fmt.Errorf("keeping %s: %w", p, err)
+		}
+	}
+
+	entries, err := os.ReadDir(bundle)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		target := filepath.Join(containerDir, e.Name())
+		if err := os.Rename(target, filepath.Join(tmpDir, "old-"+e.Name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := os.Rename(filepath.Join(bundle, e.Name()), target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resetOverlayContainer empties the overlayfs upper dir of the container in containerDir, which then shows the
+// image as unpacked in its lower bundle again. The image's volumes and keptRootfsPaths stay.
+func resetOverlayContainer(dataRoot string, img *containerImage, containerDir string) error {
+	imgConfig, err := getImageConfig(filepath.Join(containerDir, imageConfigFile))
+	if err != nil {
+		return err
+	}
+	if err := unmountContainerRootfs(containerDir); err != nil {
+		return err
+	}
+	if err := checkNoMounts(filepath.Join(containerDir, "rootfs")); err != nil {
+		return err
+	}
+	_, upper, work := overlayDirs(dataRoot, img, containerDir)
+	tmpDir, err := os.MkdirTemp(containerDir, ".reset-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	newUpper := filepath.Join(tmpDir, "upper")
+	if err := os.Mkdir(newUpper, 0755); err != nil {
+		return err
+	}
+	kept := keptRootfsPaths
+	for vol := range imgConfig.Config.Volumes {
+		kept = append(kept, vol)
+	}
+	// Whiteouts and opaque directories move along, so the kept paths look as they did.
+	for _, p := range kept {
+		if err := moveRootfsPath(upper, newUpper, p); err != nil {
+			return fmt.Errorf("keeping %s: %w", p, err)
+		}
+	}
+	if err := os.Rename(upper, filepath.Join(tmpDir, "old-upper")); err != nil {
+		return err
+	}
+	if err := os.Rename(newUpper, upper); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(work); err != nil {
+		return err
+	}
+	if err := os.Mkdir(work, 0755); err != nil {
+		return err
+	}
+	return mountContainerRootfs(dataRoot, containerDir)
+}
+
+// checkNoMounts fails if anything is mounted on or under rootfs, where removing the old files would reach into
+// whatever is mounted, such as the host directories of volumes.
+func checkNoMounts(rootfs string) error {
+	mounts, err := mountsUnder(rootfs)
+	if err != nil {
+		return err
+	}
+	if len(mounts) > 0 {
+		return fmt.Errorf("%s is still mounted, unmount it first", strings.Join(mounts, ", "))
+	}
+	return nil
+}
+
+// moveRootfsPath moves p, if it exists in oldRootfs, to the same place in newRootfs.
+// Symlinks in either rootfs are resolved inside it, so that they can not point the move at the host.
+func moveRootfsPath(oldRootfs, newRootfs, p string) error {
+	p = strings.TrimPrefix(filepath.Clean("/"+p), "/")
+	if p == "" {
+		return nil
+	}
+	src, err := securejoin.SecureJoin(oldRootfs, filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+	src = filepath.Join(src, filepath.Base(p))
+	if _, err := os.Lstat(src); errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	dst, err := securejoin.SecureJoin(newRootfs, filepath.Dir(p))
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	dst = filepath.Join(dst, filepath.Base(p))
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Rename(src, dst)
+}
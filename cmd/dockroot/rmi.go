@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+type rmiOptions struct {
+	global     *globalOptions
+	buildCache bool
+}
+
+func rmiCmd(global *globalOptions) *cobra.Command {
+	opts := rmiOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "rmi IMAGE[:TAG|@DIGEST]...",
+		Short: "remove images from the image store",
+		Long: `Remove image names from the image store.
+
+An image is only deleted once it has no names left and no container was created from it,
+since reset re-creates containers from their image. Removing a manifest digest removes
+all the names of that image, and fails while containers use it.
+
+--build-cache removes the steps build cached instead.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot rmi alpine:latest
+DockRoot rmi sha256:<digest>
+DockRoot rmi --build-cache`,
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&opts.buildCache, "build-cache", false, "Remove the steps cached by build")
+	return cmd
+}
+
+func (opts *rmiOptions) run(args []string, stdout io.Writer) error {
+	if len(args) == 0 && !opts.buildCache {
+		return fmt.Errorf("Usage: %s rmi IMAGE[:TAG|@DIGEST]...", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	unlock, err := lockImageStore(info.DataRoot, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if opts.buildCache {
+		removed, err := pruneBuildCache(info.DataRoot)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "Removed %d build cache steps\n", removed)
+	}
+	for _, name := range args {
+		untagged, deleted, err := removeStoreImage(info.DataRoot, name)
+		if err != nil {
+			return err
+		}
+		for _, n := range untagged {
+			fmt.Fprintf(stdout, "Untagged: %s\n", n)
+		}
+		if deleted != "" {
+			fmt.Fprintf(stdout, "Deleted: %s\n", deleted)
+		}
+	}
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
@@ -87,6 +88,39 @@ func RenderRuriInfo(info *RuriInfo, w io.Writer) error {
 	return templ.Execute(w, info)
 }
 
+// readRuriConf returns the settings in the ruri.conf at path, as written by RenderRuriInfo: a single value for
+// key="value" lines, and the elements of key=["a","b"] ones.
+func readRuriConf(path string) (map[string][]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	conf := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if list, ok := strings.CutPrefix(value, "["); ok {
+			list = strings.TrimSuffix(list, "]")
+			if list == "" {
+				conf[key] = nil
+				continue
+			}
+			conf[key] = strings.Split(strings.TrimSuffix(strings.TrimPrefix(list, `"`), `"`), `","`)
+		} else {
+			conf[key] = []string{strings.TrimSuffix(strings.TrimPrefix(value, `"`), `"`)}
+		}
+	}
+	return conf, scanner.Err()
+}
+
 func RuriPids(ruriPath, ruriConf string) ([]string, error) {
 	cmd := exec.Command(ruriPath, "-P", ruriConf)
 	b, err := cmd.Output()
@@ -147,3 +181,25 @@ func RunRuri(ruriPath string, args []string, stdout io.Writer) error {
 
 	return cmd.Wait()
 }
+
+// releaseContainer checks that the container with ruriConf, name, is not running, nor waiting for its supervisor
+// to restart it, and has ruri unmount what it mounted in the rootfs, such as /proc, /sys and /dev, so that only
+// the files of the container are left there.
+func releaseContainer(ruriPath, ruriConf, name string) error {
+	// ruri.conf is in the container directory.
+	state, err := readContainerState(filepath.Dir(ruriConf))
+	if err != nil {
+		return err
+	}
+	if state.supervised() {
+		return fmt.Errorf("Container %s is running with a restart policy, stop it first", name)
+	}
+	pids, err := RuriPids(ruriPath, ruriConf)
+	if err != nil {
+		return err
+	}
+	if len(pids) > 0 {
+		return fmt.Errorf("Container %s is running, stop it first", name)
+	}
+	return RunRuri(ruriPath, []string{"-U", ruriConf}, io.Discard)
+}
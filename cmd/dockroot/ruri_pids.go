@@ -7,25 +7,31 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/docker/go-units"
 	"github.com/spf13/cobra"
 )
 
 type ruriPidsOptions struct {
 	global *globalOptions
 	detail bool
+	quiet  bool
 }
 
 func ruriPidsCmd(global *globalOptions) *cobra.Command {
 	opts := ruriPidsOptions{global: global}
 	cmd := &cobra.Command{
-		Use:     "ps NAME",
-		Short:   "get pids of running rootfs",
-		RunE:    commandAction(opts.run),
-		Example: `DockRoot ps alpine001`,
+		Use:   "ps [NAME]",
+		Short: "list containers, or get pids of running rootfs",
+		RunE:  commandAction(opts.run),
+		Example: `DockRoot ps
+DockRoot ps alpine001`,
 	}
 	flags := cmd.Flags()
 	flags.BoolVar(&opts.detail, "detail", false, "details of pids")
+	flags.BoolVarP(&opts.quiet, "quiet", "q", false, "Only show container names")
 	return cmd
 }
 
@@ -34,13 +40,13 @@ func (opts *ruriPidsOptions) run(args []string, stdout io.Writer) (retErr error)
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err != nil {
 		return err
 	}
 
 	if len(args) == 0 {
-		return listDockers(binaryDir, info, stdout)
+		return listDockers(binaryDir, info, opts.quiet, stdout)
 	}
 
 	hostname := CleanString(args[0])
@@ -51,17 +57,8 @@ func (opts *ruriPidsOptions) run(args []string, stdout io.Writer) (retErr error)
 	}
 
 	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
-		client := &http.Client{}
-		if err := downloadBinary(client,
-			"https://fw0.koolcenter.com/binary/DockRoot/ruri",
-			ruriPath,
-			"ruri"); err != nil {
-			return err
-		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
 	}
 	confPath := filepath.Join(destAbsDir, "ruri.conf")
 	if _, err := os.Stat(confPath); err != nil {
@@ -78,20 +75,84 @@ func (opts *ruriPidsOptions) run(args []string, stdout io.Writer) (retErr error)
 	return nil
 }
 
-func listDockers(binaryDir string, info *registryInfo, stdout io.Writer) (retErr error) {
+func listDockers(binaryDir string, info *registryInfo, quiet bool, stdout io.Writer) (retErr error) {
 	paths, err := os.ReadDir(info.DataRoot)
 	if err != nil {
 		return err
 	}
+	var rows []containerRow
 	for _, p := range paths {
-		if !p.IsDir() {
+		dir := filepath.Join(info.DataRoot, p.Name())
+		if !p.IsDir() || !isDirValid(dir) {
 			continue
 		}
-		if isDirValid(filepath.Join(info.DataRoot, p.Name())) {
+		if quiet {
 			fmt.Fprintln(stdout, p.Name())
+			continue
 		}
+		state, err := readContainerState(dir)
+		if err != nil {
+			return err
+		}
+		row := containerRow{name: p.Name(), state: state.current(), restarting: state.supervised()}
+		// Containers from before state files were kept still know their image.
+		if row.state.Image == "" {
+			if img, err := readContainerImage(dir); err == nil {
+				row.state.Image = img.Name
+			}
+		}
+		rows = append(rows, row)
 	}
-	return nil
+	if quiet {
+		return nil
+	}
+	return writeContainerTable(stdout, rows, time.Now())
+}
+
+// containerRow is a container listed by ps.
+type containerRow struct {
+	name       string
+	state      *containerState
+	restarting bool // A supervisor is going to restart the container
+}
+
+// writeContainerTable lists containers like docker ps -a does.
+func writeContainerTable(w io.Writer, rows []containerRow, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tIMAGE\tSTATUS\tCREATED")
+	for _, r := range rows {
+		image := "<none>"
+		if ref, err := parseImageReference(r.state.Image); err == nil {
+			image = ref.String()
+		}
+		created := "N/A"
+		if !r.state.Created.IsZero() {
+			created = units.HumanDuration(now.Sub(r.state.Created)) + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.name, image, containerStatusText(r.state, r.restarting, now), created)
+	}
+	return tw.Flush()
+}
+
+// containerStatusText describes the status of a container like docker ps does.
+func containerStatusText(s *containerState, restarting bool, now time.Time) string {
+	switch s.Status {
+	case containerRunning:
+		return "Up " + units.HumanDuration(now.Sub(s.StartedAt))
+	case containerExited:
+		status := "Exited"
+		if restarting {
+			status = "Restarting"
+		}
+		text := fmt.Sprintf("%s (%d)", status, s.ExitCode)
+		if !s.FinishedAt.IsZero() {
+			text += " " + units.HumanDuration(now.Sub(s.FinishedAt)) + " ago"
+		}
+		return text
+	case containerDead:
+		return "Dead"
+	}
+	return "Created"
 }
 
 func isDirValid(dir string) bool {
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
@@ -36,7 +37,7 @@ func (opts *ruriRmOptions) run(args []string, stdout io.Writer) (retErr error) {
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err != nil {
 		return err
 	}
@@ -48,17 +49,8 @@ func (opts *ruriRmOptions) run(args []string, stdout io.Writer) (retErr error) {
 	}
 
 	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
-		client := &http.Client{}
-		if err := downloadBinary(client,
-			"https://fw0.koolcenter.com/binary/DockRoot/ruri",
-			ruriPath,
-			"ruri"); err != nil {
-			return err
-		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
 	}
 	confPath := filepath.Join(destAbsDir, "ruri.conf")
 	if _, err := os.Stat(confPath); err != nil {
@@ -68,12 +60,38 @@ func (opts *ruriRmOptions) run(args []string, stdout io.Writer) (retErr error) {
 	if err != nil {
 		return err
 	}
+	if len(pids) > 0 && !opts.force {
+		return fmt.Errorf("ruri is running, use -f to force stop")
+	}
+	// A supervisor waiting to restart the container must not start it again.
+	if err := stopSupervisor(destAbsDir); err != nil {
+		return err
+	}
 	if len(pids) > 0 {
-		if opts.force {
-			KillProcess(pids)
-		} else {
-			return fmt.Errorf("ruri is running, use -f to force stop")
+		KillProcess(pids)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), containerStopTimeout)
+	defer cancel()
+	if _, err := waitContainer(ctx, destAbsDir); err != nil {
+		return fmt.Errorf("Container %s did not stop: %w", args[0], err)
+	}
+
+	err = RunRuri(ruriPath, []string{"-U", confPath}, stdout)
+	if err == nil {
+		err = unmountContainerRootfs(destAbsDir)
+	}
+	// A container rm failed to tear down is dead, until rm succeeds.
+	if stateErr := updateContainerState(destAbsDir, func(s *containerState) error {
+		s.settle()
+		switch {
+		case err != nil && s.Status != containerDead:
+			return s.setStatus(containerDead)
+		case err == nil && s.Status == containerDead:
+			return s.setStatus(containerExited)
 		}
+		return nil
+	}); err == nil {
+		err = stateErr
 	}
-	return RunRuri(ruriPath, []string{"-U", confPath}, stdout)
+	return err
 }
@@ -6,45 +6,65 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/moby/sys/user"
 	rspec "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 type ruriRunOptions struct {
-	global   *globalOptions
-	renew    bool
-	hostname string
-	workDir  string
-	network  string
-	restart  string
-	envVars  []string
-	volumes  []string
-	publish  []string
-	detach   bool
+	global     *globalOptions
+	renew      bool
+	hostname   string
+	workDir    string
+	user       string
+	network    string
+	entrypoint string
+	restart    string
+	envVars    []string
+	volumes    []string
+	publish    []string
+	logOpts    []string
+	detach     bool
+	etc        etcConfig
 }
 
 func ruriRunCmd(global *globalOptions) *cobra.Command {
 	opts := ruriRunOptions{global: global}
 	cmd := &cobra.Command{
-		Use:     "run NAME [COMMAND [ARGS]]",
-		Short:   "run image from a rootfs",
-		RunE:    commandAction(opts.run),
-		Example: `DockRoot run alpine001 [COMMAND [ARGS]]`,
+		Use:   "run NAME [COMMAND [ARGS]]",
+		Short: "run image from a rootfs",
+		RunE:  commandAction(opts.run),
+		Example: `DockRoot run alpine001 [COMMAND [ARGS]]
+DockRoot run --entrypoint /bin/sh alpine001 -c 'echo hello'`,
 	}
 	flags := cmd.Flags()
+	// As with docker run, everything after NAME is the command, even if it looks like a flag.
+	flags.SetInterspersed(false)
 	flags.BoolVar(&opts.renew, "renew", false, "Renew config")
 	flags.BoolVarP(&opts.detach, "detach", "d", false, "Run container in detached mode")
 	flags.StringVar(&opts.hostname, "hostname", "", "Hostname inside the container")
 	flags.StringVarP(&opts.workDir, "workdir", "w", "", "Working directory inside the container")
+	flags.StringVarP(&opts.user, "user", "u", "", "Username or UID to run as inside the container, default the image's USER")
+	flags.StringVar(&opts.entrypoint, "entrypoint", "", "Overwrite the default ENTRYPOINT of the image, dropping its CMD")
 	flags.StringVar(&opts.network, "network", "", "Network inside the container, support host only")
-	flags.StringVar(&opts.restart, "restart", "", "Restart policy, not support")
+	flags.StringVar(&opts.restart, "restart", restartNo, "Restart policy of a detached container: no, on-failure[:max-retries], always or unless-stopped")
 	flags.StringSliceVarP(&opts.envVars, "env", "e", []string{}, "Set environment variables (e.g., -e UID=0 -e GID=0)")
 	flags.StringSliceVarP(&opts.volumes, "volume", "v", []string{}, "Bind mount a volume (e.g., -v /mnt:/mnt)")
 	flags.StringSliceVarP(&opts.publish, "publish", "p", []string{}, "Publish a container's port(s) to the host. not support")
+	flags.StringSliceVar(&opts.logOpts, "log-opt", []string{}, "Log options of a detached container (e.g., --log-opt max-size=10m,max-file=3,compress=true), default from dockroot.json")
+	flags.StringSliceVar(&opts.etc.dns, "dns", []string{}, "Set custom DNS servers, default from dockroot.json or the host")
+	flags.StringSliceVar(&opts.etc.dnsSearch, "dns-search", []string{}, "Set custom DNS search domains, default from dockroot.json or the host")
+	flags.StringSliceVar(&opts.etc.addHosts, "add-host", []string{}, "Add a custom host-to-IP mapping (e.g., --add-host nas:192.168.1.2)")
+	flags.StringVar(&opts.etc.tz, "tz", "", "Bind /etc/localtime for `TZ`, a zone like Asia/Shanghai or \"host\", default from dockroot.json")
 	return cmd
 }
 
@@ -55,8 +75,13 @@ func (opts *ruriRunOptions) run(args []string, stdout io.Writer) (retErr error)
 	if !opts.renew {
 		if opts.hostname != "" ||
 			opts.workDir != "" ||
+			opts.user != "" ||
 			len(opts.envVars) > 0 ||
-			len(opts.volumes) > 0 {
+			len(opts.volumes) > 0 ||
+			len(opts.etc.dns) > 0 ||
+			len(opts.etc.dnsSearch) > 0 ||
+			len(opts.etc.addHosts) > 0 ||
+			opts.etc.tz != "" {
 			return fmt.Errorf("Cannot specify options without --renew")
 		}
 	}
@@ -70,40 +95,68 @@ func (opts *ruriRunOptions) run(args []string, stdout io.Writer) (retErr error)
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err != nil {
 		return err
 	}
+	logOpts, err := info.logOptions(opts.logOpts)
+	if err != nil {
+		return err
+	}
+	restart, err := parseRestartPolicy(opts.restart)
+	if err != nil {
+		return err
+	}
+	if restart.name != restartNo && !opts.detach {
+		return fmt.Errorf("--restart needs -d")
+	}
 	hostname := CleanString(args[0])
 	destDir := filepath.Join(info.DataRoot, hostname)
 	destAbsDir, err := filepath.Abs(destDir)
 	if err != nil {
 		return err
 	}
+	state, err := readContainerState(destAbsDir)
+	if err != nil {
+		return err
+	}
+	if state.current().Status == containerRunning || state.supervised() {
+		return fmt.Errorf("Container %s is already running", args[0])
+	}
+	if state.Status == containerDead {
+		return fmt.Errorf("Container %s is dead, rm it first", args[0])
+	}
 
 	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
-		client := &http.Client{}
-		if err := downloadBinary(client,
-			"https://fw0.koolcenter.com/binary/DockRoot/ruri",
-			ruriPath,
-			"ruri"); err != nil {
-			return err
-		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
+	}
+	if err := mountContainerRootfs(info.DataRoot, destAbsDir); err != nil {
+		return err
 	}
 	confPath := filepath.Join(destAbsDir, "ruri.conf")
 	if _, err := os.Stat(confPath); err != nil {
 		opts.renew = true
 	}
 	if opts.renew {
+		etc := info.etcConfig()
+		if len(opts.etc.dns) > 0 {
+			etc.dns = opts.etc.dns
+		}
+		if len(opts.etc.dnsSearch) > 0 {
+			etc.dnsSearch = opts.etc.dnsSearch
+		}
+		if opts.etc.tz != "" {
+			etc.tz = opts.etc.tz
+		}
+		etc.addHosts = opts.etc.addHosts
 		err = writeRuri(ruriPath,
 			destAbsDir, opts.hostname,
 			opts.workDir,
+			opts.user,
 			opts.envVars,
-			opts.volumes)
+			opts.volumes,
+			etc)
 		if err != nil {
 			return err
 		}
@@ -111,21 +164,23 @@ func (opts *ruriRunOptions) run(args []string, stdout io.Writer) (retErr error)
 
 	env := os.Environ()
 	argExtras := args[1:]
+	if len(argExtras) > 0 || opts.entrypoint != "" {
+		argExtras, err = opts.command(destAbsDir, argExtras)
+		if err != nil {
+			return err
+		}
+	}
 	var argsToRun []string
 	if opts.detach {
-		logFile := filepath.Join(destAbsDir, "ruri.log")
-		if len(argExtras) == 0 {
-			argsToRun = []string{
-				"-b", "-L", logFile, "-c", confPath,
-			}
-		} else {
-			argsToRun = make([]string, 0, len(argExtras)+6)
-			argsToRun = append(argsToRun, []string{
-				"-b", "-L", logFile, "-c", confPath,
-			}...)
-			argsToRun = append(argsToRun, argExtras...)
+		self, err := os.Executable()
+		if err != nil {
+			return err
 		}
-		cmd := exec.Command(ruriPath, argsToRun...)
+		// ruri runs in the foreground under the supervisor, which writes its output to the log and restarts it.
+		argsToRun = append([]string{"supervise", "--restart", restart.String()}, logOpts.args()...)
+		argsToRun = append(argsToRun, destAbsDir, ruriPath, "-c", confPath)
+		argsToRun = append(argsToRun, argExtras...)
+		cmd := exec.Command(self, argsToRun...)
 		cmd.Env = append(os.Environ(), env...)
 		// 最小权限设置
 		cmd.SysProcAttr = &syscall.SysProcAttr{
@@ -149,33 +204,69 @@ func (opts *ruriRunOptions) run(args []string, stdout io.Writer) (retErr error)
 		}
 		cmd.Wait()
 	} else {
-		if len(argExtras) == 0 {
-			argsToRun = []string{
-				filepath.Base(ruriPath),
-				"-c", confPath,
+		argsToRun = append([]string{"-c", confPath}, argExtras...)
+		cmd := exec.Command(ruriPath, argsToRun...)
+		cmd.Args[0] = filepath.Base(ruriPath)
+		cmd.Env = env
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, stdout, os.Stderr
+		return runContainer(destAbsDir, cmd)
+	}
+	return nil
+}
+
+// runContainer runs cmd, the ruri of the container in containerDir, in the foreground, and records the run in the
+// state of the container. It returns an exitCodeError if the container failed, for DockRoot to exit with its status.
+func runContainer(containerDir string, cmd *exec.Cmd) error {
+	// The terminal sends SIGINT and SIGQUIT to ruri too; DockRoot only catches them to outlive ruri and record its exit.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := updateContainerState(containerDir, func(s *containerState) error {
+		return s.start(cmd.Process.Pid, time.Now())
+	}); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	for {
+		select {
+		case sig := <-signals:
+			if sig == syscall.SIGTERM || sig == syscall.SIGHUP {
+				_ = cmd.Process.Signal(sig)
+			}
+		case err := <-exited:
+			exitCode, err := exitStatus(err)
+			if err != nil {
+				exitCode = exitCodeUnknown
+			}
+			if stateErr := updateContainerState(containerDir, func(s *containerState) error {
+				return s.exit(exitCode, time.Now())
+			}); err == nil {
+				err = stateErr
+			}
+			if err == nil && exitCode != 0 {
+				err = exitCodeError(exitCode)
 			}
-		} else {
-			argsToRun = make([]string, 0, len(argExtras)+6)
-			argsToRun = append(argsToRun, []string{
-				filepath.Base(ruriPath),
-				"-c", confPath,
-			}...)
-			argsToRun = append(argsToRun, argExtras...)
-		}
-		err = syscall.Exec(ruriPath, argsToRun, env)
-		if err != nil {
 			return err
 		}
 	}
-	return nil
 }
 
 func writeRuri(ruriPath,
 	destAbsDir,
 	hostname,
-	workDir string,
+	workDir,
+	user string,
 	envs,
-	volumes []string) error {
+	volumes []string,
+	etc etcConfig) error {
 	var targetBashStr string
 	if _, err := os.Stat(filepath.Join(destAbsDir, "rootfs", "bin", "bash")); err == nil {
 		targetBashStr = "/bin/bash"
@@ -183,16 +274,6 @@ func writeRuri(ruriPath,
 		targetBashStr = "/bin/sh"
 	}
 
-	txts := []string{
-		"nameserver 223.5.5.5",
-		"",
-	}
-	err := os.WriteFile(filepath.Join(destAbsDir, "/rootfs/etc/resolv.conf"),
-		[]byte(strings.Join(txts, "\n")), 0644)
-	if err != nil {
-		return err
-	}
-
 	spec, err := getSpecConfig(filepath.Join(destAbsDir, "config.json"))
 	if err != nil {
 		return err
@@ -212,6 +293,12 @@ func writeRuri(ruriPath,
 		ruriInfo.Hostname = CleanString(filepath.Base(destAbsDir))
 	}
 
+	if len(user) > 0 {
+		ruriInfo.User = user
+	} else if spec.Process.User.UID != 0 || spec.Process.User.Username != "" {
+		ruriInfo.User = ruriUser(ruriInfo.ContainerDir, spec.Process.User)
+	}
+
 	if len(workDir) > 0 {
 		ruriInfo.WorkDir = workDir
 	} else {
@@ -239,8 +326,9 @@ func writeRuri(ruriPath,
 		}
 	}
 
-	if len(spec.Process.Args) > 0 {
-		if spec.Process.Args[0] == "/init" {
+	entrypoint, cmd := imageCommand(destAbsDir, spec)
+	if command := containerCommand(entrypoint, cmd, "", nil); len(command) > 0 {
+		if command[0] == "/init" {
 			if isHomeassistant(spec) {
 				entry, err := writeHomeassistant(targetBashStr, destAbsDir)
 				if err == nil {
@@ -248,7 +336,7 @@ func writeRuri(ruriPath,
 				}
 			}
 		} else {
-			ruriInfo.Commands = spec.Process.Args
+			ruriInfo.Commands = command
 		}
 	}
 	if len(ruriInfo.Commands) == 0 {
@@ -268,6 +356,10 @@ func writeRuri(ruriPath,
 		}
 	}
 
+	if err := etc.apply(ruriInfo.ContainerDir, ruriInfo); err != nil {
+		return err
+	}
+
 	ruriConf, err := os.OpenFile(filepath.Join(destAbsDir, "ruri.conf"),
 		os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
 	if err != nil {
@@ -277,6 +369,43 @@ func writeRuri(ruriPath,
 	return RenderRuriInfo(ruriInfo, ruriConf)
 }
 
+// command returns the command replacing the one in ruri.conf for this run, from --entrypoint and args.
+func (opts *ruriRunOptions) command(destAbsDir string, args []string) ([]string, error) {
+	spec, err := getSpecConfig(filepath.Join(destAbsDir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+	entrypoint, cmd := imageCommand(destAbsDir, spec)
+	command := containerCommand(entrypoint, cmd, opts.entrypoint, args)
+	if len(command) == 0 {
+		return nil, fmt.Errorf("No command specified")
+	}
+	return command, nil
+}
+
+// imageCommand returns the Entrypoint and Cmd of the image the container in destAbsDir was unpacked from.
+// Containers unpacked before the image config was kept only have the merged command in spec, which is returned as Cmd.
+func imageCommand(destAbsDir string, spec *rspec.Spec) (entrypoint, cmd []string) {
+	img, err := getImageConfig(filepath.Join(destAbsDir, imageConfigFile))
+	if err != nil {
+		return nil, spec.Process.Args
+	}
+	return img.Config.Entrypoint, img.Config.Cmd
+}
+
+// containerCommand returns the command to run, with the semantics of docker run:
+// entrypointOverride, if set, replaces the Entrypoint and drops the image's Cmd, and args, if any, replace Cmd.
+func containerCommand(entrypoint, cmd []string, entrypointOverride string, args []string) []string {
+	if entrypointOverride != "" {
+		entrypoint = []string{entrypointOverride}
+		cmd = nil
+	}
+	if len(args) > 0 {
+		cmd = args
+	}
+	return append(slices.Clone(entrypoint), cmd...)
+}
+
 func isHomeassistant(spec *rspec.Spec) bool {
 	if strings.Contains(spec.Hostname, "home-assistant") {
 		return true
@@ -312,3 +441,24 @@ func writeHomeassistant(targetBashStr, destAbsDir string) (string, error) {
 	}
 	return "/" + targetPath, nil
 }
+
+// ruriUser returns the ruri user for the image's user: its name if the image's /etc/passwd knows it, otherwise its UID.
+// ruri takes the groups from the container's /etc/passwd and /etc/group, so a GID which differs from them can only be reported.
+func ruriUser(rootfs string, u rspec.User) string {
+	if u.Username != "" {
+		return u.Username
+	}
+	users, err := user.ParsePasswdFileFilter(filepath.Join(rootfs, "etc", "passwd"), func(e user.User) bool {
+		return e.Uid == int(u.UID)
+	})
+	if err == nil && len(users) > 0 {
+		if users[0].Gid != int(u.GID) {
+			logrus.Warnf("Image runs as %d:%d, but ruri uses the group of %s, %d", u.UID, u.GID, users[0].Name, users[0].Gid)
+		}
+		return users[0].Name
+	}
+	if u.GID != u.UID {
+		logrus.Warnf("Image runs as %d:%d, but ruri can not set the group of a user missing from /etc/passwd", u.UID, u.GID)
+	}
+	return strconv.FormatUint(uint64(u.UID), 10)
+}
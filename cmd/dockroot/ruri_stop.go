@@ -1,15 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
+// containerStopTimeout is how long stop and rm wait for a killed container to be recorded as stopped.
+const containerStopTimeout = 10 * time.Second
+
 type ruriStopOptions struct {
 	global *globalOptions
 }
@@ -33,7 +39,7 @@ func (opts *ruriStopOptions) run(args []string, stdout io.Writer) (retErr error)
 	if err != nil {
 		return err
 	}
-	info, err := readRegistryInfo(binaryDir)
+	info, err := opts.global.readRegistryInfo(binaryDir)
 	if err != nil {
 		return err
 	}
@@ -45,28 +51,28 @@ func (opts *ruriStopOptions) run(args []string, stdout io.Writer) (retErr error)
 	}
 
 	ruriPath := filepath.Join(binaryDir, "ruri")
-	if !checkIsRuriDownload(ruriPath) {
-		client := &http.Client{}
-		if err := downloadBinary(client,
-			"https://fw0.koolcenter.com/binary/DockRoot/ruri",
-			ruriPath,
-			"ruri"); err != nil {
-			return err
-		}
-		if !checkIsBinaryDownload(ruriPath, "-v", "ruri version") {
-			return fmt.Errorf("failed to download ruri binary")
-		}
+	if err := checkAndDownloadRuri(ruriPath, info.dependency(dependencyRuri), &http.Client{}); err != nil {
+		return err
 	}
 	confPath := filepath.Join(destAbsDir, "ruri.conf")
 	if _, err := os.Stat(confPath); err != nil {
 		return err
 	}
+	if err := stopSupervisor(destAbsDir); err != nil {
+		return err
+	}
 	pids, err := RuriPids(ruriPath, confPath)
 	if err != nil {
 		return err
 	}
 	if len(pids) > 0 {
-		return KillProcess(pids)
+		err = KillProcess(pids)
+	}
+	// Whoever ran the container, its supervisor or run, records how it exited.
+	ctx, cancel := context.WithTimeout(context.Background(), containerStopTimeout)
+	defer cancel()
+	if _, waitErr := waitContainer(ctx, destAbsDir); waitErr != nil {
+		logrus.Warnf("Container %s did not stop: %v", args[0], waitErr)
 	}
-	return nil
+	return err
 }
@@ -3,7 +3,14 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	rspec "github.com/opencontainers/runtime-spec/specs-go"
 )
 
 func TestRuri(t *testing.T) {
@@ -15,3 +22,108 @@ func TestRuri(t *testing.T) {
 	}
 	fmt.Println(buf.String())
 }
+
+// writeTestContainer creates a container directory for writeRuri from spec, with passwd as the image's /etc/passwd.
+func writeTestContainer(t *testing.T, spec *rspec.Spec, passwd string) string {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "rootfs", "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "rootfs", "etc", "passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveSpecConfig(filepath.Join(dir, "config.json"), spec); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestRuriUser(t *testing.T) {
+	passwd := "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/home/app:/bin/sh\n"
+	for _, c := range []struct {
+		user     rspec.User
+		override string
+		expected string
+	}{
+		{rspec.User{}, "", `user=""`},
+		{rspec.User{UID: 1000, GID: 1000}, "", `user="app"`},
+		{rspec.User{UID: 1001, GID: 1001}, "", `user="1001"`},
+		{rspec.User{Username: "nobody"}, "", `user="nobody"`},
+		{rspec.User{UID: 1000, GID: 1000}, "root", `user="root"`},
+	} {
+		spec := &rspec.Spec{Process: &rspec.Process{User: c.user, Args: []string{"/bin/sh"}, Cwd: "/"}}
+		dir := writeTestContainer(t, spec, passwd)
+		if err := writeRuri("/usr/bin/ruri", dir, "", "", c.override, nil, nil, etcConfig{}); err != nil {
+			t.Fatal(err)
+		}
+		assertRuriConfContains(t, dir, c.expected)
+	}
+}
+
+func TestContainerCommand(t *testing.T) {
+	entrypoint, cmd := []string{"/docker-entrypoint.sh"}, []string{"nginx", "-g", "daemon off;"}
+	for _, c := range []struct {
+		entrypointOverride string
+		args               []string
+		expected           []string
+	}{
+		{"", nil, []string{"/docker-entrypoint.sh", "nginx", "-g", "daemon off;"}},
+		{"", []string{"--help"}, []string{"/docker-entrypoint.sh", "--help"}},
+		{"/bin/sh", nil, []string{"/bin/sh"}},
+		{"/bin/sh", []string{"-c", "id"}, []string{"/bin/sh", "-c", "id"}},
+	} {
+		got := containerCommand(entrypoint, cmd, c.entrypointOverride, c.args)
+		if !slices.Equal(got, c.expected) {
+			t.Errorf("--entrypoint %q %q: expected %q, got %q", c.entrypointOverride, c.args, c.expected, got)
+		}
+	}
+	if got := containerCommand(nil, nil, "", nil); len(got) != 0 {
+		t.Errorf("expected no command, got %q", got)
+	}
+}
+
+func TestRuriImageCommand(t *testing.T) {
+	spec := &rspec.Spec{Process: &rspec.Process{Args: []string{"/docker-entrypoint.sh", "nginx"}, Cwd: "/"}}
+
+	// Without the image config, the merged command from config.json is used.
+	dir := writeTestContainer(t, spec, "")
+	if err := writeRuri("/usr/bin/ruri", dir, "", "", "", nil, nil, etcConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	assertRuriConfContains(t, dir, `command=["/docker-entrypoint.sh","nginx"]`)
+	opts := ruriRunOptions{}
+	command, err := opts.command(dir, []string{"--help"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(command, []string{"--help"}) {
+		t.Errorf("unexpected command %q", command)
+	}
+
+	// With the image config, args only replace Cmd.
+	img := &imgspecv1.Image{Config: imgspecv1.ImageConfig{Entrypoint: []string{"/docker-entrypoint.sh"}, Cmd: []string{"nginx"}}}
+	if err := saveImageConfig(filepath.Join(dir, imageConfigFile), img); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeRuri("/usr/bin/ruri", dir, "", "", "", nil, nil, etcConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	assertRuriConfContains(t, dir, `command=["/docker-entrypoint.sh","nginx"]`)
+	command, err = opts.command(dir, []string{"--help"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(command, []string{"/docker-entrypoint.sh", "--help"}) {
+		t.Errorf("unexpected command %q", command)
+	}
+}
+
+func assertRuriConfContains(t *testing.T, dir, expected string) {
+	b, err := os.ReadFile(filepath.Join(dir, "ruri.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "\n"+expected+"\n") {
+		t.Errorf("expected %s in\n%s", expected, b)
+	}
+}
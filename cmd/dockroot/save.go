@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/containers/image/v5/copy"
+	dockerarchive "github.com/containers/image/v5/docker/archive"
+	ociarchive "github.com/containers/image/v5/oci/archive"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+const (
+	formatDockerArchive = "docker-archive"
+	formatOCIArchive    = "oci-archive"
+)
+
+type saveOptions struct {
+	global *globalOptions
+	output string
+	format string
+}
+
+func saveCmd(global *globalOptions) *cobra.Command {
+	opts := saveOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "save NAME|IMAGE[:TAG|@DIGEST] -o FILE",
+		Short: "write an image from the image store, or the image of a container, to an archive",
+		Long: `Write an image from the image store, or the image the container NAME was created from,
+to a docker-archive, as docker save does, or an oci-archive. pull imports both.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot save alpine:latest -o alpine.tar
+DockRoot save --format oci-archive homeassistant001 -o homeassistant.tar`,
+	}
+	flags := cmd.Flags()
+	flags.StringVarP(&opts.output, "output", "o", "", "Write the archive to `FILE`")
+	flags.StringVar(&opts.format, "format", formatDockerArchive, "Archive format, "+formatDockerArchive+" or "+formatOCIArchive)
+	return cmd
+}
+
+func (opts *saveOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) != 1 || opts.output == "" {
+		return fmt.Errorf("Usage: %s save NAME|IMAGE[:TAG|@DIGEST] -o FILE", os.Args[0])
+	}
+	if opts.format != formatDockerArchive && opts.format != formatOCIArchive {
+		return fmt.Errorf("Invalid format %q, must be %s or %s", opts.format, formatDockerArchive, formatOCIArchive)
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+
+	if opts.global.policyPath == "" {
+		opts.global.insecurePolicy = true
+	}
+	policyContext, err := opts.global.getPolicyContext()
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %v", err)
+	}
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		}
+	}()
+
+	unlock, err := lockImageStore(info.DataRoot, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	img, err := resolveImageOrContainer(info.DataRoot, args[0])
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+	if err := saveImage(ctx, policyContext, opts.global.newSystemContext(), imageStoreDir(info.DataRoot), img, opts.format, opts.output); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Saved %s to %s\n", img.Name, opts.output)
+	return nil
+}
+
+// resolveImageOrContainer returns the image of the container name in dataRoot, or else the image in the store named name.
+func resolveImageOrContainer(dataRoot, name string) (*containerImage, error) {
+	if containerName := CleanString(name); containerName != "" {
+		dir := filepath.Join(dataRoot, containerName)
+		if isDirValid(dir) {
+			if img, err := readContainerImage(dir); err == nil {
+				return img, nil
+			}
+		}
+	}
+	return resolveStoreImage(imageStoreDir(dataRoot), name)
+}
+
+// saveImage copies img from storeDir to an archive of format at output, named like in the store unless img is only
+// known by its digest.
+func saveImage(ctx context.Context, policyContext *signature.PolicyContext, sys *types.SystemContext, storeDir string, img *containerImage, format, output string) error {
+	srcRef, err := layout.NewReference(storeDir, img.Digest)
+	if err != nil {
+		return err
+	}
+	var tagged reference.NamedTagged
+	if named, err := reference.ParseNormalizedNamed(img.Name); err == nil {
+		tagged, _ = named.(reference.NamedTagged)
+	}
+	// Archives are written from scratch.
+	if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var destRef types.ImageReference
+	if format == formatOCIArchive {
+		name := ""
+		if tagged != nil {
+			name = tagged.String()
+		}
+		destRef, err = ociarchive.NewReference(output, name)
+	} else {
+		destRef, err = dockerarchive.NewReference(output, tagged)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = copy.Image(ctx, policyContext, destRef, srcRef, &copy.Options{
+		SourceCtx:      sys,
+		DestinationCtx: sys,
+	})
+	return err
+}
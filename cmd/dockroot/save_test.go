@@ -0,0 +1,72 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/containers/image/v5/signature"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSaveImage(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:1.0"
+	addTestImage(t, storeDir, refName, map[string]string{"usr/bin/app": "v1"}, nil)
+	containerDir := unpackTestContainer(t, dataRoot, refName, "app001")
+	require.NoError(t, os.WriteFile(filepath.Join(containerDir, "rootfs/usr/bin/app"), []byte("v2"), 0755))
+
+	// Containers are looked up before images.
+	img, err := resolveImageOrContainer(dataRoot, "app001")
+	require.NoError(t, err)
+	assert.Equal(t, refName, img.Name)
+	byName, err := resolveImageOrContainer(dataRoot, "app:1.0")
+	require.NoError(t, err)
+	assert.Equal(t, img.Digest, byName.Digest)
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	for _, format := range []string{formatDockerArchive, formatOCIArchive} {
+		output := filepath.Join(t.TempDir(), "app.tar")
+		require.NoError(t, saveImage(context.Background(), policyContext, nil, storeDir, img, format, output), format)
+
+		// pull imports the archive under the same name.
+		source := format + ":" + output
+		if format == formatOCIArchive {
+			source += ":" + refName
+		}
+		srcRef, err := parseLocalSource(source)
+		require.NoError(t, err)
+		name, err := localSourceName(nil, srcRef, "")
+		require.NoError(t, err)
+		assert.Equal(t, refName, name.storeName())
+	}
+
+	// export writes the rootfs with the container's changes.
+	output := filepath.Join(t.TempDir(), "rootfs.tar")
+	require.NoError(t, exportContainer(containerDir, output))
+	f, err := os.Open(output)
+	require.NoError(t, err)
+	defer f.Close()
+	files := map[string]string{}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := io.ReadAll(tr)
+			require.NoError(t, err)
+			files[hdr.Name] = string(b)
+		}
+	}
+	assert.Equal(t, "v2", files["usr/bin/app"])
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// storageCopy unpacks a full copy of the image into each container's rootfs.
+	storageCopy = "copy"
+	// storageOverlay unpacks each image once into lowerStoreName, and mounts it under a per-container
+	// overlayfs upper dir as the rootfs.
+	storageOverlay = "overlay"
+
+	// lowerStoreName is the directory in DataRoot holding the images unpacked for storageOverlay, by manifest digest.
+	// CleanString drops ".", so no container can be named like it.
+	lowerStoreName = ".rootfs"
+)
+
+// storage returns the storage mode of new containers, storageCopy unless dockroot.json asks for another.
+func (info *registryInfo) storage() (string, error) {
+	if info == nil || info.Storage == "" {
+		return storageCopy, nil
+	}
+	switch info.Storage {
+	case storageCopy, storageOverlay:
+		return info.Storage, nil
+	}
+	return "", fmt.Errorf("Invalid storage %q in dockroot.json, must be %q or %q", info.Storage, storageCopy, storageOverlay)
+}
+
+// lowerBundleDir returns the bundle the image with manifestDigest is unpacked into for storageOverlay.
+func lowerBundleDir(dataRoot, manifestDigest string) string {
+	return filepath.Join(dataRoot, lowerStoreName, digest.Digest(manifestDigest).Encoded())
+}
+
+// overlayDirs returns the rootfs of the lower bundle of img, and the overlayfs upper and work dirs of containerDir.
+func overlayDirs(dataRoot string, img *containerImage, containerDir string) (lower, upper, work string) {
+	return filepath.Join(lowerBundleDir(dataRoot, img.Digest), "rootfs"),
+		filepath.Join(containerDir, "upper"),
+		filepath.Join(containerDir, "work")
+}
+
+// unpackContainer unpacks img from the store in dataRoot into containerDir with the storage mode, and sets img.Storage.
+// Where overlayfs is unavailable, it falls back to storageCopy.
+// The caller holds the image store lock.
+func unpackContainer(dataRoot string, img *containerImage, containerDir, storage string) error {
+	img.Storage = ""
+	if storage == storageOverlay {
+		err := createOverlayContainer(dataRoot, img, containerDir)
+		if err == nil {
+			img.Storage = storageOverlay
+			return nil
+		}
+		logrus.Warnf("Not using overlayfs, copying the image instead: %v", err)
+	}
+	return unpack(imageStoreDir(dataRoot), img.Digest, containerDir)
+}
+
+// createOverlayContainer unpacks img into its lower bundle if needed, and mounts it as the rootfs of containerDir.
+// On failure, containerDir is left as it was found.
+func createOverlayContainer(dataRoot string, img *containerImage, containerDir string) (retErr error) {
+	lowerBundle := lowerBundleDir(dataRoot, img.Digest)
+	if err := ensureLowerBundle(imageStoreDir(dataRoot), img.Digest, lowerBundle); err != nil {
+		return err
+	}
+	lower, upper, work := overlayDirs(dataRoot, img, containerDir)
+	rootfs := filepath.Join(containerDir, "rootfs")
+	created := []string{upper, work, rootfs}
+	for _, p := range []string{"config.json", imageConfigFile} {
+		data, err := os.ReadFile(filepath.Join(lowerBundle, p))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(containerDir, p), data, 0644); err != nil {
+			return err
+		}
+		created = append(created, filepath.Join(containerDir, p))
+	}
+	defer func() {
+		if retErr != nil {
+			for _, p := range created {
+				os.RemoveAll(p)
+			}
+		}
+	}()
+	for _, p := range []string{upper, work, rootfs} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+	}
+	return mountOverlay(lower, upper, work, rootfs)
+}
+
+// ensureLowerBundle unpacks the image with manifestDigest from storeDir into lowerBundle, unless it is there already.
+func ensureLowerBundle(storeDir, manifestDigest, lowerBundle string) error {
+	if isDirValid(lowerBundle) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(lowerBundle), 0755); err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(filepath.Dir(lowerBundle), "."+filepath.Base(lowerBundle)+".unpack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	bundle := filepath.Join(tmpDir, "bundle")
+	if err := unpack(storeDir, manifestDigest, bundle); err != nil {
+		return err
+	}
+	// Creating containers only takes the store lock shared, so another one may have won the race.
+	if err := os.Rename(bundle, lowerBundle); err != nil && !isDirValid(lowerBundle) {
+		return err
+	}
+	return nil
+}
+
+// mountContainerRootfs mounts the overlayfs rootfs of the container in containerDir, if it uses one and it is not
+// mounted yet, as after a reboot or rm.
+func mountContainerRootfs(dataRoot, containerDir string) error {
+	img, err := readContainerImage(containerDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if img.Storage != storageOverlay {
+		return nil
+	}
+	rootfs := filepath.Join(containerDir, "rootfs")
+	mounted, err := isMountPoint(rootfs)
+	if err != nil || mounted {
+		return err
+	}
+	lower, upper, work := overlayDirs(dataRoot, img, containerDir)
+	if _, err := os.Stat(lower); err != nil {
+		return fmt.Errorf("The image of container %s is gone from %s: %w", filepath.Base(containerDir), lowerStoreName, err)
+	}
+	return mountOverlay(lower, upper, work, rootfs)
+}
+
+// unmountContainerRootfs unmounts the overlayfs rootfs of the container in containerDir, if it uses one.
+func unmountContainerRootfs(containerDir string) error {
+	img, err := readContainerImage(containerDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if img.Storage != storageOverlay {
+		return nil
+	}
+	rootfs := filepath.Join(containerDir, "rootfs")
+	mounted, err := isMountPoint(rootfs)
+	if err != nil || !mounted {
+		return err
+	}
+	return unmountRootfs(rootfs)
+}
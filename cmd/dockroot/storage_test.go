@@ -0,0 +1,74 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegistryInfoStorage(t *testing.T) {
+	for _, c := range []struct{ in, out string }{
+		{"", storageCopy},
+		{storageCopy, storageCopy},
+		{storageOverlay, storageOverlay},
+	} {
+		storage, err := (&registryInfo{Storage: c.in}).storage()
+		require.NoError(t, err)
+		assert.Equal(t, c.out, storage)
+	}
+	_, err := (&registryInfo{Storage: "zfs"}).storage()
+	assert.ErrorContains(t, err, "Invalid storage")
+}
+
+func TestOverlayContainer(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:latest"
+	addTestImage(t, storeDir, refName, map[string]string{
+		"usr/bin/app": "v1",
+		"data/seed":   "seed",
+	}, []string{"/data"})
+	manifestDigest, err := tagImageDigest(storeDir, refName)
+	require.NoError(t, err)
+
+	containerDir := filepath.Join(dataRoot, "app001")
+	require.NoError(t, os.Mkdir(containerDir, 0755))
+	img := &containerImage{Name: refName, Digest: manifestDigest.String()}
+	require.NoError(t, unpackContainer(dataRoot, img, containerDir, storageOverlay))
+	require.NoError(t, writeContainerImage(containerDir, img))
+	rootfs := filepath.Join(containerDir, "rootfs")
+	read := func(p string) string {
+		b, err := os.ReadFile(filepath.Join(rootfs, p))
+		require.NoError(t, err, p)
+		return string(b)
+	}
+	assert.True(t, isDirValid(containerDir))
+	assert.Equal(t, "v1", read("usr/bin/app"))
+	if img.Storage != storageOverlay {
+		t.Skip("overlayfs is not available, the image was copied instead")
+	}
+	defer unmountContainerRootfs(containerDir)
+
+	// Writes go to the upper dir and leave the shared image alone.
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "usr/bin/app"), []byte("broken"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(rootfs, "data/db"), []byte("state"), 0644))
+	lower, upper, _ := overlayDirs(dataRoot, img, containerDir)
+	b, err := os.ReadFile(filepath.Join(lower, "usr/bin/app"))
+	require.NoError(t, err)
+	assert.Equal(t, "v1", string(b))
+	assert.FileExists(t, filepath.Join(upper, "usr/bin/app"))
+
+	require.NoError(t, resetOverlayContainer(dataRoot, img, containerDir))
+	assert.Equal(t, "v1", read("usr/bin/app"))
+	assert.Equal(t, "state", read("data/db"))
+	assert.Equal(t, "seed", read("data/seed"))
+
+	// After rm or a reboot, run mounts the rootfs again.
+	require.NoError(t, unmountContainerRootfs(containerDir))
+	assert.NoFileExists(t, filepath.Join(rootfs, "usr/bin/app"))
+	require.NoError(t, mountContainerRootfs(dataRoot, containerDir))
+	assert.Equal(t, "state", read("data/db"))
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// Restart policies, as in docker. DockRoot has no daemon restarting containers at boot, so unless-stopped
+// behaves like always: both restart the container whenever it exits, until stop.
+const (
+	restartNo            = "no"
+	restartOnFailure     = "on-failure"
+	restartAlways        = "always"
+	restartUnlessStopped = "unless-stopped"
+)
+
+const (
+	restartInitialBackoff = 100 * time.Millisecond
+	restartMaxBackoff     = time.Minute
+	restartResetAfter     = 10 * time.Second // A run at least this long starts the backoff over
+)
+
+// restartPolicy is a parsed --restart value.
+type restartPolicy struct {
+	name       string
+	maxRetries int // For on-failure; no limit if 0
+}
+
+// parseRestartPolicy parses s, one of no, on-failure[:max-retries], always or unless-stopped; no if empty.
+func parseRestartPolicy(s string) (restartPolicy, error) {
+	name, retries, hasRetries := strings.Cut(s, ":")
+	policy := restartPolicy{name: name}
+	switch name {
+	case "":
+		policy.name = restartNo
+	case restartNo, restartAlways, restartUnlessStopped:
+	case restartOnFailure:
+		if hasRetries {
+			n, err := strconv.Atoi(retries)
+			if err != nil || n < 1 {
+				return restartPolicy{}, fmt.Errorf("Invalid restart policy %q, the maximum retry count must be a positive number", s)
+			}
+			policy.maxRetries = n
+		}
+		return policy, nil
+	default:
+		return restartPolicy{}, fmt.Errorf("Invalid restart policy %q, must be %s, %s[:max-retries], %s or %s",
+			s, restartNo, restartOnFailure, restartAlways, restartUnlessStopped)
+	}
+	if hasRetries {
+		return restartPolicy{}, fmt.Errorf("Invalid restart policy %q, only %s takes a maximum retry count", s, restartOnFailure)
+	}
+	return policy, nil
+}
+
+func (p restartPolicy) String() string {
+	if p.maxRetries > 0 {
+		return fmt.Sprintf("%s:%d", p.name, p.maxRetries)
+	}
+	return p.name
+}
+
+// shouldRestart returns true if a container which exited with exitCode, after restartCount restarts, is to be
+// restarted. A manual stop is up to the caller.
+func (p restartPolicy) shouldRestart(exitCode, restartCount int) bool {
+	switch p.name {
+	case restartAlways, restartUnlessStopped:
+		return true
+	case restartOnFailure:
+		return exitCode != 0 && (p.maxRetries == 0 || restartCount < p.maxRetries)
+	}
+	return false
+}
+
+type superviseOptions struct {
+	restart string
+	logOpts []string
+}
+
+// superviseCmd is run by run -d in place of ruri -b, to write the output of the container to its log and to
+// restart it as its restart policy says.
+func superviseCmd() *cobra.Command {
+	var opts superviseOptions
+	cmd := &cobra.Command{
+		Use:    "supervise [--restart POLICY] [--log-opt KEY=VALUE] DIR COMMAND [ARGS]",
+		Short:  "run the command of the container in DIR, logging its output and restarting it",
+		Hidden: true,
+		RunE:   commandAction(opts.run),
+	}
+	flags := cmd.Flags()
+	flags.SetInterspersed(false)
+	flags.StringVar(&opts.restart, "restart", restartNo, "Restart policy")
+	flags.StringSliceVar(&opts.logOpts, "log-opt", []string{}, "Log options, max-size, max-file and compress")
+	return cmd
+}
+
+func (opts *superviseOptions) run(args []string, stdout io.Writer) error {
+	if len(args) < 2 {
+		return fmt.Errorf("Usage: %s supervise [--restart POLICY] [--log-opt KEY=VALUE] DIR COMMAND [ARGS]", os.Args[0])
+	}
+	policy, err := parseRestartPolicy(opts.restart)
+	if err != nil {
+		return err
+	}
+	logOpts, err := parseLogOptions(nil, opts.logOpts)
+	if err != nil {
+		return err
+	}
+	containerDir := args[0]
+	log, err := openRotatingLog(filepath.Join(containerDir, containerLogFile), logOpts)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(stop)
+	return superviseContainer(containerDir, policy, log, stop, func() *exec.Cmd {
+		return exec.Command(args[1], args[2:]...)
+	})
+}
+
+// superviseContainer runs the commands newCmd returns, with their output going to log, for as long as policy
+// says to restart them, waiting longer after each quick exit. It records each run in the state of the container
+// in containerDir, and does not restart it after stop. A signal on stop is passed on to the running command,
+// and ends supervision.
+func superviseContainer(containerDir string, policy restartPolicy, log *rotatingLog, stop <-chan os.Signal, newCmd func() *exec.Cmd) error {
+	if err := updateContainerState(containerDir, func(s *containerState) error {
+		s.RestartPolicy = policy.String()
+		s.RestartCount = 0
+		s.ManualStop = false
+		s.SupervisorPid = os.Getpid()
+		return nil
+	}); err != nil {
+		return err
+	}
+	done := func() error {
+		return updateContainerState(containerDir, func(s *containerState) error {
+			s.SupervisorPid = 0
+			return nil
+		})
+	}
+
+	backoff := restartInitialBackoff
+	stopping := false
+	for {
+		cmd := newCmd()
+		wait, err := startLogged(log, cmd)
+		if err != nil {
+			return errors.Join(err, done())
+		}
+		started := time.Now()
+		if err := updateContainerState(containerDir, func(s *containerState) error {
+			return s.start(cmd.Process.Pid, started)
+		}); err != nil {
+			_ = cmd.Process.Kill()
+			_ = wait()
+			return errors.Join(err, done())
+		}
+		exited := make(chan error, 1)
+		go func() {
+			exited <- wait()
+		}()
+		var waitErr error
+	running:
+		for {
+			select {
+			case sig := <-stop:
+				stopping = true
+				_ = cmd.Process.Signal(sig)
+			case waitErr = <-exited:
+				break running
+			}
+		}
+		exitCode, waitErr := exitStatus(waitErr)
+		if waitErr != nil {
+			exitCode = exitCodeUnknown
+		}
+
+		restart := false
+		if err := updateContainerState(containerDir, func(s *containerState) error {
+			if err := s.exit(exitCode, time.Now()); err != nil {
+				return err
+			}
+			restart = waitErr == nil && !stopping && !s.ManualStop && policy.shouldRestart(exitCode, s.RestartCount)
+			if restart {
+				s.RestartCount++
+			} else {
+				s.SupervisorPid = 0
+			}
+			return nil
+		}); err != nil || !restart {
+			return errors.Join(waitErr, err)
+		}
+
+		if time.Since(started) >= restartResetAfter {
+			backoff = restartInitialBackoff
+		}
+		select {
+		case <-stop:
+			return done()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, restartMaxBackoff)
+	}
+}
+
+// stopSupervisor marks the container in containerDir as stopped by hand, so that it is not restarted, and asks
+// its supervisor, if one runs, to exit once the container does.
+func stopSupervisor(containerDir string) error {
+	var state containerState
+	if err := updateContainerState(containerDir, func(s *containerState) error {
+		s.ManualStop = true
+		state = *s
+		return nil
+	}); err != nil {
+		return err
+	}
+	// The PID may be stale, if the supervisor was killed.
+	if !state.supervised() {
+		return nil
+	}
+	if err := syscall.Kill(state.SupervisorPid, syscall.SIGTERM); err != nil && !errors.Is(err, syscall.ESRCH) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRestartPolicy(t *testing.T) {
+	for _, c := range []struct {
+		input    string
+		expected restartPolicy
+	}{
+		{"", restartPolicy{name: restartNo}},
+		{"no", restartPolicy{name: restartNo}},
+		{"always", restartPolicy{name: restartAlways}},
+		{"unless-stopped", restartPolicy{name: restartUnlessStopped}},
+		{"on-failure", restartPolicy{name: restartOnFailure}},
+		{"on-failure:3", restartPolicy{name: restartOnFailure, maxRetries: 3}},
+	} {
+		policy, err := parseRestartPolicy(c.input)
+		require.NoError(t, err, c.input)
+		assert.Equal(t, c.expected, policy, c.input)
+	}
+	for _, bad := range []string{"sometimes", "on-failure:0", "on-failure:x", "always:3"} {
+		_, err := parseRestartPolicy(bad)
+		assert.Error(t, err, bad)
+	}
+	assert.Equal(t, "on-failure:3", restartPolicy{name: restartOnFailure, maxRetries: 3}.String())
+
+	onFailure := restartPolicy{name: restartOnFailure, maxRetries: 2}
+	assert.True(t, onFailure.shouldRestart(1, 1))
+	assert.False(t, onFailure.shouldRestart(1, 2))
+	assert.False(t, onFailure.shouldRestart(0, 0))
+	assert.True(t, restartPolicy{name: restartAlways}.shouldRestart(0, 100))
+	assert.False(t, restartPolicy{name: restartNo}.shouldRestart(1, 0))
+}
+
+func TestSuperviseContainer(t *testing.T) {
+	dir := t.TempDir()
+	log, err := openRotatingLog(filepath.Join(dir, containerLogFile), logOptions{maxFile: 1})
+	require.NoError(t, err)
+	defer log.Close()
+
+	policy, err := parseRestartPolicy("on-failure:2")
+	require.NoError(t, err)
+	start := time.Now()
+	err = superviseContainer(dir, policy, log, nil, func() *exec.Cmd {
+		return exec.Command("sh", "-c", "echo run; exit 3")
+	})
+	require.NoError(t, err)
+	// Backing off 100ms, then 200ms.
+	assert.GreaterOrEqual(t, time.Since(start), 300*time.Millisecond)
+	state, err := readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerExited, state.Status)
+	assert.Equal(t, "on-failure:2", state.RestartPolicy)
+	assert.Equal(t, 2, state.RestartCount)
+	assert.Equal(t, 3, state.ExitCode)
+	assert.Zero(t, state.Pid)
+	assert.Zero(t, state.SupervisorPid)
+	assert.True(t, state.FinishedAt.After(state.StartedAt))
+	data, err := os.ReadFile(filepath.Join(dir, containerLogFile))
+	require.NoError(t, err)
+	assert.Equal(t, 3, strings.Count(string(data), " run\n"))
+
+	// A stop ends an always restarting container, and the signal reaches it.
+	stop := make(chan os.Signal, 1)
+	done := make(chan error)
+	go func() {
+		done <- superviseContainer(dir, restartPolicy{name: restartAlways}, log, stop, func() *exec.Cmd {
+			return exec.Command("sleep", "30")
+		})
+	}()
+	require.Eventually(t, func() bool {
+		state, err := readContainerState(dir)
+		return err == nil && state.Status == containerRunning && state.RestartCount == 0
+	}, 5*time.Second, 10*time.Millisecond)
+	state, err = readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), state.SupervisorPid)
+	assert.True(t, state.running())
+	assert.True(t, processCommandContains(state.Pid, "sleep"))
+	require.NoError(t, updateContainerState(dir, func(s *containerState) error {
+		s.ManualStop = true
+		return nil
+	}))
+	stop <- syscall.SIGTERM
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("supervision did not end after stop")
+	}
+	state, err = readContainerState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, containerExited, state.Status)
+	assert.Equal(t, 128+int(syscall.SIGTERM), state.ExitCode)
+	assert.Zero(t, state.RestartCount)
+	assert.True(t, state.ManualStop)
+	assert.Zero(t, state.SupervisorPid)
+}
+
+func TestReleaseSupervisedContainer(t *testing.T) {
+	containerDir := t.TempDir()
+	confPath := filepath.Join(containerDir, "ruri.conf")
+	// A supervisor waiting to restart the container, with no ruri running.
+	supervisor := exec.Command("sh", "-c", "sleep 30", "supervise")
+	require.NoError(t, supervisor.Start())
+	defer func() {
+		_ = supervisor.Process.Kill()
+		_ = supervisor.Wait()
+	}()
+	require.NoError(t, updateContainerState(containerDir, func(s *containerState) error {
+		s.SupervisorPid = supervisor.Process.Pid
+		return nil
+	}))
+	err := releaseContainer(filepath.Join(t.TempDir(), "ruri"), confPath, "app001")
+	assert.ErrorContains(t, err, "restart policy, stop it first")
+}
@@ -0,0 +1,673 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/transports"
+	"github.com/containers/image/v5/transports/alltransports"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// syncOptions contains information retrieved from the sync command line.
+type syncOptions struct {
+	global              *globalOptions
+	deprecatedTLSVerify *deprecatedTLSVerifyOption
+	srcImage            *imageOptions // Source image options
+	retryOpts           *retry.Options
+	removeSignatures    bool              // Do not copy signatures from the source image
+	source              string            // Source type, yaml or docker
+	digestFile          string            // Write digests to this file
+	all                 bool              // Copy all of the images if an image in the source is a list
+	platforms           []string          // Copy only the images for these platforms if an image in the source is a list
+	dryRun              bool              // Don't actually copy anything, just output what it would have done
+	keepGoing           bool              // Whether or not to abort the sync if there are any errors during syncing the images
+	mirrors             []*registryMirror // Docker Hub mirrors to try, in order
+}
+
+// repoDescriptor contains information of a single repository used as a sync source.
+type repoDescriptor struct {
+	ImageRefs []reference.Named    // List of tagged or digested images found for the repository
+	Context   *types.SystemContext // SystemContext for the sync command
+}
+
+// tlsVerifyConfig is an implementation of the Unmarshaler interface, used to
+// customize the unmarshaling behaviour of the tls-verify YAML key.
+type tlsVerifyConfig struct {
+	skip types.OptionalBool // skip TLS verification check (false by default)
+}
+
+// registrySyncConfig contains information about a single registry, read from
+// the source YAML file
+type registrySyncConfig struct {
+	Images           map[string][]string    // Images map images name to slices with the images' references (tags, digests)
+	ImagesByTagRegex map[string]string      `yaml:"images-by-tag-regex"` // Images map images name to regular expression with the images' tags
+	ImagesBySemver   map[string]string      `yaml:"images-by-semver"`    // ImagesBySemver maps a repository to a semver constraint (e.g. '>=3.14') to match images' tags to
+	Credentials      types.DockerAuthConfig // Username and password used to authenticate with the registry
+	TLSVerify        tlsVerifyConfig        `yaml:"tls-verify"` // TLS verification mode (enabled by default)
+	CertDir          string                 `yaml:"cert-dir"`   // Path to the TLS certificates of the registry
+}
+
+// sourceConfig contains all registries information read from the source YAML file
+type sourceConfig map[string]registrySyncConfig
+
+func syncCmd(global *globalOptions) *cobra.Command {
+	sharedFlags, sharedOpts := sharedImageFlags()
+	deprecatedTLSVerifyFlags, deprecatedTLSVerifyOpt := deprecatedTLSVerifyFlags()
+	srcFlags, srcOpts := dockerImageFlags(global, sharedOpts, deprecatedTLSVerifyOpt, "src-", "screds")
+	retryFlags, retryOpts := retryFlags()
+
+	opts := syncOptions{
+		global:              global,
+		deprecatedTLSVerify: deprecatedTLSVerifyOpt,
+		srcImage:            srcOpts,
+		retryOpts:           retryOpts,
+	}
+
+	cmd := &cobra.Command{
+		Use:   "sync [--src yaml|docker] SOURCE DIR",
+		Short: "mirror images from registries into the OCI layout DIR, for pulling them offline",
+		Long: `Copy the images listed in the YAML file SOURCE, or the repository or image SOURCE with --src docker,
+into the OCI layout DIR, e.g. on a USB stick. Each image is kept under its full name, so that devices
+without network access can pull it with:
+
+  DockRoot pull oci:DIR:docker.io/library/alpine:3.19
+
+The YAML file maps registries to the images to copy, as skopeo sync does:
+
+  docker.io:
+    images:
+      library/alpine: ["3.19", "3.20"]   # Tags or digests; all tags if empty
+    images-by-semver:
+      library/redis: ">=7.2, <8"
+    images-by-tag-regex:
+      library/busybox: ^1\.36
+  myreg.local:5000:
+    tls-verify: false
+    credentials: {username: me, password: secret}
+    images:
+      app: [latest]
+
+Docker Hub images are fetched through the configured mirrors. Images and blobs already in DIR are not
+copied again, so running sync again only fetches what changed.`,
+		RunE: commandAction(opts.run),
+		Example: `DockRoot sync images.yaml /mnt/usb/images
+DockRoot sync --platform linux/arm64 --platform linux/arm/v7 images.yaml /mnt/usb/images
+DockRoot sync --src docker alpine:3.19 /mnt/usb/images`,
+	}
+	flags := cmd.Flags()
+	flags.BoolVar(&opts.removeSignatures, "remove-signatures", false, "Do not copy signatures from SOURCE images")
+	flags.StringVarP(&opts.source, "src", "s", "yaml", "SOURCE type, yaml or docker")
+	flags.StringVar(&opts.digestFile, "digestfile", "", "Write the digests and names of the resulting images to the specified file, separated by newlines")
+	flags.BoolVarP(&opts.all, "all", "a", false, "Copy all images if a SOURCE image is a list")
+	flags.StringArrayVar(&opts.platforms, "platform", nil, "Copy the image for `OS/ARCH[/VARIANT]` if a SOURCE image is a list (can be repeated)")
+	flags.BoolVar(&opts.dryRun, "dry-run", false, "Run without actually copying data")
+	flags.BoolVarP(&opts.keepGoing, "keep-going", "", false, "Do not abort the sync if any image copy fails")
+	flags.AddFlagSet(&sharedFlags)
+	flags.AddFlagSet(&deprecatedTLSVerifyFlags)
+	flags.AddFlagSet(&srcFlags)
+	flags.AddFlagSet(&retryFlags)
+	return cmd
+}
+
+// UnmarshalYAML is the implementation of the Unmarshaler interface method
+// for the tlsVerifyConfig type.
+// It unmarshals the 'tls-verify' YAML key so that, when they key is not
+// specified, tls verification is enforced.
+func (tls *tlsVerifyConfig) UnmarshalYAML(value *yaml.Node) error {
+	var verify bool
+	if err := value.Decode(&verify); err != nil {
+		return err
+	}
+
+	tls.skip = types.NewOptionalBool(!verify)
+	return nil
+}
+
+// newSourceConfig unmarshals the provided YAML file path to the sourceConfig type.
+// It returns a new unmarshaled sourceConfig object and any error encountered.
+func newSourceConfig(yamlFile string) (sourceConfig, error) {
+	var cfg sourceConfig
+	source, err := os.ReadFile(yamlFile)
+	if err != nil {
+		return cfg, err
+	}
+	err = yaml.Unmarshal(source, &cfg)
+	if err != nil {
+		return cfg, fmt.Errorf("Failed to unmarshal %q: %w", yamlFile, err)
+	}
+	return cfg, nil
+}
+
+// parseRepositoryReference parses input into a reference.Named, and verifies that it names a repository, not an image.
+func parseRepositoryReference(input string) (reference.Named, error) {
+	ref, err := reference.ParseNormalizedNamed(input)
+	if err != nil {
+		return nil, err
+	}
+	if !reference.IsNameOnly(ref) {
+		return nil, errors.New("input names a reference, not a repository")
+	}
+	return ref, nil
+}
+
+// imagesToCopyFromRepo builds a list of image references from the tags
+// found in a source repository.
+// Docker Hub repositories are listed through the configured mirrors, as list-tags does.
+func (opts *syncOptions) imagesToCopyFromRepo(ctx context.Context, sys *types.SystemContext, repoRef reference.Named) ([]reference.Named, error) {
+	logrus.WithFields(logrus.Fields{
+		"image": repoRef.Name(),
+	}).Info("Getting tags")
+	_, tags, err := listDockerRepoTags(ctx, sys, &tagsOptions{retryOpts: opts.retryOpts, mirrors: opts.mirrors}, docker.Transport.Name()+"://"+repoRef.Name())
+	if err != nil {
+		return nil, fmt.Errorf("Error determining repository tags for repo %s: %w", repoRef.Name(), err)
+	}
+
+	var sourceReferences []reference.Named
+	for _, tag := range tags {
+		taggedRef, err := reference.WithTag(repoRef, tag)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"repo": repoRef.Name(),
+				"tag":  tag,
+			}).Errorf("Error creating a tagged reference from registry tag list: %v", err)
+			continue
+		}
+		sourceReferences = append(sourceReferences, taggedRef)
+	}
+	return sourceReferences, nil
+}
+
+// imagesToCopyFromRegistry builds a list of repository descriptors from the images
+// in a registry configuration.
+// It returns a repository descriptors slice with as many elements as the images
+// found and any error encountered. Each element of the slice is a list of
+// image references, to be used as sync source.
+func (opts *syncOptions) imagesToCopyFromRegistry(ctx context.Context, registryName string, cfg registrySyncConfig, sourceCtx types.SystemContext) ([]repoDescriptor, error) {
+	serverCtx := &sourceCtx
+	// override ctx with per-registryName options
+	serverCtx.DockerCertPath = cfg.CertDir
+	serverCtx.DockerDaemonCertPath = cfg.CertDir
+	serverCtx.DockerDaemonInsecureSkipTLSVerify = (cfg.TLSVerify.skip == types.OptionalBoolTrue)
+	serverCtx.DockerInsecureSkipTLSVerify = cfg.TLSVerify.skip
+	if cfg.Credentials != (types.DockerAuthConfig{}) {
+		serverCtx.DockerAuthConfig = &cfg.Credentials
+	}
+	var repoDescList []repoDescriptor
+
+	if len(cfg.Images) == 0 && len(cfg.ImagesByTagRegex) == 0 && len(cfg.ImagesBySemver) == 0 {
+		logrus.WithFields(logrus.Fields{
+			"registry": registryName,
+		}).Warn("No images specified for registry")
+		return repoDescList, nil
+	}
+
+	for _, imageName := range slices.Sorted(maps.Keys(cfg.Images)) {
+		refs := cfg.Images[imageName]
+		repoLogger := logrus.WithFields(logrus.Fields{
+			"repo":     imageName,
+			"registry": registryName,
+		})
+		repoRef, err := parseRepositoryReference(fmt.Sprintf("%s/%s", registryName, imageName))
+		if err != nil {
+			repoLogger.Error("Error parsing repository name, skipping")
+			logrus.Error(err)
+			continue
+		}
+
+		repoLogger.Info("Processing repo")
+
+		var sourceReferences []reference.Named
+		if len(refs) != 0 {
+			for _, ref := range refs {
+				tagLogger := logrus.WithFields(logrus.Fields{"ref": ref})
+				var named reference.Named
+				// first try as digest
+				if d, err := digest.Parse(ref); err == nil {
+					named, err = reference.WithDigest(repoRef, d)
+					if err != nil {
+						tagLogger.Error("Error processing ref, skipping")
+						logrus.Error(err)
+						continue
+					}
+				} else {
+					tagLogger.Debugf("Ref was not a digest, trying as a tag: %s", err)
+					named, err = reference.WithTag(repoRef, ref)
+					if err != nil {
+						tagLogger.Error("Error parsing ref, skipping")
+						logrus.Error(err)
+						continue
+					}
+				}
+				sourceReferences = append(sourceReferences, named)
+			}
+		} else { // len(refs) == 0
+			repoLogger.Info("Querying registry for image tags")
+			sourceReferences, err = opts.imagesToCopyFromRepo(ctx, serverCtx, repoRef)
+			if err != nil {
+				repoLogger.Error("Error processing repo, skipping")
+				logrus.Error(err)
+				continue
+			}
+		}
+
+		if len(sourceReferences) == 0 {
+			repoLogger.Warnf("No refs to sync found")
+			continue
+		}
+		repoDescList = append(repoDescList, repoDescriptor{
+			ImageRefs: sourceReferences,
+			Context:   serverCtx})
+	}
+
+	// include repository descriptors for cfg.ImagesByTagRegex
+	{
+		filterCollection, err := tagRegexFilterCollection(cfg.ImagesByTagRegex)
+		if err != nil {
+			logrus.Error(err)
+		} else {
+			additionalRepoDescList := opts.filterSourceReferences(ctx, serverCtx, registryName, filterCollection)
+			repoDescList = append(repoDescList, additionalRepoDescList...)
+		}
+	}
+
+	// include repository descriptors for cfg.ImagesBySemver
+	{
+		filterCollection, err := semverFilterCollection(cfg.ImagesBySemver)
+		if err != nil {
+			logrus.Error(err)
+		} else {
+			additionalRepoDescList := opts.filterSourceReferences(ctx, serverCtx, registryName, filterCollection)
+			repoDescList = append(repoDescList, additionalRepoDescList...)
+		}
+	}
+
+	return repoDescList, nil
+}
+
+// filterFunc is a function used to limit the initial set of image references
+// using tags, patterns, semver, etc.
+type filterFunc func(*logrus.Entry, reference.Named) bool
+
+// filterCollection is a map of repository names to filter functions.
+type filterCollection map[string]filterFunc
+
+// filterSourceReferences lists tags for images specified in the collection and
+// filters them using assigned filter functions.
+// It returns a list of repoDescriptors.
+func (opts *syncOptions) filterSourceReferences(ctx context.Context, sys *types.SystemContext, registryName string, collection filterCollection) []repoDescriptor {
+	var repoDescList []repoDescriptor
+	for _, repoName := range slices.Sorted(maps.Keys(collection)) {
+		filter := collection[repoName]
+		logger := logrus.WithFields(logrus.Fields{
+			"repo":     repoName,
+			"registry": registryName,
+		})
+
+		repoRef, err := parseRepositoryReference(fmt.Sprintf("%s/%s", registryName, repoName))
+		if err != nil {
+			logger.Error("Error parsing repository name, skipping")
+			logrus.Error(err)
+			continue
+		}
+
+		logger.Info("Processing repo")
+
+		logger.Info("Querying registry for image tags")
+		sourceReferences, err := opts.imagesToCopyFromRepo(ctx, sys, repoRef)
+		if err != nil {
+			logger.Error("Error processing repo, skipping")
+			logrus.Error(err)
+			continue
+		}
+
+		var filteredSourceReferences []reference.Named
+		for _, ref := range sourceReferences {
+			if filter(logger, ref) {
+				filteredSourceReferences = append(filteredSourceReferences, ref)
+			}
+		}
+
+		if len(filteredSourceReferences) == 0 {
+			logger.Warnf("No refs to sync found")
+			continue
+		}
+
+		repoDescList = append(repoDescList, repoDescriptor{
+			ImageRefs: filteredSourceReferences,
+			Context:   sys,
+		})
+	}
+	return repoDescList
+}
+
+// tagRegexFilterCollection converts a map of (repository name, tag regex) pairs
+// into a filterCollection, which is a map of (repository name, filter function)
+// pairs.
+func tagRegexFilterCollection(collection map[string]string) (filterCollection, error) {
+	filters := filterCollection{}
+
+	for repoName, tagRegex := range collection {
+		pattern, err := regexp.Compile(tagRegex)
+		if err != nil {
+			return nil, err
+		}
+
+		f := func(logger *logrus.Entry, sourceReference reference.Named) bool {
+			tagged, isTagged := sourceReference.(reference.Tagged)
+			if !isTagged {
+				logger.Errorf("Internal error, reference %s does not have a tag, skipping", sourceReference)
+				return false
+			}
+			return pattern.MatchString(tagged.Tag())
+		}
+		filters[repoName] = f
+	}
+
+	return filters, nil
+}
+
+// semverFilterCollection converts a map of (repository name, array of semver constraints) pairs
+// into a filterCollection, which is a map of (repository name, filter function)
+// pairs.
+func semverFilterCollection(collection map[string]string) (filterCollection, error) {
+	filters := filterCollection{}
+
+	for repoName, constraintString := range collection {
+		constraint, err := semver.NewConstraint(constraintString)
+		if err != nil {
+			return nil, err
+		}
+
+		f := func(logger *logrus.Entry, sourceReference reference.Named) bool {
+			tagged, isTagged := sourceReference.(reference.Tagged)
+			if !isTagged {
+				logger.Errorf("Internal error, reference %s does not have a tag, skipping", sourceReference)
+				return false
+			}
+			tagVersion, err := semver.NewVersion(tagged.Tag())
+			if err != nil {
+				logger.Tracef("Tag %q cannot be parsed as semver, skipping", tagged.Tag())
+				return false
+			}
+			return constraint.Check(tagVersion)
+		}
+
+		filters[repoName] = f
+	}
+
+	return filters, nil
+}
+
+// imagesToCopy retrieves all the images to copy from a specified sync source.
+// It returns a slice of repository descriptors, where each descriptor is a
+// list of image references to be used as sync source, and any error
+// encountered.
+func (opts *syncOptions) imagesToCopy(ctx context.Context, source string, sourceType string, sourceCtx *types.SystemContext) ([]repoDescriptor, error) {
+	var descriptors []repoDescriptor
+
+	switch sourceType {
+	case docker.Transport.Name():
+		desc := repoDescriptor{
+			Context: sourceCtx,
+		}
+		named, err := reference.ParseNormalizedNamed(source) // May be a repository or an image.
+		if err != nil {
+			return nil, fmt.Errorf("Cannot obtain a valid image reference for transport %q and reference %q: %w", docker.Transport.Name(), source, err)
+		}
+		if !reference.IsNameOnly(named) {
+			desc.ImageRefs = []reference.Named{named}
+		} else {
+			desc.ImageRefs, err = opts.imagesToCopyFromRepo(ctx, sourceCtx, named)
+			if err != nil {
+				return descriptors, err
+			}
+			if len(desc.ImageRefs) == 0 {
+				return descriptors, fmt.Errorf("No images to sync found in %q", source)
+			}
+		}
+		descriptors = append(descriptors, desc)
+
+	case "yaml":
+		cfg, err := newSourceConfig(source)
+		if err != nil {
+			return descriptors, err
+		}
+		for _, registryName := range slices.Sorted(maps.Keys(cfg)) {
+			descs, err := opts.imagesToCopyFromRegistry(ctx, registryName, cfg[registryName], *sourceCtx)
+			if err != nil {
+				return descriptors, fmt.Errorf("Failed to retrieve list of images from registry %q: %w", registryName, err)
+			}
+			descriptors = append(descriptors, descs...)
+		}
+	}
+
+	return descriptors, nil
+}
+
+// chooseInstances returns the instances of the image list srcRef points to which match platforms,
+// or nil if srcRef is a single image.
+func chooseInstances(ctx context.Context, sys *types.SystemContext, srcRef types.ImageReference, platforms []platform) (_ []digest.Digest, retErr error) {
+	src, err := srcRef.NewImageSource(ctx, sys)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := src.Close(); err != nil {
+			retErr = noteCloseFailure(retErr, "closing image source", err)
+		}
+	}()
+	manifestBytes, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !manifest.MIMETypeIsMultiImage(mimeType) {
+		return nil, nil
+	}
+	list, err := manifest.ListFromBlob(manifestBytes, mimeType)
+	if err != nil {
+		return nil, err
+	}
+	var instances []digest.Digest
+	for _, p := range platforms {
+		instance, err := list.ChooseInstance(&types.SystemContext{OSChoice: p.os, ArchitectureChoice: p.arch, VariantChoice: p.variant})
+		if err != nil {
+			logrus.Warnf("Skipping %s for %s: %v", p, transports.ImageName(srcRef), err)
+			continue
+		}
+		if !slices.Contains(instances, instance) {
+			instances = append(instances, instance)
+		}
+	}
+	if len(instances) == 0 {
+		return nil, fmt.Errorf("%s has no image for %s", transports.ImageName(srcRef), platformsString(platforms))
+	}
+	return instances, nil
+}
+
+// syncImages copies the images of repos into the OCI layout destDir, each under its full name.
+// Blobs are shared by all images in destDir, so ones which are already there are not copied again.
+func (opts *syncOptions) syncImages(ctx context.Context, policyContext *signature.PolicyContext, repos []repoDescriptor, destDir string, options *copy.Options, platforms []platform, digestFile io.Writer) error {
+	errorsPresent := false
+	imagesNumber := 0
+	for _, srcRepo := range repos {
+		for counter, named := range srcRepo.ImageRefs {
+			destRef, err := layout.NewReference(destDir, named.String())
+			if err != nil {
+				return err
+			}
+			if opts.dryRun {
+				fmt.Fprintf(options.ReportWriter, "Would have copied %s (%d/%d)\n", named, counter+1, len(srcRepo.ImageRefs))
+				imagesNumber++
+				continue
+			}
+			fmt.Fprintf(options.ReportWriter, "Copying %s (%d/%d)\n", named, counter+1, len(srcRepo.ImageRefs))
+			imageRef, err := parseImageReference(named.String())
+			if err != nil {
+				return err
+			}
+			var manifestBytes []byte
+			err = tryImageSources(ctx, imageRef.sourceCandidates(opts.mirrors), imageRef.String(), func(c imageSourceCandidate) error {
+				srcRef, err := alltransports.ParseImageName(c.name)
+				if err != nil {
+					return fmt.Errorf("Invalid source name %s: %v", c.name, err)
+				}
+				sourceCtx := *srcRepo.Context
+				c.updateSystemContext(&sourceCtx)
+				return retry.IfNecessary(ctx, func() error {
+					copyOptions := *options
+					copyOptions.SourceCtx = &sourceCtx
+					if len(platforms) != 0 {
+						instances, err := chooseInstances(ctx, &sourceCtx, srcRef, platforms)
+						if err != nil {
+							return err
+						}
+						copyOptions.ImageListSelection = copy.CopySpecificImages
+						copyOptions.Instances = instances
+					}
+					manifestBytes, err = copy.Image(ctx, policyContext, destRef, srcRef, &copyOptions)
+					return err
+				}, opts.retryOpts)
+			})
+			if err != nil {
+				if !opts.keepGoing {
+					return fmt.Errorf("Error copying %s: %w", named, err)
+				}
+				// log the error, keep a note that there was a failure and move on to the next image
+				errorsPresent = true
+				logrus.WithError(err).Errorf("Error copying %s", named)
+				continue
+			}
+			// Ensure that we log the manifest digest to a file only if the copy operation was successful
+			if digestFile != nil {
+				manifestDigest, err := manifest.Digest(manifestBytes)
+				if err != nil {
+					return err
+				}
+				if _, err = fmt.Fprintf(digestFile, "%s %s\n", manifestDigest, named); err != nil {
+					return fmt.Errorf("Failed to write digest to file %q: %w", opts.digestFile, err)
+				}
+			}
+			imagesNumber++
+		}
+	}
+
+	if opts.dryRun {
+		fmt.Fprintf(options.ReportWriter, "Would have synced %d images from %d sources into %s\n", imagesNumber, len(repos), destDir)
+	} else {
+		fmt.Fprintf(options.ReportWriter, "Synced %d images from %d sources into %s\n", imagesNumber, len(repos), destDir)
+	}
+	if !errorsPresent {
+		return nil
+	}
+	return errors.New("Sync failed due to previous reported error(s) for one or more images")
+}
+
+// platformsString returns platforms in the form given to --platform, for messages.
+func platformsString(platforms []platform) string {
+	s := make([]string, len(platforms))
+	for i, p := range platforms {
+		s[i] = p.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+func (opts *syncOptions) run(args []string, stdout io.Writer) (retErr error) {
+	if len(args) != 2 {
+		return fmt.Errorf("Usage: %s sync [--src yaml|docker] SOURCE DIR", os.Args[0])
+	}
+	opts.deprecatedTLSVerify.warnIfUsed([]string{"--src-tls-verify"})
+
+	if !slices.Contains([]string{docker.Transport.Name(), "yaml"}, opts.source) {
+		return fmt.Errorf("%q is not a valid source type, must be yaml or docker", opts.source)
+	}
+	if opts.all && len(opts.platforms) != 0 {
+		return errors.New("--all and --platform can not be used together")
+	}
+	var platforms []platform
+	for _, s := range opts.platforms {
+		p, err := parsePlatform(s)
+		if err != nil {
+			return err
+		}
+		platforms = append(platforms, p)
+	}
+
+	if opts.global.policyPath == "" {
+		opts.global.insecurePolicy = true
+	}
+	policyContext, err := opts.global.getPolicyContext()
+	if err != nil {
+		return fmt.Errorf("Error loading trust policy: %v", err)
+	}
+	defer func() {
+		if err := policyContext.Destroy(); err != nil {
+			retErr = noteCloseFailure(retErr, "tearing down policy context", err)
+		}
+	}()
+
+	imageListSelection := copy.CopySystemImage
+	if opts.all {
+		imageListSelection = copy.CopyAllImages
+	}
+
+	sourceCtx, err := opts.srcImage.newSystemContext()
+	if err != nil {
+		return err
+	}
+	opts.mirrors = configuredHubMirrors()
+
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+
+	var srcRepoList []repoDescriptor
+	if err = retry.IfNecessary(ctx, func() error {
+		srcRepoList, err = opts.imagesToCopy(ctx, args[0], opts.source, sourceCtx)
+		return err
+	}, opts.retryOpts); err != nil {
+		return err
+	}
+
+	options := &copy.Options{
+		RemoveSignatures:                      opts.removeSignatures,
+		ReportWriter:                          stdout,
+		ImageListSelection:                    imageListSelection,
+		OptimizeDestinationImageAlreadyExists: true,
+		MaxParallelDownloads:                  2,
+	}
+
+	var digestFile io.Writer
+	if opts.digestFile != "" && !opts.dryRun {
+		f, err := os.OpenFile(opts.digestFile, os.O_TRUNC|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("Error creating digest file: %w", err)
+		}
+		defer func() {
+			if err := f.Close(); err != nil {
+				retErr = noteCloseFailure(retErr, "closing digest file", err)
+			}
+		}()
+		digestFile = f
+	}
+
+	return opts.syncImages(ctx, policyContext, srcRepoList, args[1], options, platforms, digestFile)
+}
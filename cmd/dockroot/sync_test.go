@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"maps"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/containers/common/pkg/retry"
+	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/docker/reference"
+	"github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSourceConfig(t *testing.T) {
+	yamlFile := filepath.Join(t.TempDir(), "images.yaml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte(`docker.io:
+  images:
+    library/alpine: ["3.19", "sha256:0000000000000000000000000000000000000000000000000000000000000000"]
+  images-by-semver:
+    library/redis: ">=7.2"
+myreg.local:5000:
+  tls-verify: false
+  cert-dir: /etc/certs
+  credentials: {username: me, password: secret}
+  images-by-tag-regex:
+    app: ^v1\.
+`), 0644))
+	cfg, err := newSourceConfig(yamlFile)
+	require.NoError(t, err)
+	require.Len(t, cfg, 2)
+	assert.Equal(t, []string{"3.19", "sha256:0000000000000000000000000000000000000000000000000000000000000000"}, cfg["docker.io"].Images["library/alpine"])
+	assert.Equal(t, map[string]string{"library/redis": ">=7.2"}, cfg["docker.io"].ImagesBySemver)
+	assert.Equal(t, types.OptionalBoolUndefined, cfg["docker.io"].TLSVerify.skip)
+	reg := cfg["myreg.local:5000"]
+	assert.Equal(t, types.OptionalBoolTrue, reg.TLSVerify.skip)
+	assert.Equal(t, "/etc/certs", reg.CertDir)
+	assert.Equal(t, types.DockerAuthConfig{Username: "me", Password: "secret"}, reg.Credentials)
+	assert.Equal(t, map[string]string{"app": `^v1\.`}, reg.ImagesByTagRegex)
+
+	require.NoError(t, os.WriteFile(yamlFile, []byte("docker.io: [not, a, map]\n"), 0644))
+	_, err = newSourceConfig(yamlFile)
+	assert.Error(t, err)
+}
+
+func TestSyncFilterCollections(t *testing.T) {
+	var refs []reference.Named
+	for _, tag := range []string{"1.0", "1.1.2", "2.0", "v1.5", "latest"} {
+		named, err := reference.WithTag(reference.TrimNamed(mustParseNamed(t, "docker.io/library/app")), tag)
+		require.NoError(t, err)
+		refs = append(refs, named)
+	}
+	matching := func(collection filterCollection) []string {
+		var tags []string
+		for _, ref := range refs {
+			if collection["app"](logrus.NewEntry(logrus.StandardLogger()), ref) {
+				tags = append(tags, ref.(reference.Tagged).Tag())
+			}
+		}
+		return tags
+	}
+
+	semverFilters, err := semverFilterCollection(map[string]string{"app": ">=1.1, <2"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.1.2", "v1.5"}, matching(semverFilters))
+	regexFilters, err := tagRegexFilterCollection(map[string]string{"app": `^\d+\.0$`})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0", "2.0"}, matching(regexFilters))
+
+	_, err = semverFilterCollection(map[string]string{"app": "not a range"})
+	assert.Error(t, err)
+	_, err = tagRegexFilterCollection(map[string]string{"app": "("})
+	assert.Error(t, err)
+}
+
+func mustParseNamed(t *testing.T, s string) reference.Named {
+	named, err := reference.ParseNormalizedNamed(s)
+	require.NoError(t, err)
+	return named
+}
+
+func TestSyncImages(t *testing.T) {
+	dataRoot := t.TempDir()
+	storeDir := imageStoreDir(dataRoot)
+	const refName = "docker.io/library/app:1.0"
+	addTestImage(t, storeDir, refName, map[string]string{"usr/bin/app": "v1"}, nil)
+	_, err := tagImageDigest(storeDir, refName)
+	require.NoError(t, err)
+	img, err := resolveStoreImage(storeDir, refName)
+	require.NoError(t, err)
+
+	registry := newTestRegistry()
+	server := httptest.NewServer(registry)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	sys := func() *types.SystemContext {
+		return &types.SystemContext{
+			DockerInsecureSkipTLSVerify: types.OptionalBoolTrue,
+			SystemRegistriesConfPath:    os.DevNull,
+			RegistriesDirPath:           t.TempDir(),
+		}
+	}
+
+	policyContext, err := signature.NewPolicyContext(&signature.Policy{Default: signature.PolicyRequirements{signature.NewPRInsecureAcceptAnything()}})
+	require.NoError(t, err)
+	defer policyContext.Destroy()
+	ctx := context.Background()
+	var manifestDigest digest.Digest
+	for _, tag := range []string{"1.0", "1.1", "2.0", "latest"} {
+		destRef, err := parsePushDestination(host + "/team/app:" + tag)
+		require.NoError(t, err)
+		manifestDigest, err = pushImage(ctx, policyContext, storeDir, img, destRef, &copy.Options{ReportWriter: io.Discard, DestinationCtx: sys()}, &retry.Options{})
+		require.NoError(t, err)
+	}
+	// A list with an amd64 image, and an arm64 image which the registry does not have.
+	pushed := registry.manifests["team/app:1.0"]
+	index, err := json.Marshal(imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: imgspecv1.MediaTypeImageIndex,
+		Manifests: []imgspecv1.Descriptor{
+			{MediaType: registry.types["team/app:1.0"], Digest: manifestDigest, Size: int64(len(pushed)), Platform: &imgspecv1.Platform{OS: "linux", Architecture: "amd64"}},
+			{MediaType: registry.types["team/app:1.0"], Digest: digest.FromString("missing"), Size: 10, Platform: &imgspecv1.Platform{OS: "linux", Architecture: "arm64"}},
+		},
+	})
+	require.NoError(t, err)
+	for _, k := range []string{"team/multi:1.0", "team/multi:" + digest.FromBytes(index).String()} {
+		registry.manifests[k] = index
+		registry.types[k] = imgspecv1.MediaTypeImageIndex
+	}
+	registry.manifests["team/multi:"+manifestDigest.String()] = pushed
+	registry.types["team/multi:"+manifestDigest.String()] = registry.types["team/app:1.0"]
+
+	yamlFile := filepath.Join(t.TempDir(), "images.yaml")
+	require.NoError(t, os.WriteFile(yamlFile, []byte(host+`:
+  tls-verify: false
+  images:
+    team/multi: ["1.0"]
+  images-by-semver:
+    team/app: ">=1.1"
+`), 0644))
+	opts := &syncOptions{retryOpts: &retry.Options{}}
+	repos, err := opts.imagesToCopy(ctx, yamlFile, "yaml", sys())
+	require.NoError(t, err)
+	var names []string
+	for _, repo := range repos {
+		for _, named := range repo.ImageRefs {
+			names = append(names, named.String())
+		}
+	}
+	assert.ElementsMatch(t, []string{host + "/team/multi:1.0", host + "/team/app:1.1", host + "/team/app:2.0"}, names)
+
+	destDir := filepath.Join(t.TempDir(), "images")
+	sync := func() {
+		var out, digests bytes.Buffer
+		options := &copy.Options{ReportWriter: &out, OptimizeDestinationImageAlreadyExists: true}
+		err := opts.syncImages(ctx, policyContext, repos, destDir, options, []platform{{os: "linux", arch: "amd64"}}, &digests)
+		require.NoError(t, err, out.String())
+		assert.Contains(t, out.String(), "Synced 3 images from 2 sources")
+		assert.Equal(t, 3, strings.Count(digests.String(), "\n"))
+	}
+	sync()
+	listed, err := layout.List(destDir)
+	require.NoError(t, err)
+	var synced []string
+	for _, l := range listed {
+		synced = append(synced, l.ManifestDescriptor.Annotations[imgspecv1.AnnotationRefName])
+	}
+	assert.ElementsMatch(t, names, synced)
+
+	// Running again finds the layers in place, and only reads image configs.
+	var m imgspecv1.Manifest
+	require.NoError(t, json.Unmarshal(pushed, &m))
+	require.NotEmpty(t, m.Layers)
+	fetches := maps.Clone(registry.blobFetches)
+	sync()
+	for _, layer := range m.Layers {
+		assert.Equal(t, fetches[layer.Digest], registry.blobFetches[layer.Digest])
+	}
+
+	// Without a platform in the list, the image is not copied.
+	opts.keepGoing = true
+	err = opts.syncImages(ctx, policyContext, repos[:1], destDir, &copy.Options{ReportWriter: io.Discard}, []platform{{os: "linux", arch: "riscv64"}}, nil)
+	assert.Error(t, err)
+}
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
 	rspec "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/opencontainers/umoci"
+	"github.com/opencontainers/umoci/mutate"
 	"github.com/opencontainers/umoci/oci/cas/dir"
 	"github.com/opencontainers/umoci/oci/casext"
 	"github.com/opencontainers/umoci/oci/layer"
 	"github.com/opencontainers/umoci/pkg/funchelpers"
 )
 
+// imageConfigFile keeps the OCI image config a container was unpacked from, next to its config.json.
+// Unlike config.json, it still tells the image's Entrypoint and Cmd apart.
+const imageConfigFile = "image-config.json"
+
 func unpack(ociDir, imageTag, destDir string) (Err error) {
 	engine, err := dir.Open(ociDir)
 	if err != nil {
@@ -27,7 +35,52 @@ func unpack(ociDir, imageTag, destDir string) (Err error) {
 	if err := umoci.Unpack(engineExt, imageTag, destDir, unpackOptions); err != nil {
 		return fmt.Errorf("unpack image: %w", err)
 	}
-	return nil
+	img, err := readImageConfig(engineExt, imageTag)
+	if err != nil {
+		return err
+	}
+	return saveImageConfig(filepath.Join(destDir, imageConfigFile), img)
+}
+
+// readImageConfig returns the OCI image config of imageTag.
+func readImageConfig(engineExt casext.Engine, imageTag string) (*imgspecv1.Image, error) {
+	ctx := context.Background()
+	paths, err := engineExt.ResolveReference(ctx, imageTag)
+	if err != nil {
+		return nil, fmt.Errorf("get descriptor: %w", err)
+	}
+	if len(paths) != 1 {
+		return nil, fmt.Errorf("tag is not found or ambiguous: %s", imageTag)
+	}
+	mutator, err := mutate.New(engineExt, paths[0])
+	if err != nil {
+		return nil, err
+	}
+	img, err := mutator.Config(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get image config: %w", err)
+	}
+	return &img, nil
+}
+
+func getImageConfig(path string) (*imgspecv1.Image, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var img imgspecv1.Image
+	if err := json.Unmarshal(data, &img); err != nil {
+		return nil, err
+	}
+	return &img, nil
+}
+
+func saveImageConfig(path string, img *imgspecv1.Image) error {
+	data, err := json.MarshalIndent(img, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
 }
 
 func getSpecConfig(path string) (*rspec.Spec, error) {
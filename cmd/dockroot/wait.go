@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// containerWaitInterval is how often wait looks at the state of a container.
+const containerWaitInterval = 250 * time.Millisecond
+
+type waitOptions struct {
+	global *globalOptions
+}
+
+func waitCmd(global *globalOptions) *cobra.Command {
+	opts := waitOptions{global: global}
+	cmd := &cobra.Command{
+		Use:   "wait NAME...",
+		Short: "wait for containers to stop, then print their exit codes",
+		Long: `Block until each container NAME stops, and no restart policy is going to start it again, then print
+its exit code. A container which is not running is not waited for.`,
+		RunE:    commandAction(opts.run),
+		Example: `DockRoot wait alpine001`,
+	}
+	return cmd
+}
+
+func (opts *waitOptions) run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("Usage: %s wait NAME...", os.Args[0])
+	}
+	binaryDir, err := getBinaryDir()
+	if err != nil {
+		return err
+	}
+	info, err := opts.global.readRegistryInfo(binaryDir)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := opts.global.commandTimeoutContext()
+	defer cancel()
+	for _, name := range args {
+		destAbsDir, err := filepath.Abs(filepath.Join(info.DataRoot, CleanString(name)))
+		if err != nil {
+			return err
+		}
+		if !isDirValid(destAbsDir) {
+			return fmt.Errorf("No such container: %s", name)
+		}
+		state, err := waitContainer(ctx, destAbsDir)
+		if err != nil {
+			return fmt.Errorf("Waiting for %s: %w", name, err)
+		}
+		fmt.Fprintln(stdout, state.ExitCode)
+	}
+	return nil
+}
+
+// waitContainer waits until the container in containerDir is not running, and has no supervisor which may
+// restart it, and returns its state then.
+func waitContainer(ctx context.Context, containerDir string) (*containerState, error) {
+	for {
+		state, err := readContainerState(containerDir)
+		if err != nil {
+			return nil, err
+		}
+		if state = state.current(); state.Status != containerRunning && !state.supervised() {
+			return state, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(containerWaitInterval):
+		}
+	}
+}
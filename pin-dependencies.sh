@@ -0,0 +1,37 @@
+#!/bin/bash
+
+# Pins a ruri or kspeeder release in cmd/dockroot/dependencies.json: downloads it for each architecture
+# and records its version and SHA-256 digests.
+# Usage: ./pin-dependencies.sh ruri|kspeeder VERSION [ARCH...]
+
+set -euo pipefail
+
+if [ $# -lt 2 ]; then
+  echo "Usage: $0 ruri|kspeeder VERSION [ARCH...]" >&2
+  exit 1
+fi
+
+name=$1
+version=$2
+shift 2
+archs=${*:-"amd64 arm64 armv7 riscv64"}
+
+manifest=cmd/dockroot/dependencies.json
+url=$(jq -er --arg name "$name" '.[$name].url' "$manifest")
+
+tmp=$(mktemp -d)
+trap 'rm -rf "$tmp"' EXIT
+
+digests='{}'
+for ARCH in $archs; do
+  asset=${url//\{version\}/$version}
+  asset=${asset//\{arch\}/$ARCH}
+  echo "Downloading $asset"
+  curl -fsSL -o "$tmp/$name.$ARCH" "$asset"
+  digest=$(sha256sum "$tmp/$name.$ARCH" | cut -d' ' -f1)
+  digests=$(jq --arg arch "$ARCH" --arg digest "$digest" '.[$arch] = $digest' <<<"$digests")
+done
+
+jq --arg name "$name" --arg version "$version" --argjson digests "$digests" \
+  '.[$name].version = $version | .[$name].sha256 = $digests' "$manifest" >"$tmp/dependencies.json"
+mv "$tmp/dependencies.json" "$manifest"